@@ -6,11 +6,13 @@ import (
 	_ "net/http/pprof"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"github.com/spf13/pflag"
 
 	"github.com/vleukhin/prom-light/internal/config"
 	"github.com/vleukhin/prom-light/internal/server"
@@ -34,6 +36,13 @@ func main() {
 
 	zerolog.SetGlobalLevel(logLevel)
 
+	if args := pflag.Args(); len(args) > 0 {
+		if err := runCommand(cfg, args); err != nil {
+			log.Fatal().Err(err).Msg("Command failed")
+		}
+		return
+	}
+
 	app, err := server.NewApp(cfg)
 	if err != nil {
 		log.Fatal().Err(err).Msg("Failed to create app")
@@ -63,6 +72,28 @@ func main() {
 	}
 }
 
+// runCommand выполняет подкоманду сервера вместо запуска сервера:
+// migrate [up | down [N] | status] - применение, откат N последних миграций или версия схемы PostgreSQL
+func runCommand(cfg *config.ServerConfig, args []string) error {
+	if args[0] != "migrate" {
+		return fmt.Errorf("unknown command: %s", args[0])
+	}
+
+	command := server.MigrateUp
+	if len(args) > 1 {
+		command = args[1]
+	}
+	steps := 1
+	if len(args) > 2 {
+		var err error
+		if steps, err = strconv.Atoi(args[2]); err != nil {
+			return fmt.Errorf("bad number of migrations: %s", args[2])
+		}
+	}
+
+	return server.Migrate(context.Background(), cfg, command, steps)
+}
+
 func printIntro() {
 	fmt.Println("PromLight Server")
 	fmt.Println("----------------")
@@ -5,7 +5,9 @@ go 1.18
 require (
 	github.com/caarlos0/env/v6 v6.9.1
 	github.com/go-critic/go-critic v0.6.4
+	github.com/golang/snappy v0.0.4
 	github.com/gorilla/mux v1.8.0
+	github.com/jackc/pgconn v1.13.0
 	github.com/jackc/pgx/v4 v4.17.0
 	github.com/pkg/errors v0.9.1
 	github.com/rs/zerolog v1.26.1
@@ -14,6 +16,7 @@ require (
 	github.com/stretchr/testify v1.8.0
 	golang.org/x/tools v0.1.12
 	google.golang.org/grpc v1.49.0
+	google.golang.org/protobuf v1.27.1
 	honnef.co/go/tools v0.3.3
 )
 
@@ -29,7 +32,6 @@ require (
 	github.com/go-toolsmith/typep v1.0.2 // indirect
 	github.com/golang/protobuf v1.5.2 // indirect
 	github.com/jackc/chunkreader/v2 v2.0.1 // indirect
-	github.com/jackc/pgconn v1.13.0 // indirect
 	github.com/jackc/pgio v1.0.0 // indirect
 	github.com/jackc/pgpassfile v1.0.0 // indirect
 	github.com/jackc/pgproto3/v2 v2.3.1 // indirect
@@ -51,6 +53,5 @@ require (
 	golang.org/x/sys v0.0.0-20220722155257-8c9f86f7a55f // indirect
 	golang.org/x/text v0.3.7 // indirect
 	google.golang.org/genproto v0.0.0-20200526211855-cb27e3aa2013 // indirect
-	google.golang.org/protobuf v1.27.1 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -7,6 +7,7 @@ import (
 	"hash"
 	mrand "math/rand"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
@@ -18,13 +19,16 @@ import (
 	"github.com/rs/zerolog/log"
 
 	"github.com/vleukhin/prom-light/internal/metrics"
+	"github.com/vleukhin/prom-light/internal/outbox"
 	"github.com/vleukhin/prom-light/internal/pollers"
 	"github.com/vleukhin/prom-light/internal/storage"
 )
 
-type Poller interface {
-	// Poll сбор метрик
-	Poll() (metrics.Metrics, error)
+// scheduledPoller сборщик метрик со своим интервалом опроса
+type scheduledPoller struct {
+	name     string
+	poller   pollers.Poller
+	interval time.Duration
 }
 
 type Client interface {
@@ -37,12 +41,14 @@ type Client interface {
 type App struct {
 	storage      storage.MetricsStorage
 	reportTicker *time.Ticker
-	pollTicker   *time.Ticker
 	client       Client
 	cfg          *config.AgentConfig
-	pollers      []Poller
+	pollers      []scheduledPoller
 	hasher       hash.Hash
 	cancel       context.CancelFunc
+	reportMutex  sync.Mutex
+	counters     *counterTracker
+	outbox       *outbox.Outbox
 }
 
 // NewApp создаёт новый агент для сбора метрик
@@ -55,23 +61,70 @@ func NewApp(config *config.AgentConfig) (*App, error) {
 	}
 
 	agent := App{
-		storage:      storage.NewMemoryStorage(),
+		storage:      storage.NewMemoryStorage(0),
 		reportTicker: time.NewTicker(config.ReportInterval.Duration),
-		pollTicker:   time.NewTicker(config.PollInterval.Duration),
 		client:       client,
 		cfg:          config,
+		counters:     newCounterTracker(),
+	}
+
+	if config.OutboxDir != "" {
+		agent.outbox, err = outbox.Open(config.OutboxDir, outbox.Limits{
+			MaxBytes: config.OutboxMaxSize,
+			MaxAge:   config.OutboxMaxAge.Duration,
+		}, agent.countOutboxDrops)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to open outbox")
+		}
 	}
 
 	if config.Key != "" {
 		agent.hasher = hmac.New(sha256.New, []byte(config.Key))
 	}
 
-	agent.pollers = append(agent.pollers, pollers.MemStatsPoller{})
-	agent.pollers = append(agent.pollers, pollers.PsPoller{})
+	agent.pollers, err = newPollers(config)
+	if err != nil {
+		return nil, err
+	}
 
 	return &agent, nil
 }
 
+// newPollers создает выбранные в конфиге сборщики. Если сборщики не выбраны,
+// например конфиг собран без Parse, создаются сборщики по умолчанию
+func newPollers(cfg *config.AgentConfig) ([]scheduledPoller, error) {
+	configured := cfg.Pollers
+	if len(configured) == 0 {
+		for _, name := range config.DefaultPollers {
+			configured = append(configured, config.PollerConfig{Name: name})
+		}
+	}
+
+	scheduled := make([]scheduledPoller, 0, len(configured))
+	seen := make(map[string]bool, len(configured))
+	for _, pc := range configured {
+		if seen[pc.Name] {
+			return nil, errors.Errorf("poller %q configured twice", pc.Name)
+		}
+		seen[pc.Name] = true
+
+		p, err := pollers.New(pc.Name, pc.Options)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to create poller")
+		}
+		interval := pc.Interval.Duration
+		if interval == 0 {
+			interval = cfg.PollInterval.Duration
+		}
+		if interval <= 0 {
+			return nil, errors.Errorf("poller %q: poll interval must be positive", pc.Name)
+		}
+		scheduled = append(scheduled, scheduledPoller{name: pc.Name, poller: p, interval: interval})
+	}
+
+	return scheduled, nil
+}
+
 func newClient(cfg *config.AgentConfig) (Client, error) {
 	var client Client
 	addr, err := detectIP()
@@ -84,11 +137,20 @@ func newClient(cfg *config.AgentConfig) (Client, error) {
 		return nil, err
 	}
 
+	tlsCfg, err := tlsConfig(cfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to configure TLS")
+	}
+
+	retry := retryPolicy(cfg)
 	switch cfg.Protocol {
 	case config.ProtocolHTTP:
-		client = NewHTTPClient(cfg.ServerAddr, addr.IP, cfg.ReportTimeout.Duration, key)
+		client = NewHTTPClient(cfg.ServerAddr, addr.IP, cfg.ReportTimeout.Duration, key, tlsCfg, retry)
 	case config.ProtocolGRPC:
-		client, err = NewGRPCClient(cfg.ServerAddr)
+		if tlsCfg == nil && key != nil {
+			log.Warn().Msg("gRPC transport does not use crypto key, configure TLS to encrypt traffic")
+		}
+		client, err = NewGRPCClient(cfg.ServerAddr, addr.IP, tlsCfg, retry)
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to create GRPC client")
 		}
@@ -99,6 +161,22 @@ func newClient(cfg *config.AgentConfig) (Client, error) {
 	return client, nil
 }
 
+// retryPolicy создает политику повторов из конфига. Повторы не должны выходить
+// за интервал отправки, иначе следующая отправка будет ждать завершения текущей
+func retryPolicy(cfg *config.AgentConfig) RetryPolicy {
+	policy := RetryPolicy{
+		MaxAttempts:     cfg.RetryMaxAttempts,
+		InitialInterval: cfg.RetryInitialInterval.Duration,
+		MaxInterval:     cfg.RetryMaxInterval.Duration,
+		MaxElapsedTime:  cfg.RetryMaxElapsedTime.Duration,
+	}
+	if report := cfg.ReportInterval.Duration; report > 0 && (policy.MaxElapsedTime == 0 || policy.MaxElapsedTime > report) {
+		policy.MaxElapsedTime = report
+	}
+
+	return policy
+}
+
 // Start запускает сбор и отправку метрик
 func (c *App) Start(ctx context.Context, cancel context.CancelFunc) {
 	log.Info().Msgf("%s agent started", c.cfg.Protocol)
@@ -121,30 +199,48 @@ reportLoop:
 	c.Stop(ctx)
 }
 
+// poll запускает каждый сборщик в своей горутине со своим интервалом,
+// чтобы медленный или сломанный сборщик не задерживал остальные
 func (c *App) poll(ctx context.Context, metricsCh chan<- metrics.Metrics) {
-	defer func() {
-		if r := recover(); r != nil {
-			log.Error().Msgf("poll() panics: %v", r)
-			c.Stop(ctx)
-		}
-	}()
+	for _, p := range c.pollers {
+		go c.runPoller(ctx, p, metricsCh)
+	}
+}
+
+func (c *App) runPoller(ctx context.Context, p scheduledPoller, metricsCh chan<- metrics.Metrics) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case <-c.pollTicker.C:
-			for _, p := range c.pollers {
-				mtrcs, err := p.Poll()
-				if err != nil {
-					log.Error().Err(err).Msgf("Failed to poll metrics from poller")
-					continue
-				}
-				metricsCh <- mtrcs
+		case <-ticker.C:
+			mtrcs, err := pollSafe(p.poller)
+			if err != nil {
+				log.Error().Err(err).Str("poller", p.name).Msg("Failed to poll metrics from poller")
+				c.countPollErrors(p.name)
+				continue
+			}
+			select {
+			case metricsCh <- mtrcs:
+			case <-ctx.Done():
+				return
 			}
 		}
 	}
 }
 
+// pollSafe опрашивает сборщик, превращая панику в ошибку
+func pollSafe(p pollers.Poller) (mtrcs metrics.Metrics, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Errorf("poller panics: %v", r)
+		}
+	}()
+
+	return p.Poll()
+}
+
 func (c *App) storeMetrics(ctx context.Context, metricsCh chan metrics.Metrics) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -172,27 +268,112 @@ func (c *App) Stop(ctx context.Context) {
 	c.cancel()
 }
 
-// report отправляет собранные метрики на сервер
+// report отправляет собранные метрики на сервер.
+// Счетчики отправляются приростом с последней успешной отправки,
+// прирост неудавшейся отправки переносится на следующую.
+// Если включена очередь, неотправленные метрики сохраняются в нее
+// и отправляются перед следующими в порядке сохранения
 func (c *App) report(ctx context.Context) {
+	c.reportMutex.Lock()
+	defer c.reportMutex.Unlock()
+
+	c.storeOutboxStats(ctx)
+
 	mtrcs, err := c.storage.GetAllMetrics(ctx)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to get metrics to report")
 	}
 	log.Info().Msg("Sending metrics")
-	mtrcs = mtrcs.Sign(c.hasher)
+	mtrcs = c.counters.deltas(mtrcs)
+	prepared := mtrcs.WithLabels(c.cfg.Labels).Sign(c.hasher)
+
+	if c.outbox != nil && c.outbox.Len() > 0 {
+		sent, err := c.outbox.Replay(ctx, c.client.SendBatchMetricsToServer)
+		if sent > 0 {
+			log.Info().Msgf("Sent %d batches from outbox", sent)
+		}
+		if err != nil {
+			log.Error().Msg("Error occurred while sending outbox:" + err.Error())
+			c.enqueue(mtrcs, prepared)
+			return
+		}
+	}
+
 	if c.cfg.BatchMode {
-		err := c.client.SendBatchMetricsToServer(ctx, mtrcs)
+		err := c.client.SendBatchMetricsToServer(ctx, prepared)
 		if err != nil {
 			log.Error().Msg("Error occurred while reporting batch of metrics:" + err.Error())
+			c.enqueue(mtrcs, prepared)
+			return
 		}
+		c.counters.ack(mtrcs...)
 	} else {
-		for _, m := range mtrcs {
+		var failed, failedPrepared metrics.Metrics
+		for i, m := range prepared {
 			err := c.client.SendMetricToServer(ctx, m)
 			if err != nil {
 				log.Error().Msg("Error occurred while reporting " + m.Name + " metric:" + err.Error())
+				failed = append(failed, mtrcs[i])
+				failedPrepared = append(failedPrepared, m)
+				continue
 			}
+			c.counters.ack(mtrcs[i])
+		}
+		c.enqueue(failed, failedPrepared)
+	}
+}
+
+// enqueue сохраняет неотправленные метрики в очередь. Метрикам проставляется время сбора,
+// чтобы сервер сохранил историю значений за время недоступности.
+// Прирост счетчиков, попавших в очередь, считается отправленным
+func (c *App) enqueue(mtrcs, prepared metrics.Metrics) {
+	if c.outbox == nil || len(prepared) == 0 {
+		return
+	}
+
+	now := time.Now()
+	for i := range prepared {
+		if prepared[i].Timestamp == nil {
+			prepared[i].Timestamp = &now
 		}
 	}
+	if err := c.outbox.Push(prepared); err != nil {
+		log.Error().Err(err).Msg("Failed to store metrics to outbox")
+		return
+	}
+	c.counters.ack(mtrcs...)
+}
+
+// storeOutboxStats сохраняет состояние очереди в метрики агента
+func (c *App) storeOutboxStats(ctx context.Context) {
+	if c.outbox == nil {
+		return
+	}
+
+	stats := c.outbox.Stats()
+	err := c.storage.SetMetrics(ctx, metrics.Metrics{
+		metrics.MakeGaugeMetric("OutboxSegments", metrics.Gauge(stats.Segments)),
+		metrics.MakeGaugeMetric("OutboxBytes", metrics.Gauge(stats.Bytes)),
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to store outbox stats")
+	}
+}
+
+// countOutboxDrops учитывает пачки, удаленные из очереди без отправки
+func (c *App) countOutboxDrops(reason string, batches int) {
+	err := c.storage.IncCounter(context.Background(), "OutboxDropped", metrics.Labels{"reason": reason}, metrics.Counter(batches))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to count outbox drops")
+	}
+}
+
+// countPollErrors учитывает неудачные опросы сборщика
+func (c *App) countPollErrors(poller string) {
+	err := c.storage.IncCounter(context.Background(), "PollerErrors", metrics.Labels{"poller": poller}, 1)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to count poller errors")
+	}
 }
 
 func detectIP() (*net.UDPAddr, error) {
@@ -2,12 +2,17 @@ package agent
 
 import (
 	"context"
+	"errors"
 	"testing"
 	"time"
 
 	"github.com/vleukhin/prom-light/internal/config"
+	"github.com/vleukhin/prom-light/internal/metrics"
+	"github.com/vleukhin/prom-light/internal/outbox"
+	"github.com/vleukhin/prom-light/internal/storage"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestAgent_Start(t *testing.T) {
@@ -24,3 +29,240 @@ func TestAgent_Start(t *testing.T) {
 	time.Sleep(100 * time.Millisecond)
 	cancel()
 }
+
+// fakeClient запоминает принятые значения счетчиков так же, как это делает сервер
+type fakeClient struct {
+	fail     bool
+	failName string
+	counters map[string]metrics.Counter
+	gauges   []metrics.Metric
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{counters: make(map[string]metrics.Counter)}
+}
+
+func (f *fakeClient) SendMetricToServer(_ context.Context, m metrics.Metric) error {
+	if f.fail || m.Name == f.failName {
+		return errors.New("server unavailable")
+	}
+	switch m.Type {
+	case metrics.CounterTypeName:
+		f.counters[m.ID()] += *m.Delta
+	case metrics.GaugeTypeName:
+		f.gauges = append(f.gauges, m)
+	}
+	return nil
+}
+
+func (f *fakeClient) SendBatchMetricsToServer(ctx context.Context, mtrcs metrics.Metrics) error {
+	if f.fail {
+		return errors.New("server unavailable")
+	}
+	for _, m := range mtrcs {
+		if err := f.SendMetricToServer(ctx, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *fakeClient) ShutDown() error {
+	return nil
+}
+
+func TestAgent_ReportCounterDeltas(t *testing.T) {
+	for _, batch := range []bool{false, true} {
+		ctx := context.Background()
+		client := newFakeClient()
+		agent := App{
+			storage:  storage.NewMemoryStorage(0),
+			client:   client,
+			cfg:      &config.AgentConfig{BatchMode: batch},
+			counters: newCounterTracker(),
+		}
+		poll := func() {
+			require.NoError(t, agent.storage.SetMetrics(ctx, metrics.Metrics{metrics.MakeCounterMetric("PollCount", 1)}))
+		}
+
+		poll()
+		poll()
+		agent.report(ctx)
+		assert.Equal(t, metrics.Counter(2), client.counters["PollCount"], "batch=%v", batch)
+
+		// Повторная отправка без новых опросов ничего не добавляет
+		agent.report(ctx)
+		assert.Equal(t, metrics.Counter(2), client.counters["PollCount"], "batch=%v", batch)
+
+		// Прирост неудавшейся отправки переносится на следующую
+		poll()
+		client.fail = true
+		agent.report(ctx)
+		assert.Equal(t, metrics.Counter(2), client.counters["PollCount"], "batch=%v", batch)
+
+		poll()
+		client.fail = false
+		agent.report(ctx)
+		assert.Equal(t, metrics.Counter(4), client.counters["PollCount"], "batch=%v", batch)
+	}
+}
+
+func TestAgent_ReportPartialFailure(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeClient()
+	agent := App{
+		storage:  storage.NewMemoryStorage(0),
+		client:   client,
+		cfg:      &config.AgentConfig{Labels: map[string]string{"host": "a"}},
+		counters: newCounterTracker(),
+	}
+	poll := func() {
+		require.NoError(t, agent.storage.SetMetrics(ctx, metrics.Metrics{
+			metrics.MakeCounterMetric("PollCount", 1),
+			metrics.MakeCounterMetric("Errors", 2),
+		}))
+	}
+	labeled := func(name string) string {
+		return metrics.Metric{Name: name, Labels: metrics.Labels{"host": "a"}}.ID()
+	}
+
+	poll()
+	client.failName = "Errors"
+	agent.report(ctx)
+	assert.Equal(t, metrics.Counter(1), client.counters[labeled("PollCount")])
+	assert.Equal(t, metrics.Counter(0), client.counters[labeled("Errors")])
+
+	poll()
+	client.failName = ""
+	agent.report(ctx)
+	assert.Equal(t, metrics.Counter(2), client.counters[labeled("PollCount")])
+	assert.Equal(t, metrics.Counter(4), client.counters[labeled("Errors")])
+}
+
+func TestAgent_ReportOutbox(t *testing.T) {
+	for _, batch := range []bool{false, true} {
+		ctx := context.Background()
+		client := newFakeClient()
+		agent := App{
+			storage:  storage.NewMemoryStorage(0),
+			client:   client,
+			cfg:      &config.AgentConfig{BatchMode: batch},
+			counters: newCounterTracker(),
+		}
+		var err error
+		agent.outbox, err = outbox.Open(t.TempDir(), outbox.Limits{}, agent.countOutboxDrops)
+		require.NoError(t, err)
+
+		poll := func(alloc float64) {
+			require.NoError(t, agent.storage.SetMetrics(ctx, metrics.Metrics{
+				metrics.MakeCounterMetric("PollCount", 1),
+				metrics.MakeGaugeMetric("Alloc", metrics.Gauge(alloc)),
+			}))
+		}
+
+		client.fail = true
+		poll(1)
+		agent.report(ctx)
+		poll(2)
+		agent.report(ctx)
+		assert.Equal(t, 2, agent.outbox.Len(), "batch=%v", batch)
+
+		client.fail = false
+		poll(3)
+		agent.report(ctx)
+		assert.Equal(t, 0, agent.outbox.Len(), "batch=%v", batch)
+		assert.Equal(t, metrics.Counter(3), client.counters["PollCount"], "batch=%v", batch)
+
+		var allocs []float64
+		for _, m := range client.gauges {
+			if m.Name != "Alloc" {
+				continue
+			}
+			allocs = append(allocs, float64(*m.Value))
+			if *m.Value < 3 {
+				assert.NotNil(t, m.Timestamp, "batch=%v", batch)
+			}
+		}
+		assert.Equal(t, []float64{1, 2, 3}, allocs, "batch=%v", batch)
+	}
+}
+
+// funcPoller сборщик, вызывающий функцию
+type funcPoller func() (metrics.Metrics, error)
+
+func (f funcPoller) Poll() (metrics.Metrics, error) {
+	return f()
+}
+
+func TestAgent_PollersIsolated(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	block := make(chan struct{})
+	defer close(block)
+
+	agent := App{
+		storage: storage.NewMemoryStorage(0),
+		pollers: []scheduledPoller{
+			{name: "slow", interval: time.Millisecond, poller: funcPoller(func() (metrics.Metrics, error) {
+				<-block
+				return nil, nil
+			})},
+			{name: "failing", interval: time.Millisecond, poller: funcPoller(func() (metrics.Metrics, error) {
+				return nil, errors.New("poll failed")
+			})},
+			{name: "panicking", interval: time.Millisecond, poller: funcPoller(func() (metrics.Metrics, error) {
+				panic("poll panics")
+			})},
+			{name: "fast", interval: time.Millisecond, poller: funcPoller(func() (metrics.Metrics, error) {
+				return metrics.Metrics{metrics.MakeCounterMetric("FastPolls", 1)}, nil
+			})},
+		},
+	}
+	metricsCh := make(chan metrics.Metrics)
+	go agent.poll(ctx, metricsCh)
+	go agent.storeMetrics(ctx, metricsCh)
+
+	assert.Eventually(t, func() bool {
+		polls, err := agent.storage.GetCounter(ctx, "FastPolls", nil)
+		return err == nil && polls >= 3
+	}, time.Second, 5*time.Millisecond)
+	for _, name := range []string{"failing", "panicking"} {
+		assert.Eventually(t, func() bool {
+			errs, err := agent.storage.GetCounter(ctx, "PollerErrors", metrics.Labels{"poller": name})
+			return err == nil && errs > 0
+		}, time.Second, 5*time.Millisecond, name)
+	}
+}
+
+func TestNewPollers(t *testing.T) {
+	cfg := &config.AgentConfig{
+		PollInterval: config.Duration{Duration: 2 * time.Second},
+		Pollers: []config.PollerConfig{
+			{Name: "memstats"},
+			{Name: "ps", Interval: config.Duration{Duration: 10 * time.Second}, Options: []byte(`{"cpu_interval":"100ms"}`)},
+		},
+	}
+	scheduled, err := newPollers(cfg)
+	require.NoError(t, err)
+	require.Len(t, scheduled, 2)
+	assert.Equal(t, 2*time.Second, scheduled[0].interval)
+	assert.Equal(t, 10*time.Second, scheduled[1].interval)
+
+	cfg.Pollers = nil
+	scheduled, err = newPollers(cfg)
+	require.NoError(t, err)
+	require.Len(t, scheduled, len(config.DefaultPollers))
+	for i, name := range config.DefaultPollers {
+		assert.Equal(t, name, scheduled[i].name)
+	}
+
+	for name, pollers := range map[string][]config.PollerConfig{
+		"unknown poller": {{Name: "unknown"}},
+		"unknown option": {{Name: "ps", Options: []byte(`{"cpu":1}`)}},
+		"duplicate":      {{Name: "ps"}, {Name: "ps"}},
+	} {
+		cfg.Pollers = pollers
+		_, err := newPollers(cfg)
+		assert.Error(t, err, name)
+	}
+}
@@ -0,0 +1,40 @@
+package agent
+
+import (
+	"github.com/vleukhin/prom-light/internal/metrics"
+)
+
+// counterTracker запоминает, какая часть значений счетчиков уже принята сервером.
+// Агент хранит накопленные значения счетчиков, а сервер прибавляет каждое полученное значение,
+// поэтому отправлять нужно только прирост с последней успешной отправки
+type counterTracker struct {
+	reported map[string]metrics.Counter
+}
+
+func newCounterTracker() *counterTracker {
+	return &counterTracker{reported: make(map[string]metrics.Counter)}
+}
+
+// deltas заменяет накопленные значения счетчиков приростом с последней успешной отправки.
+// Остальные метрики выдаются без изменений
+func (t *counterTracker) deltas(mtrcs metrics.Metrics) metrics.Metrics {
+	result := make(metrics.Metrics, len(mtrcs))
+	for i, m := range mtrcs {
+		if m.Type == metrics.CounterTypeName && m.Delta != nil {
+			delta := *m.Delta - t.reported[m.ID()]
+			m.Delta = &delta
+		}
+		result[i] = m
+	}
+
+	return result
+}
+
+// ack отмечает приросты счетчиков как принятые сервером
+func (t *counterTracker) ack(mtrcs ...metrics.Metric) {
+	for _, m := range mtrcs {
+		if m.Type == metrics.CounterTypeName && m.Delta != nil {
+			t.reported[m.ID()] += *m.Delta
+		}
+	}
+}
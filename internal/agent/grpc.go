@@ -2,9 +2,16 @@ package agent
 
 import (
 	"context"
+	"crypto/tls"
+	"net"
+	"strings"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/vleukhin/prom-light/internal/config"
 
 	"github.com/vleukhin/prom-light/internal/metrics"
 	"github.com/vleukhin/prom-light/internal/proto"
@@ -13,10 +20,20 @@ import (
 type grpcClient struct {
 	conn   *grpc.ClientConn
 	client proto.MetricsClient
+	retry  RetryPolicy
 }
 
-func NewGRPCClient(addr string) (*grpcClient, error) {
-	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+// NewGRPCClient создает gRPC клиент. Адрес агента передается серверу в метаданных x-real-ip
+// для проверки доверенной подсети. tlsCfg задает TLS, nil означает соединение без шифрования
+func NewGRPCClient(addr string, IP net.IP, tlsCfg *tls.Config, retry RetryPolicy) (*grpcClient, error) {
+	creds := insecure.NewCredentials()
+	if tlsCfg != nil {
+		creds = credentials.NewTLS(tlsCfg)
+	}
+	conn, err := grpc.Dial(addr,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithUnaryInterceptor(realIPInterceptor(IP)),
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -24,21 +41,36 @@ func NewGRPCClient(addr string) (*grpcClient, error) {
 	return &grpcClient{
 		conn:   conn,
 		client: proto.NewMetricsClient(conn),
+		retry:  retry,
 	}, nil
 }
 
 func (g *grpcClient) SendMetricToServer(ctx context.Context, m metrics.Metric) error {
 	req := &proto.UpdateMetricRequest{Metric: metrics.ToProto(m)}
-	_, err := g.client.UpdateMetric(ctx, req)
-	return err
+	return g.retry.Do(ctx, isRetryableGRPC, func(ctx context.Context) error {
+		_, err := g.client.UpdateMetric(ctx, req)
+		return err
+	})
 }
 
 func (g *grpcClient) SendBatchMetricsToServer(ctx context.Context, m metrics.Metrics) error {
 	req := &proto.UpdateMetricsBatchRequest{Metrics: metrics.BatchToProto(m)}
-	_, err := g.client.UpdateMetricsBatch(ctx, req)
-	return err
+	return g.retry.Do(ctx, isRetryableGRPC, func(ctx context.Context) error {
+		_, err := g.client.UpdateMetricsBatch(ctx, req)
+		return err
+	})
 }
 
 func (g *grpcClient) ShutDown() error {
 	return g.conn.Close()
 }
+
+// realIPInterceptor добавляет адрес агента в метаданные запроса
+func realIPInterceptor(IP net.IP) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if IP != nil {
+			ctx = metadata.AppendToOutgoingContext(ctx, strings.ToLower(config.XRealIPHeader), IP.String())
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
@@ -4,12 +4,11 @@ import (
 	"bytes"
 	"context"
 	"crypto/rsa"
+	"crypto/tls"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"net"
 	"net/http"
-	"strconv"
 	"time"
 
 	"github.com/vleukhin/prom-light/internal/crypt"
@@ -20,25 +19,37 @@ import (
 
 type httpClient struct {
 	serverAddr string
+	scheme     string
 	client     http.Client
 	IP         net.IP
 	key        *rsa.PublicKey
+	retry      RetryPolicy
 }
 
-func NewHTTPClient(serverAddr string, IP net.IP, timeout time.Duration, key *rsa.PublicKey) *httpClient {
+// NewHTTPClient создает HTTP клиент. tlsCfg задает HTTPS, nil означает HTTP без шифрования
+func NewHTTPClient(serverAddr string, IP net.IP, timeout time.Duration, key *rsa.PublicKey, tlsCfg *tls.Config, retry RetryPolicy) *httpClient {
 	client := http.Client{}
 	client.Timeout = timeout
+	scheme := "http"
+	if tlsCfg != nil {
+		scheme = "https"
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsCfg
+		client.Transport = transport
+	}
 	return &httpClient{
 		serverAddr: serverAddr,
+		scheme:     scheme,
 		client:     client,
 		IP:         IP,
 		key:        key,
+		retry:      retry,
 	}
 }
 
 // SendMetricToServer отправляет запрос на сервер метрик
 func (c *httpClient) SendMetricToServer(ctx context.Context, m metrics.Metric) error {
-	data, err := json.Marshal(m)
+	data, err := c.encrypt(m)
 	if err != nil {
 		return err
 	}
@@ -56,31 +67,34 @@ func (c *httpClient) SendBatchMetricsToServer(ctx context.Context, m metrics.Met
 	return c.sendRequest(ctx, "/updates", data)
 }
 
-// sendRequest отправляет запрос на сервер метрик
+// sendRequest отправляет запрос на сервер метрик, повторяя его при временных ошибках
 func (c *httpClient) sendRequest(ctx context.Context, endpoint string, data []byte) error {
-	r, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("http://%s%s/", c.serverAddr, endpoint), bytes.NewBuffer(data))
-	r.Header.Set(config.XRealIPHeader, c.IP.String())
-	if err != nil {
-		return err
-	}
-	resp, err := c.client.Do(r)
-	if err != nil {
-		return err
-	}
-	err = resp.Body.Close()
-	if err != nil {
-		return err
-	}
-	if resp.StatusCode != http.StatusOK {
-		return errors.New("bad response while batch reporting: " + strconv.Itoa(resp.StatusCode))
-	}
+	url := fmt.Sprintf("%s://%s%s/", c.scheme, c.serverAddr, endpoint)
+	return c.retry.Do(ctx, isRetryableHTTP, func(ctx context.Context) error {
+		r, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		r.Header.Set(config.XRealIPHeader, c.IP.String())
+		resp, err := c.client.Do(r)
+		if err != nil {
+			return err
+		}
+		err = resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return &statusError{code: resp.StatusCode}
+		}
 
-	return nil
+		return nil
+	})
 }
 
-// encrypt encrypts metrics with public key
-func (c *httpClient) encrypt(m metrics.Metrics) ([]byte, error) {
-	data, err := json.Marshal(m)
+// encrypt encrypts metric or metrics batch with public key
+func (c *httpClient) encrypt(v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
 	if err != nil {
 		return nil, err
 	}
@@ -89,7 +103,7 @@ func (c *httpClient) encrypt(m metrics.Metrics) ([]byte, error) {
 		return data, nil
 	}
 
-	return crypt.EncryptOAEP(c.key, data, nil)
+	return crypt.EncryptEnvelope(c.key, data)
 }
 
 func (c *httpClient) ShutDown() error {
@@ -0,0 +1,100 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	mrand "math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// backoffMultiplier множитель интервала между попытками
+const backoffMultiplier = 2
+
+// RetryPolicy описывает повтор отправки с экспоненциальной задержкой.
+// Задержка перед очередной попыткой выбирается случайно от нуля до текущего интервала
+type RetryPolicy struct {
+	// MaxAttempts максимальное количество попыток, 0 и 1 отключают повторы
+	MaxAttempts int
+	// InitialInterval интервал перед первым повтором
+	InitialInterval time.Duration
+	// MaxInterval максимальный интервал между попытками
+	MaxInterval time.Duration
+	// MaxElapsedTime время, после которого повторы прекращаются. 0 снимает ограничение
+	MaxElapsedTime time.Duration
+}
+
+// Do выполняет fn, повторяя ее, пока ошибка признается retryable временной и ограничения политики не исчерпаны
+func (p RetryPolicy) Do(ctx context.Context, retryable func(error) bool, fn func(ctx context.Context) error) error {
+	start := time.Now()
+	interval := p.InitialInterval
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if err == nil || !retryable(err) || attempt >= p.MaxAttempts || ctx.Err() != nil {
+			return err
+		}
+
+		delay := time.Duration(0)
+		if interval > 0 {
+			delay = time.Duration(mrand.Int63n(int64(interval) + 1))
+		}
+		if p.MaxElapsedTime > 0 && time.Since(start)+delay > p.MaxElapsedTime {
+			return err
+		}
+		log.Debug().Err(err).Msgf("Attempt %d failed, retrying in %s", attempt, delay)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+
+		interval *= backoffMultiplier
+		if p.MaxInterval > 0 && interval > p.MaxInterval {
+			interval = p.MaxInterval
+		}
+	}
+}
+
+// statusError ответ сервера с неуспешным статусом
+type statusError struct {
+	code int
+}
+
+func (e *statusError) Error() string {
+	return "bad response while reporting: " + strconv.Itoa(e.code)
+}
+
+// isRetryableHTTP признает временными ошибки соединения, таймауты, ограничение частоты
+// и ошибки сервера, кроме неподдерживаемых методов
+func isRetryableHTTP(err error) bool {
+	var se *statusError
+	if errors.As(err, &se) {
+		switch se.code {
+		case http.StatusRequestTimeout, http.StatusTooManyRequests:
+			return true
+		case http.StatusNotImplemented, http.StatusHTTPVersionNotSupported:
+			return false
+		}
+		return se.code >= http.StatusInternalServerError
+	}
+
+	return !errors.Is(err, context.Canceled)
+}
+
+// isRetryableGRPC признает временными коды, при которых запрос можно безопасно повторить
+func isRetryableGRPC(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.ResourceExhausted, codes.Aborted, codes.DeadlineExceeded:
+		return true
+	}
+
+	return false
+}
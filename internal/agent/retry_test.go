@@ -0,0 +1,129 @@
+package agent
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/vleukhin/prom-light/internal/metrics"
+)
+
+// flakyServer отвечает статусом failStatus на первые failures запросов
+func flakyServer(failures int32, failStatus int) (*httptest.Server, *int32) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) <= failures {
+			w.WriteHeader(failStatus)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	return srv, &calls
+}
+
+func TestHTTPClient_Retry(t *testing.T) {
+	policy := RetryPolicy{
+		MaxAttempts:     5,
+		InitialInterval: time.Millisecond,
+		MaxInterval:     5 * time.Millisecond,
+	}
+	batch := metrics.Metrics{metrics.MakeGaugeMetric("Alloc", 1)}
+
+	tests := []struct {
+		name      string
+		failures  int32
+		status    int
+		policy    RetryPolicy
+		wantCalls int32
+		wantErr   bool
+	}{
+		{name: "Recovers after server errors", failures: 3, status: http.StatusServiceUnavailable, policy: policy, wantCalls: 4},
+		{name: "Recovers after throttling", failures: 1, status: http.StatusTooManyRequests, policy: policy, wantCalls: 2},
+		{name: "Gives up after max attempts", failures: 10, status: http.StatusBadGateway, policy: policy, wantCalls: 5, wantErr: true},
+		{name: "Client errors are not retried", failures: 10, status: http.StatusBadRequest, policy: policy, wantCalls: 1, wantErr: true},
+		{name: "Not implemented is not retried", failures: 10, status: http.StatusNotImplemented, policy: policy, wantCalls: 1, wantErr: true},
+		{name: "Retries disabled", failures: 1, status: http.StatusInternalServerError, policy: RetryPolicy{MaxAttempts: 1}, wantCalls: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, calls := flakyServer(tt.failures, tt.status)
+			defer srv.Close()
+
+			client := NewHTTPClient(strings.TrimPrefix(srv.URL, "http://"), net.IPv4(127, 0, 0, 1), time.Second, nil, nil, tt.policy)
+			err := client.SendBatchMetricsToServer(context.Background(), batch)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantCalls, atomic.LoadInt32(calls))
+		})
+	}
+}
+
+func TestHTTPClient_RetryConnectionErrors(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := listener.Addr().String()
+	_ = listener.Close()
+
+	var calls int32
+	policy := RetryPolicy{MaxAttempts: 10, InitialInterval: 20 * time.Millisecond, MaxInterval: 20 * time.Millisecond}
+	client := NewHTTPClient(addr, net.IPv4(127, 0, 0, 1), time.Second, nil, nil, policy)
+
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusOK)
+	})}
+	defer srv.Close()
+
+	// Сервер поднимается, пока клиент повторяет попытки
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return
+		}
+		_ = srv.Serve(l)
+	}()
+
+	err = client.SendMetricToServer(context.Background(), metrics.MakeCounterMetric("PollCount", 1))
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestRetryPolicy_MaxElapsedTime(t *testing.T) {
+	policy := RetryPolicy{
+		MaxAttempts:     1000,
+		InitialInterval: 10 * time.Millisecond,
+		MaxInterval:     10 * time.Millisecond,
+		MaxElapsedTime:  100 * time.Millisecond,
+	}
+	start := time.Now()
+	attempts := 0
+	err := policy.Do(context.Background(), isRetryableGRPC, func(ctx context.Context) error {
+		attempts++
+		return status.Error(codes.Unavailable, "unavailable")
+	})
+	assert.Error(t, err)
+	assert.Greater(t, attempts, 1)
+	assert.Less(t, time.Since(start), 200*time.Millisecond)
+
+	attempts = 0
+	err = policy.Do(context.Background(), isRetryableGRPC, func(ctx context.Context) error {
+		attempts++
+		return status.Error(codes.InvalidArgument, "bad metric")
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 1, attempts)
+}
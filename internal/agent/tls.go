@@ -0,0 +1,42 @@
+package agent
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+
+	"github.com/vleukhin/prom-light/internal/config"
+)
+
+// tlsConfig создает настройки TLS для соединения с сервером.
+// Если TLS не включен, выдает nil. Если задан TLSCA, сертификат сервера
+// проверяется только по нему, иначе по системным корневым сертификатам.
+// Если задан TLSCert, агент предъявляет серверу клиентский сертификат
+func tlsConfig(cfg *config.AgentConfig) (*tls.Config, error) {
+	if !cfg.TLS && cfg.TLSCA == "" && cfg.TLSCert == "" {
+		return nil, nil
+	}
+
+	tlsCfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.TLSCert != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey)
+		if err != nil {
+			return nil, err
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+	if cfg.TLSCA != "" {
+		pem, err := os.ReadFile(cfg.TLSCA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in " + cfg.TLSCA)
+		}
+		tlsCfg.RootCAs = pool
+	}
+
+	return tlsCfg, nil
+}
@@ -1,6 +1,9 @@
 package config
 
 import (
+	"encoding/json"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/caarlos0/env/v6"
@@ -9,17 +12,43 @@ import (
 
 // AgentConfig описывает конфиг агента
 type AgentConfig struct {
-	ServerAddr     string   `env:"ADDRESS" json:"address"`
-	PollInterval   Duration `env:"POLL_INTERVAL" json:"poll_interval"`
-	ReportInterval Duration `env:"REPORT_INTERVAL" json:"report_interval"`
-	ReportTimeout  Duration `env:"REPORT_TIMEOUT" json:"report_timeout"`
-	Key            string   `env:"KEY" json:"hash_key"`
-	BatchMode      bool     `env:"BATCH_MODE" json:"batch_mode"`
-	LogLevel       string   `env:"LOG_LEVEL" json:"log_level"`
-	CryptoKey      string   `env:"CRYPTO_KEY" json:"crypto_key"`
-	Protocol       string   `env:"PROTOCOL" json:"protocol"`
+	ServerAddr     string            `env:"ADDRESS" json:"address"`
+	PollInterval   Duration          `env:"POLL_INTERVAL" json:"poll_interval"`
+	ReportInterval Duration          `env:"REPORT_INTERVAL" json:"report_interval"`
+	ReportTimeout  Duration          `env:"REPORT_TIMEOUT" json:"report_timeout"`
+	Key            string            `env:"KEY" json:"hash_key"`
+	BatchMode      bool              `env:"BATCH_MODE" json:"batch_mode"`
+	LogLevel       string            `env:"LOG_LEVEL" json:"log_level"`
+	CryptoKey      string            `env:"CRYPTO_KEY" json:"crypto_key"`
+	Protocol       string            `env:"PROTOCOL" json:"protocol"`
+	Labels         map[string]string `env:"LABELS" json:"labels"`
+	OutboxDir      string            `env:"OUTBOX_DIR" json:"outbox_dir"`
+	OutboxMaxSize  int64             `env:"OUTBOX_MAX_SIZE" json:"outbox_max_size"`
+	OutboxMaxAge   Duration          `env:"OUTBOX_MAX_AGE" json:"outbox_max_age"`
+	TLS            bool              `env:"TLS" json:"tls"`
+	TLSCA          string            `env:"TLS_CA" json:"tls_ca"`
+	TLSCert        string            `env:"TLS_CERT" json:"tls_cert"`
+	TLSKey         string            `env:"TLS_KEY" json:"tls_key"`
+
+	RetryMaxAttempts     int      `env:"RETRY_MAX_ATTEMPTS" json:"retry_max_attempts"`
+	RetryInitialInterval Duration `env:"RETRY_INITIAL_INTERVAL" json:"retry_initial_interval"`
+	RetryMaxInterval     Duration `env:"RETRY_MAX_INTERVAL" json:"retry_max_interval"`
+	RetryMaxElapsedTime  Duration `env:"RETRY_MAX_ELAPSED_TIME" json:"retry_max_elapsed_time"`
+
+	Pollers []PollerConfig `json:"pollers"`
+}
+
+// PollerConfig описывает запускаемый сборщик метрик. Interval - интервал опроса,
+// 0 - общий PollInterval. Options - настройки сборщика, свои у каждого сборщика
+type PollerConfig struct {
+	Name     string          `json:"name"`
+	Interval Duration        `json:"interval"`
+	Options  json.RawMessage `json:"options"`
 }
 
+// DefaultPollers сборщики, которые запускаются, если в конфиге они не выбраны
+var DefaultPollers = []string{"memstats", "ps"}
+
 func (cfg *AgentConfig) Parse() error {
 	err := fillConfigFromFile(cfg)
 	if err != nil {
@@ -34,6 +63,19 @@ func (cfg *AgentConfig) Parse() error {
 	logLevel := pflag.StringP("log-level", "l", "info", "Setup log level")
 	cryptoKey := pflag.StringP("crypto-key", "e", "", "Path to public key")
 	proto := pflag.StringP("protocol", "c", "http", "Server protocol (http or grpc")
+	labels := pflag.StringToStringP("label", "L", nil, "Labels added to every reported metric (key=value)")
+	outboxDir := pflag.String("outbox-dir", "", "Directory for batches that failed to be reported. Empty disables outbox")
+	outboxMaxSize := pflag.Int64("outbox-max-size", 64<<20, "Outbox size limit in bytes. 0 disables limit")
+	outboxMaxAge := pflag.Duration("outbox-max-age", 24*time.Hour, "How long to keep batches in outbox. 0 disables limit")
+	useTLS := pflag.Bool("tls", false, "Connect to server over TLS")
+	tlsCA := pflag.String("tls-ca", "", "Path to CA certificate to verify server. Enables TLS")
+	tlsCert := pflag.String("tls-cert", "", "Path to client certificate for mutual TLS. Enables TLS")
+	tlsKey := pflag.String("tls-key", "", "Path to client certificate private key")
+	retryMaxAttempts := pflag.Int("retry-max-attempts", 5, "Max attempts to report metrics. 1 disables retries")
+	retryInitial := pflag.Duration("retry-initial-interval", 100*time.Millisecond, "Delay before the first retry")
+	retryMaxInterval := pflag.Duration("retry-max-interval", 2*time.Second, "Max delay between retries")
+	retryMaxElapsed := pflag.Duration("retry-max-elapsed-time", 0, "Time limit for retries. 0 or values above report interval mean report interval")
+	pollerNames := pflag.StringSlice("pollers", nil, "Comma separated pollers to run. Intervals and options are set in JSON config (default memstats,ps)")
 
 	pflag.Parse()
 
@@ -46,11 +88,53 @@ func (cfg *AgentConfig) Parse() error {
 	cfg.LogLevel = *logLevel
 	cfg.CryptoKey = *cryptoKey
 	cfg.Protocol = *proto
+	if len(*labels) > 0 {
+		cfg.Labels = *labels
+	}
+	cfg.OutboxDir = *outboxDir
+	cfg.OutboxMaxSize = *outboxMaxSize
+	cfg.OutboxMaxAge = Duration{*outboxMaxAge}
+	cfg.TLS = *useTLS
+	cfg.TLSCA = *tlsCA
+	cfg.TLSCert = *tlsCert
+	cfg.TLSKey = *tlsKey
+	cfg.RetryMaxAttempts = *retryMaxAttempts
+	cfg.RetryInitialInterval = Duration{*retryInitial}
+	cfg.RetryMaxInterval = Duration{*retryMaxInterval}
+	cfg.RetryMaxElapsedTime = Duration{*retryMaxElapsed}
+	switch {
+	case len(*pollerNames) > 0:
+		cfg.Pollers = selectPollers(cfg.Pollers, *pollerNames)
+	case len(cfg.Pollers) == 0:
+		cfg.Pollers = selectPollers(nil, DefaultPollers)
+	}
 
 	err = env.ParseWithFuncs(cfg, parseFuncs())
 	if err != nil {
 		return err
 	}
+	// POLLERS, как и флаг, только выбирает сборщики, их настройки задаются в JSON
+	if names := os.Getenv("POLLERS"); names != "" {
+		cfg.Pollers = selectPollers(cfg.Pollers, strings.Split(names, ","))
+	}
 
 	return nil
 }
+
+// selectPollers выбирает сборщики names. Для сборщиков, описанных в configured,
+// сохраняются их интервал и настройки
+func selectPollers(configured []PollerConfig, names []string) []PollerConfig {
+	selected := make([]PollerConfig, 0, len(names))
+	for _, name := range names {
+		p := PollerConfig{Name: name}
+		for _, c := range configured {
+			if c.Name == name {
+				p = c
+				break
+			}
+		}
+		selected = append(selected, p)
+	}
+
+	return selected
+}
@@ -19,6 +19,9 @@ type Duration struct {
 
 const XRealIPHeader = "X-Real-IP"
 
+// HashHeader заголовок с подписью тела запроса
+const HashHeader = "Hash"
+
 const (
 	ProtocolHTTP = "http"
 	ProtocolGRPC = "grpc"
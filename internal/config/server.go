@@ -10,17 +10,49 @@ import (
 
 // ServerConfig описывает конфиг сервера
 type ServerConfig struct {
-	Addr          string    `env:"ADDRESS" json:"address"`
-	Restore       bool      `env:"RESTORE" json:"restore"`
-	StoreFile     string    `env:"STORE_FILE" json:"store_file"`
-	StoreInterval Duration  `env:"STORE_INTERVAL" json:"store_interval"`
-	Key           string    `env:"KEY" json:"hash_key"`
-	DSN           string    `env:"DATABASE_DSN" json:"database_dsn"`
-	DBConnTimeout Duration  `env:"DB_CONN_TIMEOUT" envDefault:"5s" json:"db_conn_timeout"`
-	LogLevel      string    `env:"LOG_LEVEL" json:"log_level"`
-	CryptoKey     string    `env:"CRYPTO_KEY" json:"crypto_key"`
-	TrustedSubnet net.IPNet `env:"TRUSTED_SUBNET" json:"trusted_subnet"`
-	Protocol      string    `env:"PROTOCOL" json:"protocol"`
+	Addr                string            `env:"ADDRESS" json:"address"`
+	Restore             bool              `env:"RESTORE" json:"restore"`
+	StoreFile           string            `env:"STORE_FILE" json:"store_file"`
+	StoreInterval       Duration          `env:"STORE_INTERVAL" json:"store_interval"`
+	StoreWAL            bool              `env:"STORE_WAL" json:"store_wal"`
+	WALCheckpoint       Duration          `env:"WAL_CHECKPOINT_INTERVAL" json:"wal_checkpoint_interval"`
+	Key                 string            `env:"KEY" json:"hash_key"`
+	DSN                 string            `env:"DATABASE_DSN" json:"database_dsn"`
+	DBConnTimeout       Duration          `env:"DB_CONN_TIMEOUT" envDefault:"5s" json:"db_conn_timeout"`
+	LogLevel            string            `env:"LOG_LEVEL" json:"log_level"`
+	CryptoKey           string            `env:"CRYPTO_KEY" json:"crypto_key"`
+	TrustedSubnet       net.IPNet         `env:"TRUSTED_SUBNET" json:"trusted_subnet"`
+	Protocol            string            `env:"PROTOCOL" json:"protocol"`
+	HistoryRetention    Duration          `env:"HISTORY_RETENTION" json:"history_retention"`
+	StatsdAddr          string            `env:"STATSD_ADDRESS" json:"statsd_address"`
+	StatsdTCPAddr       string            `env:"STATSD_TCP_ADDRESS" json:"statsd_tcp_address"`
+	StatsdFlushInterval Duration          `env:"STATSD_FLUSH_INTERVAL" json:"statsd_flush_interval"`
+	GraphiteAddr        string            `env:"GRAPHITE_ADDRESS" json:"graphite_address"`
+	GraphiteMappings    []GraphiteMapping `json:"graphite_mappings"`
+	TLSCert             string            `env:"TLS_CERT" json:"tls_cert"`
+	TLSKey              string            `env:"TLS_KEY" json:"tls_key"`
+	TLSClientCA         string            `env:"TLS_CLIENT_CA" json:"tls_client_ca"`
+	TLSIdentityLabel    string            `env:"TLS_IDENTITY_LABEL" json:"tls_identity_label"`
+	StaleInterval       Duration          `env:"STALE_INTERVAL" json:"stale_interval"`
+	StaleIntervals      int               `env:"STALE_INTERVALS" json:"stale_intervals"`
+	StaleOverrides      []StaleOverride   `json:"stale_overrides"`
+}
+
+// StaleOverride переопределяет количество интервалов без обновлений, после которых
+// устаревают серии с типом Type и/или префиксом имени Prefix. 0 - серии не устаревают
+type StaleOverride struct {
+	Type      string `json:"type"`
+	Prefix    string `json:"prefix"`
+	Intervals int    `json:"intervals"`
+}
+
+// GraphiteMapping правило преобразования пути Graphite в имя и метки метрики.
+// Match - путь, в котором сегмент * совпадает с любым сегментом.
+// В Name и значениях Labels $1, $2 и т.д. заменяются совпавшими сегментами
+type GraphiteMapping struct {
+	Match  string            `json:"match"`
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels"`
 }
 
 func (cfg *ServerConfig) Parse() error {
@@ -31,6 +63,8 @@ func (cfg *ServerConfig) Parse() error {
 	addr := pflag.StringP("addr", "a", "localhost:8080", "Server address")
 	restore := pflag.BoolP("restore", "r", true, "Restore data on start up")
 	storeInterval := pflag.DurationP("store-interval", "i", 1*time.Minute, "Store interval. 0 enables sync mode")
+	storeWAL := pflag.Bool("store-wal", false, "Append changes to write-ahead log instead of rewriting file in sync mode")
+	walCheckpoint := pflag.Duration("wal-checkpoint-interval", 1*time.Minute, "How often write-ahead log is compacted into file")
 	storeFile := pflag.StringP("file", "f", "/tmp/devops-metrics-db.json", "Path for file storage. Empty value disables file storage")
 	key := pflag.StringP("key", "k", "", "Secret key for signing data")
 	dsn := pflag.StringP("database-dsn", "d", "", "Database connection string")
@@ -38,12 +72,25 @@ func (cfg *ServerConfig) Parse() error {
 	cryptoKey := pflag.StringP("crypto-key", "e", "", "Path to private key")
 	trustedSubnet := pflag.IPNetP("trusted-subnet", "t", net.IPNet{}, "CIDR for trusted subnet")
 	proto := pflag.StringP("protocol", "p", "http", "Server protocol (http or grpc")
+	historyRetention := pflag.Duration("history-retention", 0, "How long to keep metric history. 0 disables history")
+	statsdAddr := pflag.String("statsd-addr", "", "StatsD UDP address. Empty value disables StatsD listener")
+	statsdTCPAddr := pflag.String("statsd-tcp-addr", "", "StatsD TCP address. Empty value disables StatsD over TCP")
+	statsdFlush := pflag.Duration("statsd-flush-interval", 10*time.Second, "StatsD aggregation flush interval")
+	graphiteAddr := pflag.String("graphite-addr", "", "Graphite plaintext TCP address. Empty value disables Graphite listener")
+	tlsCert := pflag.String("tls-cert", "", "Path to TLS certificate. Empty value disables TLS")
+	tlsKey := pflag.String("tls-key", "", "Path to TLS private key")
+	tlsClientCA := pflag.String("tls-client-ca", "", "Path to CA certificate to verify agent certificates. Enables mutual TLS")
+	tlsIdentityLabel := pflag.String("tls-identity-label", "", "Label for agent identity from its certificate CN/SAN. Empty value disables label")
+	staleInterval := pflag.Duration("stale-interval", 0, "Stale series check interval. 0 disables stale series expiry")
+	staleIntervals := pflag.Int("stale-intervals", 3, "Number of intervals without updates after which series is stale. 0 keeps series forever")
 
 	pflag.Parse()
 
 	cfg.Addr = *addr
 	cfg.Restore = *restore
 	cfg.StoreInterval = Duration{*storeInterval}
+	cfg.StoreWAL = *storeWAL
+	cfg.WALCheckpoint = Duration{*walCheckpoint}
 	cfg.StoreFile = *storeFile
 	cfg.Key = *key
 	cfg.DSN = *dsn
@@ -51,6 +98,17 @@ func (cfg *ServerConfig) Parse() error {
 	cfg.CryptoKey = *cryptoKey
 	cfg.TrustedSubnet = *trustedSubnet
 	cfg.Protocol = *proto
+	cfg.HistoryRetention = Duration{*historyRetention}
+	cfg.StatsdAddr = *statsdAddr
+	cfg.StatsdTCPAddr = *statsdTCPAddr
+	cfg.StatsdFlushInterval = Duration{*statsdFlush}
+	cfg.GraphiteAddr = *graphiteAddr
+	cfg.TLSCert = *tlsCert
+	cfg.TLSKey = *tlsKey
+	cfg.TLSClientCA = *tlsClientCA
+	cfg.TLSIdentityLabel = *tlsIdentityLabel
+	cfg.StaleInterval = Duration{*staleInterval}
+	cfg.StaleIntervals = *staleIntervals
 
 	err = env.ParseWithFuncs(cfg, parseFuncs())
 	if err != nil {
@@ -0,0 +1,132 @@
+package crypt
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	keyOnce sync.Once
+	key     *rsa.PrivateKey
+)
+
+func testKey(tb testing.TB) *rsa.PrivateKey {
+	keyOnce.Do(func() {
+		var err error
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(tb, err)
+	})
+
+	return key
+}
+
+// testPayload выдает тело batch запроса агента из n метрик
+func testPayload(tb testing.TB, n int) []byte {
+	type metric struct {
+		ID    string  `json:"id"`
+		Type  string  `json:"type"`
+		Value float64 `json:"value"`
+	}
+	batch := make([]metric, n)
+	for i := range batch {
+		batch[i] = metric{ID: "Metric" + strconv.Itoa(i), Type: "gauge", Value: float64(i) * 1.5}
+	}
+	data, err := json.Marshal(batch)
+	require.NoError(tb, err)
+
+	return data
+}
+
+func TestEnvelope(t *testing.T) {
+	private := testKey(t)
+	msg := testPayload(t, 30)
+
+	encrypted, err := EncryptEnvelope(&private.PublicKey, msg)
+	require.NoError(t, err)
+	assert.True(t, IsEnvelope(encrypted))
+	assert.Less(t, len(encrypted), len(msg)+private.Size()+64)
+
+	decrypted, err := Decrypt(private, encrypted)
+	require.NoError(t, err)
+	assert.Equal(t, msg, decrypted)
+
+	t.Run("Tampered", func(t *testing.T) {
+		tampered := append([]byte(nil), encrypted...)
+		tampered[len(tampered)-1] ^= 0xff
+		_, err := Decrypt(private, tampered)
+		assert.Error(t, err)
+	})
+
+	t.Run("Unsupported version", func(t *testing.T) {
+		other := append([]byte(nil), encrypted...)
+		other[len(envelopeMagic)] = EnvelopeVersion + 1
+		_, err := DecryptEnvelope(private, other)
+		assert.Error(t, err)
+	})
+
+	t.Run("Truncated", func(t *testing.T) {
+		_, err := Decrypt(private, encrypted[:len(envelopeMagic)+3])
+		assert.Error(t, err)
+	})
+}
+
+func TestDecrypt_Legacy(t *testing.T) {
+	private := testKey(t)
+	msg := testPayload(t, 30)
+
+	encrypted, err := EncryptOAEP(&private.PublicKey, msg, nil)
+	require.NoError(t, err)
+
+	decrypted, err := Decrypt(private, encrypted)
+	require.NoError(t, err)
+	assert.Equal(t, msg, decrypted)
+}
+
+func BenchmarkEncrypt(b *testing.B) {
+	private := testKey(b)
+	msg := testPayload(b, 30)
+
+	b.Run("legacy", func(b *testing.B) {
+		b.SetBytes(int64(len(msg)))
+		var encrypted []byte
+		for i := 0; i < b.N; i++ {
+			encrypted, _ = EncryptOAEP(&private.PublicKey, msg, nil)
+		}
+		b.ReportMetric(float64(len(encrypted)), "payload-bytes")
+	})
+	b.Run("envelope", func(b *testing.B) {
+		b.SetBytes(int64(len(msg)))
+		var encrypted []byte
+		for i := 0; i < b.N; i++ {
+			encrypted, _ = EncryptEnvelope(&private.PublicKey, msg)
+		}
+		b.ReportMetric(float64(len(encrypted)), "payload-bytes")
+	})
+}
+
+func BenchmarkDecrypt(b *testing.B) {
+	private := testKey(b)
+	msg := testPayload(b, 30)
+	legacy, err := EncryptOAEP(&private.PublicKey, msg, nil)
+	require.NoError(b, err)
+	envelope, err := EncryptEnvelope(&private.PublicKey, msg)
+	require.NoError(b, err)
+
+	b.Run("legacy", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, _ = Decrypt(private, legacy)
+		}
+	})
+	b.Run("envelope", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, _ = Decrypt(private, envelope)
+		}
+	})
+}
@@ -0,0 +1,132 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// EnvelopeVersion текущая версия формата конверта
+const EnvelopeVersion byte = 1
+
+// envelopeMagic признак конверта в начале сообщения
+var envelopeMagic = []byte("PLE")
+
+// dataKeySize размер ключа AES-256
+const dataKeySize = 32
+
+// EncryptEnvelope шифрует сообщение случайным ключом AES-256-GCM, а сам ключ шифрует RSA-OAEP.
+// Формат конверта:
+//
+//	magic "PLE" | version (1 байт) | длина ключа (uint16 BE) | зашифрованный ключ | nonce | шифротекст с тегом GCM
+//
+// Заголовок magic+version участвует в проверке целостности как дополнительные данные GCM
+func EncryptEnvelope(public *rsa.PublicKey, msg []byte) ([]byte, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, err
+	}
+
+	wrappedKey, err := rsa.EncryptOAEP(sha512.New(), rand.Reader, public, dataKey, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	header := envelopeHeader(EnvelopeVersion)
+	out := make([]byte, 0, len(header)+2+len(wrappedKey)+len(nonce)+len(msg)+aead.Overhead())
+	out = append(out, header...)
+	out = append(out, 0, 0)
+	binary.BigEndian.PutUint16(out[len(out)-2:], uint16(len(wrappedKey)))
+	out = append(out, wrappedKey...)
+	out = append(out, nonce...)
+
+	return aead.Seal(out, nonce, msg, header), nil
+}
+
+// DecryptEnvelope расшифровывает сообщение, зашифрованное EncryptEnvelope
+func DecryptEnvelope(private *rsa.PrivateKey, msg []byte) ([]byte, error) {
+	if !IsEnvelope(msg) {
+		return nil, errors.New("not an envelope")
+	}
+	header := msg[:len(envelopeMagic)+1]
+	if version := header[len(envelopeMagic)]; version != EnvelopeVersion {
+		return nil, errors.New("unsupported envelope version")
+	}
+
+	rest := msg[len(header):]
+	if len(rest) < 2 {
+		return nil, errors.New("envelope is too short")
+	}
+	keyLen := int(binary.BigEndian.Uint16(rest))
+	rest = rest[2:]
+	if len(rest) < keyLen {
+		return nil, errors.New("envelope is too short")
+	}
+
+	dataKey, err := rsa.DecryptOAEP(sha512.New(), rand.Reader, private, rest[:keyLen], nil)
+	if err != nil {
+		return nil, err
+	}
+	rest = rest[keyLen:]
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) < aead.NonceSize()+aead.Overhead() {
+		return nil, errors.New("envelope is too short")
+	}
+
+	return aead.Open(nil, rest[:aead.NonceSize()], rest[aead.NonceSize():], header)
+}
+
+// IsEnvelope проверяет, что сообщение начинается с заголовка конверта
+func IsEnvelope(msg []byte) bool {
+	return len(msg) > len(envelopeMagic) && bytes.HasPrefix(msg, envelopeMagic)
+}
+
+// Decrypt расшифровывает сообщение в формате конверта или в устаревшем формате блоков RSA-OAEP.
+// Сообщение устаревшего формата может случайно начинаться с заголовка конверта,
+// поэтому при ошибке разбора конверта сообщение расшифровывается как устаревшее
+func Decrypt(private *rsa.PrivateKey, msg []byte) ([]byte, error) {
+	if !IsEnvelope(msg) {
+		return DecryptOAEP(private, msg, nil)
+	}
+
+	decrypted, err := DecryptEnvelope(private, msg)
+	if err != nil && len(msg)%private.PublicKey.Size() == 0 {
+		if legacy, legacyErr := DecryptOAEP(private, msg, nil); legacyErr == nil {
+			return legacy, nil
+		}
+	}
+
+	return decrypted, err
+}
+
+func envelopeHeader(version byte) []byte {
+	return append(append([]byte(nil), envelopeMagic...), version)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,26 @@
+package crypt
+
+import (
+	"crypto/tls"
+)
+
+// PeerIdentity выдает идентификатор клиента по проверенному клиентскому сертификату:
+// CommonName, а если он пуст, первое DNS имя или URI из SAN.
+// Если клиент не предъявил сертификат или он не проверен, выдает пустую строку
+func PeerIdentity(state *tls.ConnectionState) string {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.PeerCertificates) == 0 {
+		return ""
+	}
+
+	cert := state.PeerCertificates[0]
+	switch {
+	case cert.Subject.CommonName != "":
+		return cert.Subject.CommonName
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String()
+	}
+
+	return ""
+}
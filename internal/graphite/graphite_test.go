@@ -0,0 +1,151 @@
+package graphite
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vleukhin/prom-light/internal/config"
+	"github.com/vleukhin/prom-light/internal/metrics"
+	"github.com/vleukhin/prom-light/internal/storage"
+)
+
+func newTestMapper(t *testing.T) *Mapper {
+	mapper, err := NewMapper([]config.GraphiteMapping{
+		{
+			Match:  "servers.*.cpu.*",
+			Name:   "cpu_$2",
+			Labels: map[string]string{"host": "$1"},
+		},
+		{
+			Match: "jobs.*.duration",
+			Name:  "job_duration_seconds",
+			Labels: map[string]string{
+				"job":  "$1",
+				"kind": "cron",
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	return mapper
+}
+
+func TestMapper_Map(t *testing.T) {
+	mapper := newTestMapper(t)
+
+	tests := []struct {
+		path       string
+		wantName   string
+		wantLabels metrics.Labels
+	}{
+		{
+			path:       "servers.web01.cpu.idle",
+			wantName:   "cpu_idle",
+			wantLabels: metrics.Labels{"host": "web01"},
+		},
+		{
+			path:       "jobs.backup.duration",
+			wantName:   "job_duration_seconds",
+			wantLabels: metrics.Labels{"job": "backup", "kind": "cron"},
+		},
+		{
+			path:     "servers.web01.memory.free",
+			wantName: "servers.web01.memory.free",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			name, labels := mapper.Map(tt.path)
+			assert.Equal(t, tt.wantName, name)
+			assert.Equal(t, tt.wantLabels, labels)
+		})
+	}
+
+	t.Run("Invalid mapping", func(t *testing.T) {
+		_, err := NewMapper([]config.GraphiteMapping{{Match: "a.*"}})
+		assert.Error(t, err)
+	})
+}
+
+func TestParseLine(t *testing.T) {
+	mapper := newTestMapper(t)
+
+	t.Run("Mapped path with timestamp", func(t *testing.T) {
+		m, err := parseLine("servers.web01.cpu.idle 97.5 1665000000", mapper)
+		require.NoError(t, err)
+		assert.Equal(t, `cpu_idle{host="web01"}`, m.ID())
+		assert.Equal(t, metrics.Gauge(97.5), *m.Value)
+		assert.Equal(t, time.Unix(1665000000, 0), *m.Timestamp)
+	})
+
+	t.Run("Tagged path without timestamp", func(t *testing.T) {
+		m, err := parseLine("jobs.backup.duration;kind=manual;dc=eu 12", mapper)
+		require.NoError(t, err)
+		assert.Equal(t, `job_duration_seconds{dc="eu",job="backup",kind="manual"}`, m.ID())
+		assert.WithinDuration(t, time.Now(), *m.Timestamp, time.Second)
+	})
+
+	for _, line := range []string{
+		"servers.web01.cpu.idle",
+		"servers.web01.cpu.idle abc",
+		"servers.web01.cpu.idle 1 yesterday",
+		"servers.web01.cpu.idle;bad 1",
+		"servers.web01.cpu.idle 1 2 3",
+	} {
+		t.Run(line, func(t *testing.T) {
+			_, err := parseLine(line, mapper)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestServer(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMockStorage()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := NewServer("", newTestMapper(t), store)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- server.Serve(listener)
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("servers.web01.cpu.idle 97.5 -1\nbad line\njobs.backup.duration 12\nservers.web02.cpu.idle 50 -1\n"))
+	require.NoError(t, err)
+	// Строки соединения обрабатываются по порядку: последняя принятая строка означает, что приняты все
+	require.Eventually(t, func() bool {
+		server.mutex.Lock()
+		defer server.mutex.Unlock()
+		for _, m := range server.buffer {
+			if m.Labels["host"] == "web02" {
+				return true
+			}
+		}
+		_, err := store.GetGauge(ctx, "cpu_idle", metrics.Labels{"host": "web02"})
+		return err == nil
+	}, 3*flushInterval, 5*time.Millisecond)
+
+	// Соединение остается открытым: метрики должны сохраниться при остановке сервера
+	require.NoError(t, server.Shutdown(ctx))
+	require.ErrorIs(t, <-errCh, ErrServerClosed)
+	_ = conn.Close()
+
+	store.AssertGaugeStoredWithValue(t, `cpu_idle{host="web01"}`, 97.5)
+	store.AssertGaugeStoredWithValue(t, `cpu_idle{host="web02"}`, 50)
+	store.AssertGaugeStoredWithValue(t, `job_duration_seconds{job="backup",kind="cron"}`, 12)
+}
+
+func TestServer_ShutdownBeforeListen(t *testing.T) {
+	server := NewServer("127.0.0.1:0", newTestMapper(t), storage.NewMockStorage())
+	require.NoError(t, server.Shutdown(context.Background()))
+	require.NoError(t, server.Shutdown(context.Background()))
+	require.ErrorIs(t, server.ListenAndServe(), ErrServerClosed)
+}
@@ -0,0 +1,93 @@
+package graphite
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/vleukhin/prom-light/internal/config"
+	"github.com/vleukhin/prom-light/internal/metrics"
+)
+
+// rule скомпилированное правило преобразования пути Graphite в метрику
+type rule struct {
+	segments []string
+	name     string
+	labels   metrics.Labels
+}
+
+// Mapper преобразует пути Graphite в имена и метки метрик
+type Mapper struct {
+	rules []rule
+}
+
+// NewMapper создает преобразователь путей по правилам. Правила проверяются по порядку,
+// применяется первое подходящее. Путь, не подошедший ни под одно правило, становится именем метрики
+func NewMapper(mappings []config.GraphiteMapping) (*Mapper, error) {
+	m := &Mapper{rules: make([]rule, 0, len(mappings))}
+	for _, mapping := range mappings {
+		if mapping.Match == "" || mapping.Name == "" {
+			return nil, errors.New("graphite mapping must have match and name")
+		}
+		m.rules = append(m.rules, rule{
+			segments: strings.Split(mapping.Match, "."),
+			name:     mapping.Name,
+			labels:   mapping.Labels,
+		})
+	}
+
+	return m, nil
+}
+
+// Map выдает имя и метки метрики для пути
+func (m *Mapper) Map(path string) (string, metrics.Labels) {
+	segments := strings.Split(path, ".")
+	for _, r := range m.rules {
+		captures, ok := r.match(segments)
+		if !ok {
+			continue
+		}
+
+		var labels metrics.Labels
+		if len(r.labels) > 0 {
+			labels = make(metrics.Labels, len(r.labels))
+			for k, v := range r.labels {
+				labels[k] = expand(v, captures)
+			}
+		}
+
+		return expand(r.name, captures), labels
+	}
+
+	return path, nil
+}
+
+// match сопоставляет сегменты пути с шаблоном правила. Сегмент * совпадает с любым
+// сегментом пути, совпавшие сегменты выдаются в порядке следования
+func (r rule) match(segments []string) ([]string, bool) {
+	if len(segments) != len(r.segments) {
+		return nil, false
+	}
+
+	var captures []string
+	for i, pattern := range r.segments {
+		if pattern == "*" {
+			captures = append(captures, segments[i])
+			continue
+		}
+		if pattern != segments[i] {
+			return nil, false
+		}
+	}
+
+	return captures, true
+}
+
+// expand подставляет совпавшие сегменты вместо $1, $2 и т.д.
+func expand(template string, captures []string) string {
+	for i := len(captures); i > 0; i-- {
+		template = strings.ReplaceAll(template, "$"+strconv.Itoa(i), captures[i-1])
+	}
+
+	return template
+}
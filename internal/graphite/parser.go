@@ -0,0 +1,61 @@
+package graphite
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vleukhin/prom-light/internal/metrics"
+)
+
+// parseLine разбирает строку вида path[;tag=value...] value [timestamp] и преобразует ее в gauge.
+// Отсутствующая или отрицательная временная метка означает текущее время
+func parseLine(line string, mapper *Mapper) (metrics.Metric, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || len(fields) > 3 {
+		return metrics.Metric{}, errors.New("expected path, value and optional timestamp")
+	}
+
+	path, rawTags, _ := strings.Cut(fields[0], ";")
+	if path == "" {
+		return metrics.Metric{}, errors.New("missing metric path")
+	}
+
+	value, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil || math.IsNaN(value) {
+		return metrics.Metric{}, errors.New("invalid metric value: " + fields[1])
+	}
+
+	at := time.Now()
+	if len(fields) == 3 {
+		ts, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			return metrics.Metric{}, errors.New("invalid timestamp: " + fields[2])
+		}
+		if ts >= 0 {
+			sec, frac := math.Modf(ts)
+			at = time.Unix(int64(sec), int64(frac*float64(time.Second)))
+		}
+	}
+
+	name, labels := mapper.Map(path)
+	if rawTags != "" {
+		tags := make(metrics.Labels)
+		for _, tag := range strings.Split(rawTags, ";") {
+			k, v, found := strings.Cut(tag, "=")
+			if !found || k == "" || v == "" {
+				return metrics.Metric{}, errors.New("invalid tag: " + tag)
+			}
+			tags[k] = v
+		}
+		labels = labels.Merge(tags)
+	}
+
+	m := metrics.MakeGaugeMetric(name, metrics.Gauge(value))
+	m.Labels = labels
+	m.Timestamp = &at
+
+	return m, nil
+}
@@ -0,0 +1,96 @@
+package graphite
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/vleukhin/prom-light/internal/lineserver"
+	"github.com/vleukhin/prom-light/internal/metrics"
+	"github.com/vleukhin/prom-light/internal/storage"
+)
+
+// ErrServerClosed возвращается ListenAndServe после вызова Shutdown
+var ErrServerClosed = errors.New("graphite: server closed")
+
+// flushInterval интервал сохранения принятых метрик в хранилище
+const flushInterval = time.Second
+
+// Server принимает метрики по протоколу Graphite plaintext через TCP
+type Server struct {
+	addr   string
+	mapper *Mapper
+	lines  *lineserver.Server
+
+	mutex  sync.Mutex
+	buffer metrics.Metrics
+}
+
+// NewServer создает Graphite сервер
+func NewServer(addr string, mapper *Mapper, store storage.MetricsSetter) *Server {
+	s := &Server{
+		addr:   addr,
+		mapper: mapper,
+	}
+	s.lines = lineserver.New(lineserver.Options{
+		Name:          "Graphite",
+		FlushInterval: flushInterval,
+		Collect:       s.collect,
+		Store:         store,
+		ErrClosed:     ErrServerClosed,
+	})
+
+	return s
+}
+
+// ListenAndServe начинает прием метрик и блокируется до вызова Shutdown
+func (s *Server) ListenAndServe() error {
+	listener, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(listener)
+}
+
+// Serve принимает метрики из соединений listener и блокируется до вызова Shutdown
+func (s *Server) Serve(listener net.Listener) error {
+	return s.lines.ServeTCP(listener, s.handleLine)
+}
+
+// Shutdown прекращает прием соединений, закрывает открытые соединения
+// и сохраняет принятые метрики. Если контекст завершится раньше, возвращается его ошибка
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.lines.Shutdown(ctx)
+}
+
+func (s *Server) handleLine(line string) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+	m, err := parseLine(line, s.mapper)
+	if err != nil {
+		log.Debug().Msgf("Skipping bad Graphite line %q: %s", line, err)
+		return
+	}
+
+	s.mutex.Lock()
+	s.buffer = append(s.buffer, m)
+	s.mutex.Unlock()
+}
+
+// collect забирает принятые метрики
+func (s *Server) collect() metrics.Metrics {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	mtrcs := s.buffer
+	s.buffer = nil
+
+	return mtrcs
+}
@@ -0,0 +1,174 @@
+package httphandlers
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/vleukhin/prom-light/internal/config"
+	"github.com/vleukhin/prom-light/internal/influx"
+	"github.com/vleukhin/prom-light/internal/metrics"
+	"github.com/vleukhin/prom-light/internal/storage"
+)
+
+// counterFieldSuffix суффикс полей, которые сохраняются как counter
+const counterFieldSuffix = "_total"
+
+// WriteLineProtocol принимает метрики в формате InfluxDB line protocol.
+// Теги становятся метками, каждое числовое поле - отдельной метрикой measurement_field
+// (или measurement для поля value). Поля с суффиксом _total считаются накопительными
+// счетчиками и сохраняются как counter, остальные - как gauge. Строковые поля пропускаются.
+// Если задан ключ подписи, тело запроса должно быть подписано в заголовке Hash.
+// Пересчет накопленных значений в приращения защищен блокировкой внутри процесса, поэтому
+// счетчики верны, только если line protocol принимает один экземпляр сервера
+func (c MetricsController) WriteLineProtocol(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	body, err := readBody(r)
+	if err != nil {
+		log.Error().Msg(err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if !c.isValidBody(body, r.Header.Get(config.HashHeader)) {
+		log.Error().Msg("Invalid hash of line protocol body")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	precision, ok := influx.Precision[r.URL.Query().Get("precision")]
+	if !ok {
+		http.Error(w, "unknown precision", http.StatusBadRequest)
+		return
+	}
+
+	var points []influx.Point
+	identity := c.identityLabels(r)
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		point, err := influx.ParseLine(line, precision)
+		if err != nil {
+			http.Error(w, "line "+strconv.Itoa(n)+": "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if identity != nil {
+			point.Tags = point.Tags.Merge(identity)
+		}
+		points = append(points, point)
+	}
+
+	// Приращения счетчиков считаются от сохраненных значений, поэтому запись
+	// выполняется под той же блокировкой, что и чтение
+	c.counterMutex.Lock()
+	defer c.counterMutex.Unlock()
+
+	var mtrcs metrics.Metrics
+	counters := make(map[string]metrics.Counter)
+	for _, point := range points {
+		pointMetrics, err := c.pointToMetrics(r.Context(), point, counters)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to get stored counter")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		mtrcs = append(mtrcs, pointMetrics...)
+	}
+
+	log.Debug().Msgf("Received %d metrics via line protocol", len(mtrcs))
+
+	if err := c.store.SetMetrics(r.Context(), mtrcs); err != nil {
+		log.Error().Msg(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// pointToMetrics преобразует точку в метрики. Накопительные значения счетчиков
+// переводятся в приращение относительно сохраненного значения или значения,
+// уже полученного в этом запросе. counters хранит значения счетчиков текущего запроса.
+// Приращением считается все значение, только если счетчика нет в хранилище: при ошибке
+// чтения это значение завысило бы счетчик навсегда
+func (c MetricsController) pointToMetrics(ctx context.Context, point influx.Point, counters map[string]metrics.Counter) (metrics.Metrics, error) {
+	result := make(metrics.Metrics, 0, len(point.Fields))
+	for _, f := range point.Fields {
+		if f.Type == influx.FieldString {
+			continue
+		}
+		name := point.Measurement
+		if f.Key != "value" {
+			name += "_" + f.Key
+		}
+
+		var m metrics.Metric
+		if strings.HasSuffix(f.Key, counterFieldSuffix) {
+			value := metrics.Counter(f.Value)
+			id := metrics.Metric{Name: name, Labels: point.Tags}.ID()
+			previous, ok := counters[id]
+			if !ok {
+				stored, err := c.store.GetCounter(ctx, name, point.Tags)
+				if err != nil && !errors.Is(err, storage.ErrMetricNotFound) {
+					return nil, err
+				}
+				previous, ok = stored, err == nil
+			}
+			counters[id] = value
+
+			delta := value
+			// Значение меньше предыдущего означает сброс счетчика у источника
+			if ok && value >= previous {
+				delta -= previous
+			}
+			m = metrics.MakeCounterMetric(name, delta)
+		} else {
+			m = metrics.MakeGaugeMetric(name, metrics.Gauge(f.Value))
+		}
+		m.Labels = point.Tags
+		m.Timestamp = point.Timestamp
+		result = append(result, m)
+	}
+
+	return result, nil
+}
+
+// isValidBody проверяет подпись тела запроса
+func (c MetricsController) isValidBody(body []byte, hash string) bool {
+	if c.hasher == nil {
+		return true
+	}
+	c.hasher.Write(body)
+	defer c.hasher.Reset()
+
+	expected := hex.EncodeToString(c.hasher.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(hash))
+}
+
+// readBody читает тело запроса, распаковывая его при необходимости
+func readBody(r *http.Request) ([]byte, error) {
+	if r.Header.Get("Content-Encoding") != "gzip" {
+		return io.ReadAll(r.Body)
+	}
+
+	gz, err := gzip.NewReader(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	return io.ReadAll(gz)
+}
@@ -2,37 +2,65 @@ package httphandlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"hash"
 	"io"
 	"net/http"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/rs/zerolog/log"
 
+	"github.com/vleukhin/prom-light/internal/crypt"
 	"github.com/vleukhin/prom-light/internal/metrics"
 
 	"github.com/vleukhin/prom-light/internal/storage"
 )
 
 type MetricsController struct {
-	store  storage.MetricsStorage
-	hasher hash.Hash
+	store         storage.MetricsStorage
+	hasher        hash.Hash
+	identityLabel string
+	// counterMutex защищает пересчет накопленных значений счетчиков line protocol в приращения:
+	// между чтением сохраненного значения и записью приращения его не должен изменить другой запрос.
+	// Запросы к другим экземплярам сервера с общей БД блокировка не видит
+	counterMutex *sync.Mutex
 }
 
-func NewMetricsController(storage storage.MetricsStorage, hasher hash.Hash) MetricsController {
+// NewMetricsController создает контроллер метрик. Если задан identityLabel, к метрикам,
+// полученным от клиента с проверенным сертификатом, добавляется метка с его идентификатором
+func NewMetricsController(storage storage.MetricsStorage, hasher hash.Hash, identityLabel string) MetricsController {
 	return MetricsController{
-		store:  storage,
-		hasher: hasher,
+		store:         storage,
+		hasher:        hasher,
+		identityLabel: identityLabel,
+		counterMutex:  &sync.Mutex{},
 	}
 }
 
+// identityLabels выдает метку с идентификатором клиента из его сертификата.
+// Метка заменяет одноименную метку, присланную клиентом
+func (c MetricsController) identityLabels(r *http.Request) metrics.Labels {
+	if c.identityLabel == "" {
+		return nil
+	}
+	identity := crypt.PeerIdentity(r.TLS)
+	if identity == "" {
+		return nil
+	}
+
+	return metrics.Labels{c.identityLabel: identity}
+}
+
 func (c MetricsController) UpdateMetric(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	m := metrics.Metric{
-		Name: params["name"],
-		Type: params["type"],
+		Name:   params["name"],
+		Type:   params["type"],
+		Labels: labelsFromQuery(r),
 	}
 
 	switch params["type"] {
@@ -54,10 +82,16 @@ func (c MetricsController) UpdateMetric(w http.ResponseWriter, r *http.Request)
 		log.Debug().Msgf("Received counter %s with value %d \n", params["name"], rawValue)
 		value := metrics.Counter(rawValue)
 		m.Delta = &value
+	case metrics.HistogramTypeName, metrics.SummaryTypeName:
+		http.Error(w, params["type"]+" can only be updated with JSON API", http.StatusBadRequest)
+		return
 	default:
 		w.WriteHeader(http.StatusNotImplemented)
 		return
 	}
+	if identity := c.identityLabels(r); identity != nil {
+		m.Labels = m.Labels.Merge(identity)
+	}
 
 	err := c.store.SetMetric(r.Context(), m)
 	if err != nil {
@@ -97,6 +131,9 @@ func (c MetricsController) UpdateMetricJSON(w http.ResponseWriter, r *http.Reque
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	if identity := c.identityLabels(r); identity != nil {
+		m.Labels = m.Labels.Merge(identity)
+	}
 
 	err = c.store.SetMetric(r.Context(), m)
 	if err != nil {
@@ -131,6 +168,11 @@ func (c MetricsController) UpdateMetricsBatch(w http.ResponseWriter, r *http.Req
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	if identity := c.identityLabels(r); identity != nil {
+		for i := range mtrcs {
+			mtrcs[i].Labels = mtrcs[i].Labels.Merge(identity)
+		}
+	}
 
 	err = c.store.SetMetrics(r.Context(), mtrcs)
 	if err != nil {
@@ -146,7 +188,7 @@ func (c MetricsController) GetMetric(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("Content-type", "text/html")
 	switch params["type"] {
 	case metrics.GaugeTypeName:
-		value, err := c.store.GetGauge(r.Context(), params["name"])
+		value, err := c.store.GetGauge(r.Context(), params["name"], labelsFromQuery(r))
 		if err != nil {
 			w.WriteHeader(http.StatusNotFound)
 			return
@@ -159,7 +201,7 @@ func (c MetricsController) GetMetric(w http.ResponseWriter, r *http.Request) {
 		}
 
 	case metrics.CounterTypeName:
-		value, err := c.store.GetCounter(r.Context(), params["name"])
+		value, err := c.store.GetCounter(r.Context(), params["name"], labelsFromQuery(r))
 		if err != nil {
 			w.WriteHeader(http.StatusNotFound)
 			return
@@ -169,6 +211,28 @@ func (c MetricsController) GetMetric(w http.ResponseWriter, r *http.Request) {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
+	case metrics.HistogramTypeName:
+		value, err := c.store.GetHistogram(r.Context(), params["name"], labelsFromQuery(r))
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, err = w.Write([]byte(value.String()))
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	case metrics.SummaryTypeName:
+		value, err := c.store.GetSummary(r.Context(), params["name"], labelsFromQuery(r))
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, err = w.Write([]byte(value.String()))
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
 	default:
 		w.WriteHeader(http.StatusNotImplemented)
 		return
@@ -196,7 +260,7 @@ func (c MetricsController) GetMetricJSON(w http.ResponseWriter, r *http.Request)
 
 	switch m.Type {
 	case metrics.GaugeTypeName:
-		value, err := c.store.GetGauge(r.Context(), m.Name)
+		value, err := c.store.GetGauge(r.Context(), m.Name, m.Labels)
 		if err != nil {
 			log.Error().Msg(err.Error())
 			w.WriteHeader(http.StatusNotFound)
@@ -205,13 +269,31 @@ func (c MetricsController) GetMetricJSON(w http.ResponseWriter, r *http.Request)
 		m.Value = &value
 
 	case metrics.CounterTypeName:
-		value, err := c.store.GetCounter(r.Context(), m.Name)
+		value, err := c.store.GetCounter(r.Context(), m.Name, m.Labels)
 		if err != nil {
 			log.Error().Msg(err.Error())
 			w.WriteHeader(http.StatusNotFound)
 			return
 		}
 		m.Delta = &value
+
+	case metrics.HistogramTypeName:
+		value, err := c.store.GetHistogram(r.Context(), m.Name, m.Labels)
+		if err != nil {
+			log.Error().Msg(err.Error())
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		m.Histogram = &value
+
+	case metrics.SummaryTypeName:
+		value, err := c.store.GetSummary(r.Context(), m.Name, m.Labels)
+		if err != nil {
+			log.Error().Msg(err.Error())
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		m.Summary = &value
 	}
 
 	m.Sign(c.hasher)
@@ -227,3 +309,108 @@ func (c MetricsController) GetMetricJSON(w http.ResponseWriter, r *http.Request)
 		w.WriteHeader(http.StatusInternalServerError)
 	}
 }
+
+// DeleteMetric удаляет метрику вместе с историей. Если в query-параметрах переданы метки,
+// удаляются только серии с этими метками, иначе все серии метрики
+func (c MetricsController) DeleteMetric(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	switch params["type"] {
+	case metrics.GaugeTypeName, metrics.CounterTypeName, metrics.HistogramTypeName, metrics.SummaryTypeName:
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+
+	var err error
+	if labels := labelsFromQuery(r); labels != nil {
+		var deleted int
+		deleted, err = c.store.DeleteByMatcher(r.Context(), storage.Matcher{
+			Types:  []string{params["type"]},
+			Name:   params["name"],
+			Labels: labels,
+		})
+		if err == nil && deleted == 0 {
+			err = storage.ErrMetricNotFound
+		}
+	} else {
+		err = c.store.DeleteMetric(r.Context(), params["type"], params["name"])
+	}
+	if errors.Is(err, storage.ErrMetricNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error().Msg(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	log.Info().Msgf("Deleted %s %s", params["type"], params["name"])
+
+	_, err = w.Write([]byte("Deleted"))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// GetMetricHistory отдает историю значений метрики за период [from, to] в формате JSON.
+// По умолчанию отдается история за последний час
+func (c MetricsController) GetMetricHistory(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	query := r.URL.Query()
+
+	to := time.Now()
+	if raw := query.Get("to"); raw != "" {
+		parsed, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		to = parsed
+	}
+	from := to.Add(-time.Hour)
+	if raw := query.Get("from"); raw != "" {
+		parsed, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		from = parsed
+	}
+
+	history, err := c.store.GetRange(r.Context(), params["name"], from, to)
+	if err != nil {
+		log.Error().Msg(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if history == nil {
+		history = metrics.Metrics{}
+	}
+
+	respBody, err := json.Marshal(history)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	_, err = w.Write(respBody)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// labelsFromQuery собирает метки метрики из query-параметров запроса
+func labelsFromQuery(r *http.Request) metrics.Labels {
+	query := r.URL.Query()
+	if len(query) == 0 {
+		return nil
+	}
+
+	labels := make(metrics.Labels, len(query))
+	for k := range query {
+		labels[k] = query.Get(k)
+	}
+
+	return labels
+}
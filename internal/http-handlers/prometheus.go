@@ -0,0 +1,253 @@
+package httphandlers
+
+import (
+	"bufio"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/vleukhin/prom-light/internal/metrics"
+	"github.com/vleukhin/prom-light/internal/storage"
+)
+
+// Content-Type поддерживаемых форматов выдачи метрик
+const (
+	PrometheusTextContentType = "text/plain; version=0.0.4; charset=utf-8"
+	OpenMetricsContentType    = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+// PrometheusController хэндлер для выдачи метрик в формате Prometheus
+type PrometheusController struct {
+	store storage.MetricsGetter
+}
+
+// NewPrometheusController создаёт новый хэндлер для выдачи метрик в формате Prometheus
+func NewPrometheusController(storage storage.MetricsGetter) PrometheusController {
+	return PrometheusController{
+		store: storage,
+	}
+}
+
+// metricFamily группа серий с одинаковым именем и типом
+type metricFamily struct {
+	name   string
+	typ    string
+	series metrics.Metrics
+}
+
+// Metrics отдает все метрики в текстовом формате Prometheus 0.0.4 или в формате OpenMetrics,
+// в зависимости от заголовка Accept
+func (c PrometheusController) Metrics(w http.ResponseWriter, r *http.Request) {
+	data, err := c.store.GetAllMetrics(r.Context())
+	if err != nil {
+		log.Error().Msg("Failed to get metrics: " + err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	openMetrics := acceptsOpenMetrics(r.Header.Get("Accept"))
+	if openMetrics {
+		w.Header().Set("Content-Type", OpenMetricsContentType)
+	} else {
+		w.Header().Set("Content-Type", PrometheusTextContentType)
+	}
+
+	buf := bufio.NewWriter(w)
+	for _, family := range groupFamilies(data, openMetrics) {
+		writeFamily(buf, family, openMetrics)
+	}
+	if openMetrics {
+		_, _ = buf.WriteString("# EOF\n")
+	}
+
+	if err := buf.Flush(); err != nil {
+		log.Error().Msg("Failed to write metrics: " + err.Error())
+	}
+}
+
+// groupFamilies группирует серии по именам метрик и сортирует их.
+// Серии, чье имя после приведения совпадает с метрикой другого типа, отбрасываются
+func groupFamilies(data metrics.Metrics, openMetrics bool) []*metricFamily {
+	families := make(map[string]*metricFamily)
+	for _, m := range data {
+		name := SanitizeMetricName(m.Name)
+		if openMetrics && m.Type == metrics.CounterTypeName {
+			name = strings.TrimSuffix(name, "_total")
+		}
+
+		family, ok := families[name]
+		if !ok {
+			family = &metricFamily{name: name, typ: m.Type}
+			families[name] = family
+		}
+		if family.typ != m.Type {
+			log.Warn().Msgf("Metric %s of type %s conflicts with %s %s, skipping", m.ID(), m.Type, family.typ, name)
+			continue
+		}
+		family.series = append(family.series, m)
+	}
+
+	result := make([]*metricFamily, 0, len(families))
+	for _, family := range families {
+		sort.Slice(family.series, func(i, j int) bool {
+			return formatLabels(family.series[i].Labels) < formatLabels(family.series[j].Labels)
+		})
+		result = append(result, family)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].name < result[j].name
+	})
+
+	return result
+}
+
+func writeFamily(w *bufio.Writer, family *metricFamily, openMetrics bool) {
+	typ := "untyped"
+	if openMetrics {
+		typ = "unknown"
+	}
+	switch family.typ {
+	case metrics.GaugeTypeName, metrics.CounterTypeName, metrics.HistogramTypeName, metrics.SummaryTypeName:
+		typ = family.typ
+	}
+	_, _ = w.WriteString("# TYPE " + family.name + " " + typ + "\n")
+
+	for _, m := range family.series {
+		name := family.name
+		switch m.Type {
+		case metrics.GaugeTypeName:
+			writeSample(w, name, m.Labels, formatFloat(float64(*m.Value)))
+		case metrics.CounterTypeName:
+			if openMetrics {
+				name += "_total"
+			}
+			writeSample(w, name, m.Labels, strconv.FormatInt(int64(*m.Delta), 10))
+		case metrics.HistogramTypeName:
+			for _, b := range m.Histogram.Buckets {
+				le := metrics.Labels{"le": formatFloat(b.UpperBound)}
+				writeSample(w, name+"_bucket", m.Labels.Merge(le), strconv.FormatUint(b.Count, 10))
+			}
+			count := strconv.FormatUint(m.Histogram.Count, 10)
+			writeSample(w, name+"_bucket", m.Labels.Merge(metrics.Labels{"le": "+Inf"}), count)
+			writeSample(w, name+"_sum", m.Labels, formatFloat(m.Histogram.Sum))
+			writeSample(w, name+"_count", m.Labels, count)
+		case metrics.SummaryTypeName:
+			for _, q := range m.Summary.Quantiles {
+				quantile := metrics.Labels{"quantile": formatFloat(q.Quantile)}
+				writeSample(w, name, m.Labels.Merge(quantile), formatFloat(q.Value))
+			}
+			writeSample(w, name+"_sum", m.Labels, formatFloat(m.Summary.Sum))
+			writeSample(w, name+"_count", m.Labels, strconv.FormatUint(m.Summary.Count, 10))
+		}
+	}
+}
+
+func writeSample(w *bufio.Writer, name string, labels metrics.Labels, value string) {
+	_, _ = w.WriteString(name + formatLabels(labels) + " " + value + "\n")
+}
+
+// acceptsOpenMetrics определяет, предпочитает ли клиент формат OpenMetrics текстовому формату Prometheus
+func acceptsOpenMetrics(accept string) bool {
+	var openMetricsQ, textQ float64
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+		q := 1.0
+		for _, param := range params[1:] {
+			k, v, found := strings.Cut(strings.TrimSpace(param), "=")
+			if !found || strings.ToLower(k) != "q" {
+				continue
+			}
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+
+		switch mediaType {
+		case "application/openmetrics-text":
+			if q > openMetricsQ {
+				openMetricsQ = q
+			}
+		case "text/plain", "text/*", "*/*":
+			if q > textQ {
+				textQ = q
+			}
+		}
+	}
+
+	return openMetricsQ > 0 && openMetricsQ >= textQ
+}
+
+// SanitizeMetricName приводит имя метрики к виду [a-zA-Z_:][a-zA-Z0-9_:]*
+func SanitizeMetricName(name string) string {
+	return sanitizeName(name, true)
+}
+
+// SanitizeLabelName приводит имя метки к виду [a-zA-Z_][a-zA-Z0-9_]*
+func SanitizeLabelName(name string) string {
+	return sanitizeName(name, false)
+}
+
+func sanitizeName(name string, allowColon bool) string {
+	if name == "" {
+		return "_"
+	}
+
+	var b strings.Builder
+	for i, r := range name {
+		valid := r == '_' ||
+			(r >= 'a' && r <= 'z') ||
+			(r >= 'A' && r <= 'Z') ||
+			(r == ':' && allowColon) ||
+			(r >= '0' && r <= '9' && i > 0)
+		if r >= '0' && r <= '9' && i == 0 {
+			b.WriteRune('_')
+			valid = true
+		}
+		if valid {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('_')
+		}
+	}
+
+	return b.String()
+}
+
+// formatLabels выдает метки в формате {a="1",b="2"} с отсортированными и приведенными именами
+func formatLabels(labels metrics.Labels) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	sanitized := make(map[string]string, len(labels))
+	names := make([]string, 0, len(labels))
+	for k, v := range labels {
+		name := SanitizeLabelName(k)
+		if _, ok := sanitized[name]; !ok {
+			names = append(names, name)
+		}
+		sanitized[name] = v
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, name+`="`+escapeLabelValue(sanitized[name])+`"`)
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(v string) string {
+	return labelValueReplacer.Replace(v)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,108 @@
+package httphandlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/vleukhin/prom-light/internal/metrics"
+	"github.com/vleukhin/prom-light/internal/storage"
+)
+
+// Ограничения размера страницы списка метрик
+const (
+	defaultQueryLimit = 100
+	maxQueryLimit     = 1000
+)
+
+// ListMetrics отдает страницу метрик в формате JSON. Параметры запроса:
+// type - тип метрики (можно указать несколько раз), name - имя, name_prefix - префикс имени,
+// name_regex - регулярное выражение для имени, label - метка вида key=value (можно указать несколько раз),
+// sort - поле сортировки name или type, с префиксом "-" по убыванию,
+// limit - размер страницы, cursor - курсор следующей страницы из next_cursor
+func (c MetricsController) ListMetrics(w http.ResponseWriter, r *http.Request) {
+	q, err := parseMetricsQuery(r)
+	if err == nil {
+		err = q.Validate()
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := c.store.QueryMetrics(r.Context(), q)
+	if err != nil {
+		if errors.Is(err, storage.ErrInvalidQuery) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Error().Msg(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	page.Metrics = page.Metrics.Sign(c.hasher)
+
+	respBody, err := json.Marshal(page)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	_, err = w.Write(respBody)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func parseMetricsQuery(r *http.Request) (storage.MetricsQuery, error) {
+	query := r.URL.Query()
+	q := storage.MetricsQuery{
+		Matcher: storage.Matcher{
+			Types:      query["type"],
+			Name:       query.Get("name"),
+			NamePrefix: query.Get("name_prefix"),
+			NameRegex:  query.Get("name_regex"),
+		},
+		Cursor: query.Get("cursor"),
+		Limit:  defaultQueryLimit,
+	}
+
+	for _, t := range q.Types {
+		switch t {
+		case metrics.GaugeTypeName, metrics.CounterTypeName, metrics.HistogramTypeName, metrics.SummaryTypeName:
+		default:
+			return q, errors.New("unknown metric type: " + t)
+		}
+	}
+
+	for _, raw := range query["label"] {
+		k, v, found := strings.Cut(raw, "=")
+		if !found || k == "" {
+			return q, errors.New("label must be key=value: " + raw)
+		}
+		if q.Labels == nil {
+			q.Labels = make(metrics.Labels)
+		}
+		q.Labels[k] = v
+	}
+
+	if sortBy := query.Get("sort"); sortBy != "" {
+		q.Desc = strings.HasPrefix(sortBy, "-")
+		q.SortBy = strings.TrimPrefix(sortBy, "-")
+	}
+
+	if raw := query.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > maxQueryLimit {
+			return q, errors.New("limit must be between 1 and " + strconv.Itoa(maxQueryLimit))
+		}
+		q.Limit = limit
+	}
+
+	return q, nil
+}
@@ -0,0 +1,105 @@
+package httphandlers
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/rs/zerolog/log"
+	pb "google.golang.org/protobuf/proto"
+
+	"github.com/vleukhin/prom-light/internal/metrics"
+	"github.com/vleukhin/prom-light/internal/proto"
+	"github.com/vleukhin/prom-light/internal/storage"
+)
+
+// metricNameLabel метка, в которой Prometheus передает имя метрики
+const metricNameLabel = "__name__"
+
+// RemoteWriteController хэндлер для приема метрик по протоколу Prometheus remote_write
+type RemoteWriteController struct {
+	store storage.MetricsSetter
+}
+
+// NewRemoteWriteController создаёт новый хэндлер для приема метрик по протоколу Prometheus remote_write
+func NewRemoteWriteController(storage storage.MetricsSetter) RemoteWriteController {
+	return RemoteWriteController{
+		store: storage,
+	}
+}
+
+// Write принимает сжатый snappy protobuf WriteRequest и сохраняет все значения серий как gauge
+func (c RemoteWriteController) Write(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	compressed, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Error().Msg(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	data, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		log.Error().Msg("Failed to decompress remote write request: " + err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var req proto.WriteRequest
+	if err := pb.Unmarshal(data, &req); err != nil {
+		log.Error().Msg("Failed to parse remote write request: " + err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	mtrcs := FromWriteRequest(&req)
+	log.Debug().Msgf("Received %d samples via remote write", len(mtrcs))
+
+	if err := c.store.SetMetrics(r.Context(), mtrcs); err != nil {
+		log.Error().Msg(err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// FromWriteRequest преобразует серии remote_write в метрики.
+// Remote write не передает тип метрики, поэтому все значения сохраняются как gauge.
+// Серии без имени и значения NaN (в том числе метки устаревания) отбрасываются
+func FromWriteRequest(req *proto.WriteRequest) metrics.Metrics {
+	var result metrics.Metrics
+	for _, ts := range req.Timeseries {
+		var name string
+		var labels metrics.Labels
+		for _, l := range ts.Labels {
+			if l.Name == metricNameLabel {
+				name = l.Value
+				continue
+			}
+			if labels == nil {
+				labels = make(metrics.Labels, len(ts.Labels))
+			}
+			labels[l.Name] = l.Value
+		}
+		if name == "" {
+			log.Warn().Msg("Skipping remote write series without name")
+			continue
+		}
+
+		for _, s := range ts.Samples {
+			if math.IsNaN(s.Value) {
+				continue
+			}
+			m := metrics.MakeGaugeMetric(name, metrics.Gauge(s.Value))
+			m.Labels = labels
+			at := time.UnixMilli(s.Timestamp)
+			m.Timestamp = &at
+			result = append(result, m)
+		}
+	}
+
+	return result
+}
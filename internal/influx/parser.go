@@ -0,0 +1,177 @@
+package influx
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vleukhin/prom-light/internal/metrics"
+)
+
+// FieldType тип значения поля
+type FieldType int
+
+const (
+	FieldFloat FieldType = iota
+	FieldInteger
+	FieldUnsigned
+	FieldBoolean
+	FieldString
+)
+
+// Field поле точки
+type Field struct {
+	Key   string
+	Type  FieldType
+	Value float64
+}
+
+// Point одна строка line protocol
+type Point struct {
+	Measurement string
+	Tags        metrics.Labels
+	Fields      []Field
+	Timestamp   *time.Time
+}
+
+// Precision определяет единицы измерения временных меток
+var Precision = map[string]time.Duration{
+	"":   time.Nanosecond,
+	"ns": time.Nanosecond,
+	"n":  time.Nanosecond,
+	"us": time.Microsecond,
+	"u":  time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+}
+
+// ParseLine разбирает строку вида measurement[,tag=value...] field=value[,field2=value2] [timestamp].
+// precision задает единицы измерения временной метки
+func ParseLine(line string, precision time.Duration) (Point, error) {
+	var p Point
+
+	sections := splitUnescaped(line, ' ', true)
+	if len(sections) < 2 || len(sections) > 3 {
+		return p, errors.New("invalid line: expected measurement, fields and optional timestamp")
+	}
+
+	keys := splitUnescaped(sections[0], ',', false)
+	p.Measurement = unescape(keys[0])
+	if p.Measurement == "" {
+		return p, errors.New("missing measurement")
+	}
+	for _, tag := range keys[1:] {
+		k, v, found := cutUnescaped(tag, '=')
+		if !found || k == "" || v == "" {
+			return p, errors.New("invalid tag: " + tag)
+		}
+		if p.Tags == nil {
+			p.Tags = make(metrics.Labels, len(keys)-1)
+		}
+		p.Tags[unescape(k)] = unescape(v)
+	}
+
+	for _, raw := range splitUnescaped(sections[1], ',', true) {
+		k, v, found := cutUnescaped(raw, '=')
+		if !found || k == "" || v == "" {
+			return p, errors.New("invalid field: " + raw)
+		}
+		field, err := parseField(unescape(k), v)
+		if err != nil {
+			return p, err
+		}
+		p.Fields = append(p.Fields, field)
+	}
+
+	if len(sections) == 3 {
+		ts, err := strconv.ParseInt(sections[2], 10, 64)
+		if err != nil {
+			return p, errors.New("invalid timestamp: " + sections[2])
+		}
+		// С минутами и часами переполнение наносекунд возможно уже у правдоподобных на вид значений
+		if precision > 1 && (ts > math.MaxInt64/int64(precision) || ts < math.MinInt64/int64(precision)) {
+			return p, errors.New("timestamp out of range: " + sections[2])
+		}
+		at := time.Unix(0, ts*int64(precision))
+		p.Timestamp = &at
+	}
+
+	return p, nil
+}
+
+func parseField(key, raw string) (Field, error) {
+	f := Field{Key: key}
+	var err error
+	switch {
+	case raw[0] == '"':
+		f.Type = FieldString
+	case raw == "t" || raw == "T" || raw == "true" || raw == "True" || raw == "TRUE":
+		f.Type = FieldBoolean
+		f.Value = 1
+	case raw == "f" || raw == "F" || raw == "false" || raw == "False" || raw == "FALSE":
+		f.Type = FieldBoolean
+	case strings.HasSuffix(raw, "i"):
+		f.Type = FieldInteger
+		var v int64
+		v, err = strconv.ParseInt(raw[:len(raw)-1], 10, 64)
+		f.Value = float64(v)
+	case strings.HasSuffix(raw, "u"):
+		f.Type = FieldUnsigned
+		var v uint64
+		v, err = strconv.ParseUint(raw[:len(raw)-1], 10, 64)
+		f.Value = float64(v)
+	default:
+		f.Type = FieldFloat
+		f.Value, err = strconv.ParseFloat(raw, 64)
+	}
+	if err != nil {
+		return f, errors.New("invalid value of field " + key + ": " + raw)
+	}
+
+	return f, nil
+}
+
+// splitUnescaped делит строку по неэкранированному разделителю.
+// Если quotes установлен, разделители внутри двойных кавычек игнорируются
+func splitUnescaped(s string, sep byte, quotes bool) []string {
+	var result []string
+	inQuotes := false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case s[i] == '"' && quotes:
+			inQuotes = !inQuotes
+		case s[i] == sep && !inQuotes:
+			result = append(result, s[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(result, s[start:])
+}
+
+// cutUnescaped делит строку по первому неэкранированному разделителю
+func cutUnescaped(s string, sep byte) (string, string, bool) {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case sep:
+			return s[:i], s[i+1:], true
+		}
+	}
+
+	return s, "", false
+}
+
+var unescaper = strings.NewReplacer(`\,`, ",", `\ `, " ", `\=`, "=", `\"`, `"`, `\\`, `\`)
+
+func unescape(s string) string {
+	return unescaper.Replace(s)
+}
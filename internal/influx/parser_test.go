@@ -0,0 +1,119 @@
+package influx
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vleukhin/prom-light/internal/metrics"
+)
+
+func TestParseLine(t *testing.T) {
+	ts := time.Unix(1465839830, 100400200)
+	tsSeconds := time.Unix(1465839830, 0)
+	tsHours := time.Unix(407177*3600, 0)
+
+	tests := []struct {
+		name      string
+		line      string
+		precision time.Duration
+		want      Point
+		wantErr   bool
+	}{
+		{
+			name: "Single field",
+			line: "cpu usage_idle=98.5",
+			want: Point{
+				Measurement: "cpu",
+				Fields:      []Field{{Key: "usage_idle", Type: FieldFloat, Value: 98.5}},
+			},
+		},
+		{
+			name:      "Tags, fields and timestamp",
+			line:      "mem,host=server01,region=us-west used=1024i,free=512u,swap=f 1465839830100400200",
+			precision: time.Nanosecond,
+			want: Point{
+				Measurement: "mem",
+				Tags:        metrics.Labels{"host": "server01", "region": "us-west"},
+				Fields: []Field{
+					{Key: "used", Type: FieldInteger, Value: 1024},
+					{Key: "free", Type: FieldUnsigned, Value: 512},
+					{Key: "swap", Type: FieldBoolean, Value: 0},
+				},
+				Timestamp: &ts,
+			},
+		},
+		{
+			name:      "Seconds precision",
+			line:      "up value=true 1465839830",
+			precision: time.Second,
+			want: Point{
+				Measurement: "up",
+				Fields:      []Field{{Key: "value", Type: FieldBoolean, Value: 1}},
+				Timestamp:   &tsSeconds,
+			},
+		},
+		{
+			name:      "Hours precision",
+			line:      "up value=1 407177",
+			precision: time.Hour,
+			want: Point{
+				Measurement: "up",
+				Fields:      []Field{{Key: "value", Type: FieldFloat, Value: 1}},
+				Timestamp:   &tsHours,
+			},
+		},
+		{
+			name: "Escaped characters and strings",
+			line: `disk\ io,path=/mnt/my\ disk,label=a\,b status="ok, fine",reads=3i`,
+			want: Point{
+				Measurement: "disk io",
+				Tags:        metrics.Labels{"path": "/mnt/my disk", "label": "a,b"},
+				Fields: []Field{
+					{Key: "status", Type: FieldString},
+					{Key: "reads", Type: FieldInteger, Value: 3},
+				},
+			},
+		},
+		{
+			name:    "No fields",
+			line:    "cpu,host=a",
+			wantErr: true,
+		},
+		{
+			name:    "Bad field value",
+			line:    "cpu usage=abc",
+			wantErr: true,
+		},
+		{
+			name:    "Bad tag",
+			line:    "cpu,host usage=1",
+			wantErr: true,
+		},
+		{
+			name:    "Bad timestamp",
+			line:    "cpu usage=1 yesterday",
+			wantErr: true,
+		},
+		{
+			name:      "Timestamp out of range",
+			line:      "cpu usage=1 153722867280912931",
+			precision: time.Minute,
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseLine(tt.line, tt.precision)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
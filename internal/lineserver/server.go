@@ -0,0 +1,209 @@
+package lineserver
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/vleukhin/prom-light/internal/metrics"
+	"github.com/vleukhin/prom-light/internal/storage"
+)
+
+// maxPending сколько метрик, которые не удалось сохранить, ждет следующего сохранения.
+// Более старые метрики сверх этого количества отбрасываются
+const maxPending = 100000
+
+// Options настройки сервера
+type Options struct {
+	// Name название протокола в журнале, например StatsD
+	Name string
+	// FlushInterval интервал сохранения принятых метрик
+	FlushInterval time.Duration
+	// Collect забирает метрики, принятые с прошлого сохранения
+	Collect func() metrics.Metrics
+	// Store хранилище принятых метрик
+	Store storage.MetricsSetter
+	// ErrClosed возвращается из Serve и ServeTCP после вызова Shutdown
+	ErrClosed error
+}
+
+// Server жизненный цикл серверов, принимающих метрики построчно: учет слушателей и соединений,
+// периодическое сохранение принятых метрик и остановка с сохранением остатка
+type Server struct {
+	opts Options
+
+	mutex     sync.Mutex
+	closers   map[io.Closer]struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	startOnce sync.Once
+	wg        sync.WaitGroup
+
+	flushMutex sync.Mutex
+	pending    metrics.Metrics
+}
+
+// New создает сервер
+func New(opts Options) *Server {
+	return &Server{
+		opts:    opts,
+		closers: make(map[io.Closer]struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// Serve выполняет serve, пока Shutdown не закроет ресурс c, например слушатель или UDP сокет.
+// Первый вызов запускает периодическое сохранение метрик. После Shutdown возвращается ErrClosed
+func (s *Server) Serve(c io.Closer, serve func() error) error {
+	if !s.track(c) {
+		return s.opts.ErrClosed
+	}
+	defer s.release(c)
+
+	s.startOnce.Do(func() {
+		s.wg.Add(1)
+		go s.flushLoop()
+	})
+
+	err := serve()
+	if s.closing() {
+		return s.opts.ErrClosed
+	}
+
+	return err
+}
+
+// ServeTCP принимает соединения и передает каждую полученную строку в handleLine
+func (s *Server) ServeTCP(listener net.Listener, handleLine func(line string)) error {
+	return s.Serve(listener, func() error {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return err
+			}
+			if s.track(conn) {
+				go s.handleConn(conn, handleLine)
+			}
+		}
+	})
+}
+
+// Shutdown прекращает прием метрик, закрывает открытые соединения и сохраняет принятые метрики.
+// Если контекст завершится раньше, возвращается его ошибка. Повторный вызов безопасен
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mutex.Lock()
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+	for c := range s.closers {
+		_ = c.Close()
+	}
+	s.mutex.Unlock()
+
+	stopped := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	return s.flush(ctx)
+}
+
+// track учитывает ресурс, который закроет Shutdown. Если Shutdown уже вызван,
+// ресурс сразу закрывается и выдается false. Проверка и учет выполняются под mutex,
+// чтобы ресурс не появился после того, как Shutdown закрыл остальные
+func (s *Server) track(c io.Closer) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closing() {
+		_ = c.Close()
+		return false
+	}
+	s.closers[c] = struct{}{}
+	s.wg.Add(1)
+
+	return true
+}
+
+// release закрывает ресурс, учтенный track
+func (s *Server) release(c io.Closer) {
+	s.mutex.Lock()
+	delete(s.closers, c)
+	s.mutex.Unlock()
+	_ = c.Close()
+	s.wg.Done()
+}
+
+// closing проверяет, что вызван Shutdown
+func (s *Server) closing() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Server) handleConn(conn net.Conn, handleLine func(line string)) {
+	defer s.release(conn)
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		handleLine(scanner.Text())
+	}
+	if err := scanner.Err(); err != nil && !s.closing() {
+		log.Error().Err(err).Msgf("Failed to read %s connection", s.opts.Name)
+	}
+}
+
+func (s *Server) flushLoop() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.opts.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			if err := s.flush(context.Background()); err != nil {
+				log.Error().Err(err).Msgf("Failed to store %s metrics", s.opts.Name)
+			}
+		}
+	}
+}
+
+// flush сохраняет принятые метрики. Метрики, которые не удалось сохранить, сохраняются
+// при следующем вызове раньше новых, чтобы более новые значения gauge остались последними
+func (s *Server) flush(ctx context.Context) error {
+	s.flushMutex.Lock()
+	defer s.flushMutex.Unlock()
+
+	mtrcs := append(s.pending, s.opts.Collect()...)
+	s.pending = nil
+	if len(mtrcs) == 0 {
+		return nil
+	}
+	log.Debug().Msgf("Flushing %d %s metrics", len(mtrcs), s.opts.Name)
+
+	if err := s.opts.Store.SetMetrics(ctx, mtrcs); err != nil {
+		if dropped := len(mtrcs) - maxPending; dropped > 0 {
+			log.Error().Int("dropped", dropped).Msgf("Dropping %s metrics that could not be stored", s.opts.Name)
+			mtrcs = mtrcs[dropped:]
+		}
+		s.pending = mtrcs
+		return err
+	}
+
+	return nil
+}
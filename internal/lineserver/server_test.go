@@ -0,0 +1,95 @@
+package lineserver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vleukhin/prom-light/internal/metrics"
+	"github.com/vleukhin/prom-light/internal/storage"
+)
+
+var errTestClosed = errors.New("test: server closed")
+
+// unavailableStorage не сохраняет метрики, пока недоступна
+type unavailableStorage struct {
+	storage.MetricsStorage
+	down bool
+}
+
+func (s *unavailableStorage) SetMetrics(ctx context.Context, mtrcs metrics.Metrics) error {
+	if s.down {
+		return errors.New("storage is unavailable")
+	}
+	return s.MetricsStorage.SetMetrics(ctx, mtrcs)
+}
+
+func TestServer_FlushRetry(t *testing.T) {
+	ctx := context.Background()
+	mock := storage.NewMockStorage()
+	store := &unavailableStorage{MetricsStorage: mock, down: true}
+	var received metrics.Metrics
+	server := New(Options{
+		Name:      "test",
+		Store:     store,
+		ErrClosed: errTestClosed,
+		Collect: func() metrics.Metrics {
+			mtrcs := received
+			received = nil
+			return mtrcs
+		},
+	})
+
+	received = metrics.Metrics{metrics.MakeCounterMetric("requests", 2), metrics.MakeGaugeMetric("temperature", 20)}
+	require.Error(t, server.flush(ctx))
+
+	// Несохраненные метрики сохраняются вместе с новыми, новое значение gauge остается последним
+	store.down = false
+	received = metrics.Metrics{metrics.MakeCounterMetric("requests", 3), metrics.MakeGaugeMetric("temperature", 21)}
+	require.NoError(t, server.flush(ctx))
+	mock.AssertCounterStoredWithValue(t, "requests", 5)
+	mock.AssertGaugeStoredWithValue(t, "temperature", 21)
+
+	require.NoError(t, server.flush(ctx))
+	mock.AssertCounterStoredWithValue(t, "requests", 5)
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	server := New(Options{
+		Name:          "test",
+		FlushInterval: time.Hour,
+		Store:         storage.NewMockStorage(),
+		ErrClosed:     errTestClosed,
+		Collect:       func() metrics.Metrics { return nil },
+	})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	lines := make(chan string, 1)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- server.ServeTCP(listener, func(line string) { lines <- line })
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("line\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "line", <-lines)
+
+	require.NoError(t, server.Shutdown(context.Background()))
+	require.ErrorIs(t, <-errCh, errTestClosed)
+	require.NoError(t, server.Shutdown(context.Background()), "repeated Shutdown")
+
+	// Слушатель, переданный после Shutdown, сразу закрывается
+	late, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	require.ErrorIs(t, server.ServeTCP(late, func(string) {}), errTestClosed)
+	_, err = late.Accept()
+	assert.Error(t, err)
+}
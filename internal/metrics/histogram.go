@@ -0,0 +1,136 @@
+package metrics
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Bucket корзина гистограммы
+type Bucket struct {
+	// UpperBound верхняя граница корзины включительно
+	UpperBound float64 `json:"le"`
+	// Count количество наблюдений, не превышающих UpperBound
+	Count uint64 `json:"count"`
+}
+
+// Histogram значение метрики типа histogram. Корзины упорядочены по возрастанию границ,
+// количество в корзинах накопительное. Корзина +Inf не хранится, ее количество равно Count
+type Histogram struct {
+	Buckets []Bucket `json:"buckets"`
+	Sum     float64  `json:"sum"`
+	Count   uint64   `json:"count"`
+}
+
+// Quantile значение квантиля
+type Quantile struct {
+	Quantile float64 `json:"quantile"`
+	Value    float64 `json:"value"`
+}
+
+// Summary значение метрики типа summary
+type Summary struct {
+	Quantiles []Quantile `json:"quantiles"`
+	Sum       float64    `json:"sum"`
+	Count     uint64     `json:"count"`
+}
+
+// NewHistogram создает пустую гистограмму с заданными границами корзин
+func NewHistogram(bounds []float64) Histogram {
+	h := Histogram{Buckets: make([]Bucket, len(bounds))}
+	for i, b := range bounds {
+		h.Buckets[i].UpperBound = b
+	}
+
+	return h
+}
+
+// Observe учитывает наблюдение в гистограмме weight раз
+func (h *Histogram) Observe(v float64, weight uint64) {
+	for i := range h.Buckets {
+		if v <= h.Buckets[i].UpperBound {
+			h.Buckets[i].Count += weight
+		}
+	}
+	h.Sum += v * float64(weight)
+	h.Count += weight
+}
+
+// Merge возвращает гистограмму, содержащую наблюдения обеих гистограмм.
+// Если границы корзин не совпадают, возвращается other
+func (h Histogram) Merge(other Histogram) Histogram {
+	if !h.sameBounds(other) {
+		return other.Clone()
+	}
+
+	result := h.Clone()
+	for i := range result.Buckets {
+		result.Buckets[i].Count += other.Buckets[i].Count
+	}
+	result.Sum += other.Sum
+	result.Count += other.Count
+
+	return result
+}
+
+// IsValid проверяет, что границы корзин возрастают, а количество в корзинах не убывает
+func (h Histogram) IsValid() bool {
+	for i, b := range h.Buckets {
+		if b.Count > h.Count {
+			return false
+		}
+		if i > 0 && (b.UpperBound <= h.Buckets[i-1].UpperBound || b.Count < h.Buckets[i-1].Count) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Clone создает копию гистограммы, не разделяющую с оригиналом корзины
+func (h Histogram) Clone() Histogram {
+	h.Buckets = append([]Bucket(nil), h.Buckets...)
+	return h
+}
+
+// String выдает каноническое представление гистограммы, используемое также при подписи
+func (h Histogram) String() string {
+	buckets := make([]string, 0, len(h.Buckets))
+	for _, b := range h.Buckets {
+		buckets = append(buckets, formatFloat(b.UpperBound)+"="+strconv.FormatUint(b.Count, 10))
+	}
+
+	return "[" + strings.Join(buckets, ",") + "] sum=" + formatFloat(h.Sum) + " count=" + strconv.FormatUint(h.Count, 10)
+}
+
+// Clone создает копию summary, не разделяющую с оригиналом квантили
+func (s Summary) Clone() Summary {
+	s.Quantiles = append([]Quantile(nil), s.Quantiles...)
+	return s
+}
+
+// String выдает каноническое представление summary, используемое также при подписи
+func (s Summary) String() string {
+	quantiles := make([]string, 0, len(s.Quantiles))
+	for _, q := range s.Quantiles {
+		quantiles = append(quantiles, formatFloat(q.Quantile)+"="+formatFloat(q.Value))
+	}
+
+	return "[" + strings.Join(quantiles, ",") + "] sum=" + formatFloat(s.Sum) + " count=" + strconv.FormatUint(s.Count, 10)
+}
+
+func (h Histogram) sameBounds(other Histogram) bool {
+	if len(h.Buckets) != len(other.Buckets) {
+		return false
+	}
+	for i := range h.Buckets {
+		if h.Buckets[i].UpperBound != other.Buckets[i].UpperBound {
+			return false
+		}
+	}
+
+	return true
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -4,23 +4,38 @@ import (
 	"encoding/hex"
 	"fmt"
 	"hash"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Gauge float64
 type Counter int64
 
+// Labels метки метрики
+type Labels map[string]string
+
 // Metric определяем метрику
 type Metric struct {
 	// Name имя метрики
 	Name string `json:"id"`
-	// Type параметр, принимающий значение gauge или counter
+	// Type параметр, принимающий значение gauge, counter, histogram или summary
 	Type string `json:"type"`
 	// Delta значение метрики в случае передачи counter
 	Delta *Counter `json:"delta,omitempty"`
 	// Value значение метрики в случае передачи gauge
 	Value *Gauge `json:"value,omitempty"`
+	// Histogram значение метрики в случае передачи histogram
+	Histogram *Histogram `json:"histogram,omitempty"`
+	// Summary значение метрики в случае передачи summary
+	Summary *Summary `json:"summary,omitempty"`
 	// Hash значение хеш-функции
 	Hash string `json:"hash,omitempty"`
+	// Labels метки метрики. Вместе с именем определяют серию
+	Labels Labels `json:"labels,omitempty"`
+	// Timestamp время, к которому относится значение метрики
+	Timestamp *time.Time `json:"timestamp,omitempty"`
 }
 
 // Metrics слайс метрик
@@ -28,8 +43,10 @@ type Metrics []Metric
 
 // Строковое представление типов метрик
 const (
-	GaugeTypeName   = "gauge"
-	CounterTypeName = "counter"
+	GaugeTypeName     = "gauge"
+	CounterTypeName   = "counter"
+	HistogramTypeName = "histogram"
+	SummaryTypeName   = "summary"
 )
 
 // IsCounter проверяет является ли метрика счетчиком
@@ -37,6 +54,11 @@ func (m Metric) IsCounter() bool {
 	return m.Type == CounterTypeName
 }
 
+// ID выдает идентификатор серии: имя метрики вместе с метками
+func (m Metric) ID() string {
+	return m.Name + m.Labels.String()
+}
+
 // String выдает строковое представление метрики
 func (m Metric) String() string {
 	var str string
@@ -45,6 +67,10 @@ func (m Metric) String() string {
 		str = fmt.Sprintf("%.3f", *m.Value)
 	case CounterTypeName:
 		str = fmt.Sprintf("%d", *m.Delta)
+	case HistogramTypeName:
+		str = m.Histogram.String()
+	case SummaryTypeName:
+		str = m.Summary.String()
 	default:
 		str = "unknown"
 	}
@@ -60,15 +86,46 @@ func makeHash(m Metric, hasher hash.Hash) string {
 
 	switch m.Type {
 	case CounterTypeName:
-		hasher.Write([]byte(fmt.Sprintf("%s:counter:%d", m.Name, *m.Delta)))
+		hasher.Write([]byte(fmt.Sprintf("%s:counter:%d", m.ID(), *m.Delta)))
 	case GaugeTypeName:
-		hasher.Write([]byte(fmt.Sprintf("%s:gauge:%f", m.Name, *m.Value)))
+		hasher.Write([]byte(fmt.Sprintf("%s:gauge:%f", m.ID(), *m.Value)))
+	case HistogramTypeName:
+		hasher.Write([]byte(fmt.Sprintf("%s:histogram:%s", m.ID(), m.Histogram)))
+	case SummaryTypeName:
+		hasher.Write([]byte(fmt.Sprintf("%s:summary:%s", m.ID(), m.Summary)))
 	}
 
 	defer hasher.Reset()
 	return hex.EncodeToString(hasher.Sum(nil))
 }
 
+// Clone создает копию метрики, не разделяющую с оригиналом значения и метки
+func (m Metric) Clone() Metric {
+	if m.Delta != nil {
+		delta := *m.Delta
+		m.Delta = &delta
+	}
+	if m.Value != nil {
+		value := *m.Value
+		m.Value = &value
+	}
+	if m.Histogram != nil {
+		h := m.Histogram.Clone()
+		m.Histogram = &h
+	}
+	if m.Summary != nil {
+		summary := m.Summary.Clone()
+		m.Summary = &summary
+	}
+	if m.Timestamp != nil {
+		ts := *m.Timestamp
+		m.Timestamp = &ts
+	}
+	m.Labels = m.Labels.Merge(nil)
+
+	return m
+}
+
 // Sign подписывает метрику с помощью переданного хэшера
 func (m *Metric) Sign(hasher hash.Hash) {
 	if hasher == nil {
@@ -103,6 +160,57 @@ func (m Metrics) Sign(hasher hash.Hash) Metrics {
 	return result
 }
 
+// WithLabels добавляет метки ко всем метрикам в слайсе.
+// Метки, уже заданные у метрики, имеют приоритет
+func (m Metrics) WithLabels(labels Labels) Metrics {
+	if len(labels) == 0 {
+		return m
+	}
+	result := make(Metrics, len(m))
+	for i, metric := range m {
+		metric.Labels = labels.Merge(metric.Labels)
+		result[i] = metric
+	}
+
+	return result
+}
+
+// String выдает каноническое представление меток вида {a="1",b="2"}
+func (l Labels) String() string {
+	if len(l) == 0 {
+		return ""
+	}
+
+	keys := make([]string, 0, len(l))
+	for k := range l {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+"="+strconv.Quote(l[k]))
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Merge возвращает новый набор меток, в котором метки other перекрывают текущие
+func (l Labels) Merge(other Labels) Labels {
+	if len(l) == 0 && len(other) == 0 {
+		return nil
+	}
+	result := make(Labels, len(l)+len(other))
+	for k, v := range l {
+		result[k] = v
+	}
+	for k, v := range other {
+		result[k] = v
+	}
+
+	return result
+}
+
 // MakeGaugeMetric создает метрику типа gauge
 func MakeGaugeMetric(name string, value Gauge) Metric {
 	return Metric{
@@ -112,6 +220,24 @@ func MakeGaugeMetric(name string, value Gauge) Metric {
 	}
 }
 
+// MakeHistogramMetric создает метрику типа histogram
+func MakeHistogramMetric(name string, h Histogram) Metric {
+	return Metric{
+		Name:      name,
+		Type:      HistogramTypeName,
+		Histogram: &h,
+	}
+}
+
+// MakeSummaryMetric создает метрику типа summary
+func MakeSummaryMetric(name string, s Summary) Metric {
+	return Metric{
+		Name:    name,
+		Type:    SummaryTypeName,
+		Summary: &s,
+	}
+}
+
 // MakeCounterMetric создает метрику типа counter
 func MakeCounterMetric(name string, delta Counter) Metric {
 	return Metric{
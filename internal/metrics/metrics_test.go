@@ -43,6 +43,16 @@ func TestMetric_Sign(t *testing.T) {
 			},
 			wantHash: "483c1e0d3e3b33b9426863bda45a142581c837db875f2d4087ab7b74d76a3c9f",
 		},
+		{
+			name: "Labelled gauge",
+			metric: Metric{
+				Name:   "TestGauge",
+				Type:   GaugeTypeName,
+				Value:  makeGaugePointer(125.3444442),
+				Labels: Labels{"host": "a"},
+			},
+			wantHash: "04b8cc64b5219d7d14501bec7f92cecd67fa3f4006eea32d9dcf733b8bd5a4e7",
+		},
 	}
 
 	hasher := hmac.New(sha256.New, []byte("test-key"))
@@ -76,6 +86,24 @@ func TestMetric_String(t *testing.T) {
 			metric: MakeCounterMetric("test", 10),
 			str:    "10",
 		},
+		{
+			name: "histogram",
+			metric: MakeHistogramMetric("test", Histogram{
+				Buckets: []Bucket{{UpperBound: 0.1, Count: 1}, {UpperBound: 1, Count: 3}},
+				Sum:     2.5,
+				Count:   4,
+			}),
+			str: "[0.1=1,1=3] sum=2.5 count=4",
+		},
+		{
+			name: "summary",
+			metric: MakeSummaryMetric("test", Summary{
+				Quantiles: []Quantile{{Quantile: 0.5, Value: 0.2}, {Quantile: 0.99, Value: 1.5}},
+				Sum:       10,
+				Count:     20,
+			}),
+			str: "[0.5=0.2,0.99=1.5] sum=10 count=20",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -84,6 +112,116 @@ func TestMetric_String(t *testing.T) {
 	}
 }
 
+func TestMetric_ID(t *testing.T) {
+	tests := []struct {
+		name   string
+		metric Metric
+		id     string
+	}{
+		{
+			name:   "no labels",
+			metric: MakeGaugeMetric("Alloc", 1),
+			id:     "Alloc",
+		},
+		{
+			name: "labels are sorted",
+			metric: Metric{
+				Name:   "Alloc",
+				Labels: Labels{"service": "api", "host": "a"},
+			},
+			id: `Alloc{host="a",service="api"}`,
+		},
+		{
+			name: "values are quoted",
+			metric: Metric{
+				Name:   "Alloc",
+				Labels: Labels{"host": `a"b`},
+			},
+			id: `Alloc{host="a\"b"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.id, tt.metric.ID())
+		})
+	}
+}
+
+func TestMetrics_WithLabels(t *testing.T) {
+	m := MakeGaugeMetric("Alloc", 1)
+	m.Labels = Labels{"host": "own"}
+	mtrcs := Metrics{m, MakeCounterMetric("PollCount", 1)}
+
+	labelled := mtrcs.WithLabels(Labels{"host": "agent", "dc": "eu"})
+
+	assert.Equal(t, Labels{"host": "own", "dc": "eu"}, labelled[0].Labels)
+	assert.Equal(t, Labels{"host": "agent", "dc": "eu"}, labelled[1].Labels)
+	assert.Nil(t, mtrcs[1].Labels)
+}
+
+func TestMetric_SignHistogram(t *testing.T) {
+	hasher := hmac.New(sha256.New, []byte("test-key"))
+	m := MakeHistogramMetric("latency", Histogram{
+		Buckets: []Bucket{{UpperBound: 0.1, Count: 1}, {UpperBound: 1, Count: 3}},
+		Sum:     2.5,
+		Count:   4,
+	})
+	m.Sign(hasher)
+	assert.True(t, m.IsValid(hasher))
+
+	m.Histogram.Buckets[0].Count = 2
+	assert.False(t, m.IsValid(hasher))
+
+	s := MakeSummaryMetric("latency", Summary{Quantiles: []Quantile{{Quantile: 0.5, Value: 1}}, Sum: 1, Count: 1})
+	s.Sign(hasher)
+	assert.True(t, s.IsValid(hasher))
+	s.Summary.Quantiles[0].Value = 2
+	assert.False(t, s.IsValid(hasher))
+}
+
+func TestHistogram_Merge(t *testing.T) {
+	h := NewHistogram([]float64{1, 10})
+	h.Observe(0.5, 1)
+	h.Observe(5, 2)
+
+	other := NewHistogram([]float64{1, 10})
+	other.Observe(50, 1)
+
+	merged := h.Merge(other)
+	assert.Equal(t, Histogram{
+		Buckets: []Bucket{{UpperBound: 1, Count: 1}, {UpperBound: 10, Count: 3}},
+		Sum:     60.5,
+		Count:   4,
+	}, merged)
+	assert.Equal(t, uint64(1), h.Buckets[0].Count, "Merge() must not modify the original")
+
+	rebucketed := NewHistogram([]float64{100})
+	rebucketed.Observe(50, 1)
+	assert.Equal(t, rebucketed, h.Merge(rebucketed))
+}
+
+func TestHistogram_IsValid(t *testing.T) {
+	assert.True(t, Histogram{Buckets: []Bucket{{1, 1}, {2, 3}}, Count: 3}.IsValid())
+	assert.False(t, Histogram{Buckets: []Bucket{{2, 1}, {1, 3}}, Count: 3}.IsValid(), "bounds must increase")
+	assert.False(t, Histogram{Buckets: []Bucket{{1, 3}, {2, 1}}, Count: 3}.IsValid(), "counts must be cumulative")
+	assert.False(t, Histogram{Buckets: []Bucket{{1, 5}}, Count: 3}.IsValid(), "bucket count exceeds total")
+}
+
+func TestProto(t *testing.T) {
+	for _, m := range []Metric{
+		MakeGaugeMetric("Alloc", 1.5),
+		MakeCounterMetric("PollCount", 3),
+		MakeHistogramMetric("latency", Histogram{Buckets: []Bucket{{UpperBound: 1, Count: 2}}, Sum: 1.5, Count: 3}),
+		MakeSummaryMetric("latency", Summary{Quantiles: []Quantile{{Quantile: 0.5, Value: 1}}, Sum: 1, Count: 1}),
+	} {
+		t.Run(m.Type, func(t *testing.T) {
+			got, err := FromProto(ToProto(m))
+			assert.NoError(t, err)
+			assert.Equal(t, m, got)
+		})
+	}
+}
+
 func BenchmarkSign(b *testing.B) {
 	var metricsData = Metrics{
 		MakeCounterMetric("Counter1", 0),
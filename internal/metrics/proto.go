@@ -8,20 +8,37 @@ import (
 )
 
 func FromProto(metric *proto.Metric) (Metric, error) {
+	var m Metric
 	switch metric.Type {
 	case proto.MetricType_GAUGE:
-		return MakeGaugeMetric(metric.Name, Gauge(metric.Value)), nil
+		m = MakeGaugeMetric(metric.Name, Gauge(metric.Value))
 	case proto.MetricType_COUNTER:
-		return MakeCounterMetric(metric.Name, Counter(metric.Delta)), nil
+		m = MakeCounterMetric(metric.Name, Counter(metric.Delta))
+	case proto.MetricType_HISTOGRAM:
+		if metric.Histogram == nil {
+			return Metric{}, status.Error(codes.InvalidArgument, "missing histogram value")
+		}
+		m = MakeHistogramMetric(metric.Name, histogramFromProto(metric.Histogram))
+	case proto.MetricType_SUMMARY:
+		if metric.Summary == nil {
+			return Metric{}, status.Error(codes.InvalidArgument, "missing summary value")
+		}
+		m = MakeSummaryMetric(metric.Name, summaryFromProto(metric.Summary))
 	default:
 		return Metric{}, status.Errorf(codes.InvalidArgument, "unknown metric type '%s'", metric.Type)
 	}
+	m.Labels = Labels(metric.Labels).Merge(nil)
+	m.Hash = metric.Hash
+
+	return m, nil
 }
 
 func ToProto(metric Metric) *proto.Metric {
 	m := &proto.Metric{
-		Name: metric.Name,
-		Type: TypeToProto(metric.Type),
+		Name:   metric.Name,
+		Type:   TypeToProto(metric.Type),
+		Labels: metric.Labels,
+		Hash:   metric.Hash,
 	}
 
 	switch metric.Type {
@@ -29,6 +46,14 @@ func ToProto(metric Metric) *proto.Metric {
 		if metric.Value != nil {
 			m.Value = float64(*metric.Value)
 		}
+	case HistogramTypeName:
+		if metric.Histogram != nil {
+			m.Histogram = histogramToProto(*metric.Histogram)
+		}
+	case SummaryTypeName:
+		if metric.Summary != nil {
+			m.Summary = summaryToProto(*metric.Summary)
+		}
 	default:
 		if metric.Delta != nil {
 			m.Delta = int64(*metric.Delta)
@@ -52,7 +77,79 @@ func TypeToProto(t string) proto.MetricType {
 	switch t {
 	case GaugeTypeName:
 		return proto.MetricType_GAUGE
+	case HistogramTypeName:
+		return proto.MetricType_HISTOGRAM
+	case SummaryTypeName:
+		return proto.MetricType_SUMMARY
 	default:
 		return proto.MetricType_COUNTER
 	}
 }
+
+// TypeFromProto выдает название типа метрики по его значению в protobuf
+func TypeFromProto(t proto.MetricType) (string, error) {
+	switch t {
+	case proto.MetricType_GAUGE:
+		return GaugeTypeName, nil
+	case proto.MetricType_COUNTER:
+		return CounterTypeName, nil
+	case proto.MetricType_HISTOGRAM:
+		return HistogramTypeName, nil
+	case proto.MetricType_SUMMARY:
+		return SummaryTypeName, nil
+	default:
+		return "", status.Errorf(codes.InvalidArgument, "unknown metric type '%s'", t)
+	}
+}
+
+func histogramFromProto(h *proto.Histogram) Histogram {
+	res := Histogram{
+		Buckets: make([]Bucket, 0, len(h.Buckets)),
+		Sum:     h.Sum,
+		Count:   h.Count,
+	}
+	for _, b := range h.Buckets {
+		res.Buckets = append(res.Buckets, Bucket{UpperBound: b.UpperBound, Count: b.Count})
+	}
+
+	return res
+}
+
+func histogramToProto(h Histogram) *proto.Histogram {
+	res := &proto.Histogram{
+		Buckets: make([]*proto.Bucket, 0, len(h.Buckets)),
+		Sum:     h.Sum,
+		Count:   h.Count,
+	}
+	for _, b := range h.Buckets {
+		res.Buckets = append(res.Buckets, &proto.Bucket{UpperBound: b.UpperBound, Count: b.Count})
+	}
+
+	return res
+}
+
+func summaryFromProto(s *proto.Summary) Summary {
+	res := Summary{
+		Quantiles: make([]Quantile, 0, len(s.Quantiles)),
+		Sum:       s.Sum,
+		Count:     s.Count,
+	}
+	for _, q := range s.Quantiles {
+		res.Quantiles = append(res.Quantiles, Quantile{Quantile: q.Quantile, Value: q.Value})
+	}
+
+	return res
+}
+
+func summaryToProto(s Summary) *proto.Summary {
+	res := &proto.Summary{
+		Quantiles: make([]*proto.Quantile, 0, len(s.Quantiles)),
+		Sum:       s.Sum,
+		Count:     s.Count,
+	}
+	for _, q := range s.Quantiles {
+		res.Quantiles = append(res.Quantiles, &proto.Quantile{Quantile: q.Quantile, Value: q.Value})
+	}
+
+	return res
+}
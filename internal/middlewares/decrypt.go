@@ -28,15 +28,20 @@ func (m Decrypt) Handle(next http.Handler) http.Handler {
 			return
 		}
 
+		// Тело, которое не удалось расшифровать, - ошибка клиента, например другой ключ.
+		// Ответ 5xx агент повторял бы без конца
 		if err := m.decryptRequestBody(r); err != nil {
-			log.Error().Err(err)
-			w.WriteHeader(http.StatusInternalServerError)
+			log.Error().Err(err).Msg("Failed to decrypt request body")
+			w.WriteHeader(http.StatusBadRequest)
+			return
 		}
 
 		next.ServeHTTP(w, r)
 	})
 }
 
+// decryptRequestBody расшифровывает тело запроса. Поддерживаются конверт AES-GCM
+// и устаревший формат блоков RSA-OAEP от агентов предыдущих версий
 func (m Decrypt) decryptRequestBody(r *http.Request) error {
 	defer func(Body io.ReadCloser) {
 		err := Body.Close()
@@ -49,7 +54,7 @@ func (m Decrypt) decryptRequestBody(r *http.Request) error {
 		return err
 	}
 
-	decrypted, err := crypt.DecryptOAEP(m.key, original, nil)
+	decrypted, err := crypt.Decrypt(m.key, original)
 	if err != nil {
 		return err
 	}
@@ -1,9 +1,16 @@
 package middlewares
 
 import (
-	"log"
+	"context"
 	"net"
 	"net/http"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 
 	"github.com/vleukhin/prom-light/internal/config"
 )
@@ -22,9 +29,8 @@ func (m TrustedIPs) Handle(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		IPRaw := r.Header.Get(config.XRealIPHeader)
 		if IPRaw == "" {
-			IPRaw = r.RemoteAddr
+			IPRaw = hostOf(r.RemoteAddr)
 		}
-		log.Println(IPRaw)
 		if !m.CIDR.Contains(net.ParseIP(IPRaw)) {
 			w.WriteHeader(http.StatusForbidden)
 			return
@@ -32,3 +38,69 @@ func (m TrustedIPs) Handle(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// HandlePeer пропускает запрос, только если соединение установлено из доверенной подсети.
+// Используется для опасных операций, где нельзя доверять присланному клиентом заголовку X-Real-IP
+func (m TrustedIPs) HandlePeer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !m.trusted(r.RemoteAddr, r.Header.Get(config.XRealIPHeader)) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// UnaryInterceptor проверяет, что unary gRPC запрос пришел из доверенной подсети
+func (m TrustedIPs) UnaryInterceptor(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if !m.trustedPeer(ctx) {
+		return nil, status.Error(codes.PermissionDenied, "untrusted client address")
+	}
+
+	return handler(ctx, req)
+}
+
+// StreamInterceptor проверяет, что потоковый gRPC запрос пришел из доверенной подсети
+func (m TrustedIPs) StreamInterceptor(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if !m.trustedPeer(ss.Context()) {
+		return status.Error(codes.PermissionDenied, "untrusted client address")
+	}
+
+	return handler(srv, ss)
+}
+
+// trustedPeer проверяет адрес gRPC соединения и метаданные x-real-ip по правилам trusted
+func (m TrustedIPs) trustedPeer(ctx context.Context) bool {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return false
+	}
+	var realIP string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(strings.ToLower(config.XRealIPHeader)); len(values) > 0 {
+			realIP = values[0]
+		}
+	}
+
+	return m.trusted(p.Addr.String(), realIP)
+}
+
+// trusted проверяет адрес соединения peerAddr. Адрес realIP из заголовка или метаданных
+// учитывается, только если соединение установлено из доверенной подсети, например прокси,
+// и тогда он тоже должен быть доверенным. Иначе клиент мог бы выдать себя за доверенный адрес
+func (m TrustedIPs) trusted(peerAddr, realIP string) bool {
+	if !m.CIDR.Contains(net.ParseIP(hostOf(peerAddr))) {
+		return false
+	}
+
+	return realIP == "" || m.CIDR.Contains(net.ParseIP(realIP))
+}
+
+// hostOf отделяет порт от адреса
+func hostOf(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+
+	return addr
+}
@@ -0,0 +1,271 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/vleukhin/prom-light/internal/metrics"
+)
+
+// Причины удаления пачек из очереди без отправки
+const (
+	DropReasonSize    = "size"
+	DropReasonAge     = "age"
+	DropReasonCorrupt = "corrupt"
+)
+
+const (
+	segmentExt = ".batch"
+	tmpExt     = ".tmp"
+)
+
+// Limits ограничения очереди. Нулевое значение отключает ограничение
+type Limits struct {
+	// MaxBytes максимальный суммарный размер сегментов на диске
+	MaxBytes int64
+	// MaxAge максимальное время ожидания пачки в очереди
+	MaxAge time.Duration
+}
+
+// Stats состояние очереди
+type Stats struct {
+	Segments int
+	Bytes    int64
+}
+
+// DropFunc вызывается при удалении пачек из очереди без отправки
+type DropFunc func(reason string, batches int)
+
+// SendFunc отправляет пачку метрик на сервер
+type SendFunc func(ctx context.Context, m metrics.Metrics) error
+
+type segment struct {
+	seq  uint64
+	size int64
+}
+
+type record struct {
+	Created time.Time       `json:"created"`
+	Metrics metrics.Metrics `json:"metrics"`
+}
+
+// Outbox очередь неотправленных пачек метрик на диске. Каждая пачка хранится
+// в отдельном сегменте, сегменты отправляются в порядке записи
+type Outbox struct {
+	mutex    sync.Mutex
+	dir      string
+	limits   Limits
+	onDrop   DropFunc
+	segments []segment
+	bytes    int64
+	nextSeq  uint64
+	// now текущее время, подменяется в тестах
+	now func() time.Time
+}
+
+// Open открывает очередь в каталоге dir, создавая его при необходимости.
+// Сегменты, оставшиеся с прошлого запуска, будут отправлены первыми
+func Open(dir string, limits Limits, onDrop DropFunc) (*Outbox, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	o := &Outbox{
+		dir:    dir,
+		limits: limits,
+		onDrop: onDrop,
+		now:    time.Now,
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if strings.HasSuffix(name, tmpExt) {
+			// Запись сегмента была прервана, пачка в очередь не попала
+			_ = os.Remove(filepath.Join(dir, name))
+			continue
+		}
+		if !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		o.segments = append(o.segments, segment{seq: seq, size: info.Size()})
+		o.bytes += info.Size()
+	}
+	sort.Slice(o.segments, func(i, j int) bool {
+		return o.segments[i].seq < o.segments[j].seq
+	})
+	if n := len(o.segments); n > 0 {
+		o.nextSeq = o.segments[n-1].seq + 1
+	}
+
+	return o, nil
+}
+
+// Push записывает пачку в очередь. Если очередь превышает допустимый размер,
+// самые старые пачки удаляются. Пачка больше допустимого размера очереди
+// не записывается и считается удаленной по размеру
+func (o *Outbox) Push(m metrics.Metrics) error {
+	data, err := json.Marshal(record{Created: o.now(), Metrics: m})
+	if err != nil {
+		return err
+	}
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if o.limits.MaxBytes > 0 && int64(len(data)) > o.limits.MaxBytes {
+		o.drop(DropReasonSize, 1)
+		return nil
+	}
+
+	seq := o.nextSeq
+	path := o.path(seq)
+	if err := writeFile(path, data); err != nil {
+		return err
+	}
+	o.nextSeq++
+	o.segments = append(o.segments, segment{seq: seq, size: int64(len(data))})
+	o.bytes += int64(len(data))
+
+	if o.limits.MaxBytes > 0 {
+		dropped := 0
+		for o.bytes > o.limits.MaxBytes && len(o.segments) > 1 {
+			o.removeFirst()
+			dropped++
+		}
+		o.drop(DropReasonSize, dropped)
+	}
+
+	return nil
+}
+
+// Replay отправляет пачки из очереди в порядке записи и удаляет отправленные.
+// Отправка прекращается на первой ошибке, неотправленные пачки остаются в очереди.
+// Выдает количество отправленных пачек
+func (o *Outbox) Replay(ctx context.Context, send SendFunc) (int, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	sent := 0
+	for len(o.segments) > 0 {
+		rec, err := o.read(o.segments[0].seq)
+		if err != nil {
+			log.Error().Err(err).Msg("Dropping corrupted outbox segment")
+			o.removeFirst()
+			o.drop(DropReasonCorrupt, 1)
+			continue
+		}
+		if o.limits.MaxAge > 0 && o.now().Sub(rec.Created) > o.limits.MaxAge {
+			o.removeFirst()
+			o.drop(DropReasonAge, 1)
+			continue
+		}
+		if err := send(ctx, rec.Metrics); err != nil {
+			return sent, err
+		}
+		o.removeFirst()
+		sent++
+	}
+
+	return sent, nil
+}
+
+// Len выдает количество пачек в очереди
+func (o *Outbox) Len() int {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	return len(o.segments)
+}
+
+// Stats выдает текущее состояние очереди
+func (o *Outbox) Stats() Stats {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	return Stats{Segments: len(o.segments), Bytes: o.bytes}
+}
+
+func (o *Outbox) path(seq uint64) string {
+	return filepath.Join(o.dir, fmt.Sprintf("%020d%s", seq, segmentExt))
+}
+
+func (o *Outbox) read(seq uint64) (record, error) {
+	var rec record
+	data, err := os.ReadFile(o.path(seq))
+	if err != nil {
+		return rec, err
+	}
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return rec, err
+	}
+
+	return rec, nil
+}
+
+func (o *Outbox) removeFirst() {
+	s := o.segments[0]
+	if err := os.Remove(o.path(s.seq)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Error().Err(err).Msg("Failed to remove outbox segment")
+	}
+	o.segments = o.segments[1:]
+	o.bytes -= s.size
+}
+
+func (o *Outbox) drop(reason string, batches int) {
+	if batches == 0 {
+		return
+	}
+	log.Warn().Str("reason", reason).Msgf("Dropped %d outbox batches", batches)
+	if o.onDrop != nil {
+		o.onDrop(reason, batches)
+	}
+}
+
+// writeFile записывает сегмент через временный файл, чтобы в каталоге
+// не оказалось недописанного сегмента
+func writeFile(path string, data []byte) error {
+	tmp := path + tmpExt
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,150 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vleukhin/prom-light/internal/metrics"
+)
+
+func batch(v float64) metrics.Metrics {
+	return metrics.Metrics{metrics.MakeGaugeMetric("Alloc", metrics.Gauge(v))}
+}
+
+// collector запоминает отправленные значения и отказывает в отправке, пока fail выставлен
+type collector struct {
+	fail bool
+	sent []float64
+}
+
+func (c *collector) send(_ context.Context, m metrics.Metrics) error {
+	if c.fail {
+		return errors.New("server unavailable")
+	}
+	c.sent = append(c.sent, float64(*m[0].Value))
+	return nil
+}
+
+func TestOutbox_ReplayInOrder(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	o, err := Open(dir, Limits{}, nil)
+	require.NoError(t, err)
+
+	for i := 1; i <= 3; i++ {
+		require.NoError(t, o.Push(batch(float64(i))))
+	}
+
+	c := &collector{fail: true}
+	sent, err := o.Replay(ctx, c.send)
+	assert.Error(t, err)
+	assert.Equal(t, 0, sent)
+	assert.Equal(t, 3, o.Len())
+
+	// Очередь переживает перезапуск агента
+	o, err = Open(dir, Limits{}, nil)
+	require.NoError(t, err)
+	require.NoError(t, o.Push(batch(4)))
+
+	c.fail = false
+	sent, err = o.Replay(ctx, c.send)
+	require.NoError(t, err)
+	assert.Equal(t, 4, sent)
+	assert.Equal(t, []float64{1, 2, 3, 4}, c.sent)
+	assert.Equal(t, Stats{}, o.Stats())
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestOutbox_Limits(t *testing.T) {
+	ctx := context.Background()
+	drops := make(map[string]int)
+	onDrop := func(reason string, batches int) {
+		drops[reason] += batches
+	}
+
+	t.Run("Size", func(t *testing.T) {
+		o, err := Open(t.TempDir(), Limits{}, onDrop)
+		require.NoError(t, err)
+		// Время создания пишется в сегмент, с постоянным временем размер сегментов одинаковый
+		created := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+		o.now = func() time.Time { return created }
+		require.NoError(t, o.Push(batch(1)))
+		size := o.Stats().Bytes
+
+		o.limits.MaxBytes = 2 * size
+		require.NoError(t, o.Push(batch(2)))
+		require.NoError(t, o.Push(batch(3)))
+		assert.Equal(t, Stats{Segments: 2, Bytes: 2 * size}, o.Stats())
+		assert.Equal(t, 1, drops[DropReasonSize])
+
+		c := &collector{}
+		_, err = o.Replay(ctx, c.send)
+		require.NoError(t, err)
+		assert.Equal(t, []float64{2, 3}, c.sent)
+	})
+
+	t.Run("Batch larger than limit", func(t *testing.T) {
+		dir := t.TempDir()
+		o, err := Open(dir, Limits{}, onDrop)
+		require.NoError(t, err)
+		require.NoError(t, o.Push(batch(1)))
+		size := o.Stats().Bytes
+
+		o.limits.MaxBytes = size
+		dropped := drops[DropReasonSize]
+		require.NoError(t, o.Push(metrics.Metrics{metrics.MakeGaugeMetric("Alloc", 2), metrics.MakeGaugeMetric("HeapAlloc", 2)}))
+		assert.Equal(t, Stats{Segments: 1, Bytes: size}, o.Stats())
+		assert.Equal(t, dropped+1, drops[DropReasonSize])
+
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		assert.Len(t, entries, 1)
+	})
+
+	t.Run("Age", func(t *testing.T) {
+		o, err := Open(t.TempDir(), Limits{MaxAge: 50 * time.Millisecond}, onDrop)
+		require.NoError(t, err)
+		require.NoError(t, o.Push(batch(1)))
+		time.Sleep(100 * time.Millisecond)
+		require.NoError(t, o.Push(batch(2)))
+
+		c := &collector{}
+		sent, err := o.Replay(ctx, c.send)
+		require.NoError(t, err)
+		assert.Equal(t, 1, sent)
+		assert.Equal(t, []float64{2}, c.sent)
+		assert.Equal(t, 1, drops[DropReasonAge])
+	})
+
+	t.Run("Corrupt", func(t *testing.T) {
+		dir := t.TempDir()
+		o, err := Open(dir, Limits{}, onDrop)
+		require.NoError(t, err)
+		require.NoError(t, o.Push(batch(1)))
+		require.NoError(t, o.Push(batch(2)))
+		require.NoError(t, os.WriteFile(o.path(0), []byte("{broken"), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "x"+segmentExt+tmpExt), []byte("{"), 0644))
+
+		o, err = Open(dir, Limits{}, onDrop)
+		require.NoError(t, err)
+		c := &collector{}
+		_, err = o.Replay(ctx, c.send)
+		require.NoError(t, err)
+		assert.Equal(t, []float64{2}, c.sent)
+		assert.Equal(t, 1, drops[DropReasonCorrupt])
+
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		assert.Empty(t, entries)
+	})
+}
@@ -0,0 +1,240 @@
+package pollers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/vleukhin/prom-light/internal/metrics"
+)
+
+func init() {
+	Register("cgroup", func(options json.RawMessage) (Poller, error) {
+		opts := CgroupOptions{}
+		if err := decodeOptions(options, &opts); err != nil {
+			return nil, err
+		}
+		return NewCgroupPoller(opts)
+	})
+}
+
+// cgroupRoot точка монтирования cgroup v2
+const cgroupRoot = "/sys/fs/cgroup"
+
+// CgroupOptions настройки сборщика метрик cgroup
+type CgroupOptions struct {
+	// Path каталог cgroup. По умолчанию cgroup агента из /proc/self/cgroup
+	Path string `json:"path"`
+}
+
+// cgroupCPUStat имена счетчиков для полей cpu.stat
+var cgroupCPUStat = map[string]string{
+	"usage_usec":     "CgroupCPUUsageUsec",
+	"user_usec":      "CgroupCPUUserUsec",
+	"system_usec":    "CgroupCPUSystemUsec",
+	"nr_periods":     "CgroupCPUPeriods",
+	"nr_throttled":   "CgroupCPUThrottledPeriods",
+	"throttled_usec": "CgroupCPUThrottledUsec",
+}
+
+// cgroupIOStat имена счетчиков для полей io.stat
+var cgroupIOStat = map[string]string{
+	"rbytes": "CgroupIOReadBytes",
+	"wbytes": "CgroupIOWriteBytes",
+	"rios":   "CgroupIOReads",
+	"wios":   "CgroupIOWrites",
+}
+
+// CgroupPoller собирает потребление ресурсов и троттлинг контейнера из файлов cgroup v2.
+// Файлы выключенных контроллеров пропускаются
+type CgroupPoller struct {
+	path   string
+	deltas *counterDeltas
+}
+
+// NewCgroupPoller создает сборщик метрик cgroup
+func NewCgroupPoller(opts CgroupOptions) (*CgroupPoller, error) {
+	path := opts.Path
+	if path == "" {
+		var err error
+		if path, err = selfCgroupPath(); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := os.Stat(filepath.Join(path, "cgroup.controllers")); err != nil {
+		return nil, fmt.Errorf("%s is not cgroup v2 directory: %w", path, err)
+	}
+
+	return &CgroupPoller{path: path, deltas: newCounterDeltas()}, nil
+}
+
+// selfCgroupPath выдает каталог cgroup v2 текущего процесса. В контейнере с отдельным
+// пространством имен cgroup это корень иерархии. В гибридном режиме systemd иерархия
+// cgroup v2 смонтирована в unified
+func selfCgroupPath() (string, error) {
+	raw, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(raw), "\n") {
+		// Для cgroup v2 строка имеет вид 0::/path
+		if !strings.HasPrefix(line, "0::") {
+			continue
+		}
+		rel := strings.TrimPrefix(line, "0::")
+		path := filepath.Join(cgroupRoot, rel)
+		if _, err := os.Stat(filepath.Join(path, "cgroup.controllers")); err != nil {
+			path = filepath.Join(cgroupRoot, "unified", rel)
+		}
+		return path, nil
+	}
+
+	return "", errors.New("cgroup v2 is not used by this process")
+}
+
+func (p *CgroupPoller) Poll() (metrics.Metrics, error) {
+	var mtrcs metrics.Metrics
+
+	usage, err := p.readValue("memory.current")
+	if err != nil {
+		return nil, err
+	}
+	limit, err := p.readValue("memory.max")
+	if err != nil {
+		return nil, err
+	}
+	if usage != nil {
+		mtrcs = append(mtrcs, metrics.MakeGaugeMetric("CgroupMemoryUsage", metrics.Gauge(*usage)))
+	}
+	if limit != nil {
+		mtrcs = append(mtrcs, metrics.MakeGaugeMetric("CgroupMemoryLimit", metrics.Gauge(*limit)))
+		if usage != nil && *limit > 0 {
+			mtrcs = append(mtrcs, metrics.MakeGaugeMetric("CgroupMemoryUsedPercent", metrics.Gauge(float64(*usage)/float64(*limit)*100)))
+		}
+	}
+
+	pids, err := p.readValue("pids.current")
+	if err != nil {
+		return nil, err
+	}
+	if pids != nil {
+		mtrcs = append(mtrcs, metrics.MakeGaugeMetric("CgroupPids", metrics.Gauge(*pids)))
+	}
+	pidsLimit, err := p.readValue("pids.max")
+	if err != nil {
+		return nil, err
+	}
+	if pidsLimit != nil {
+		mtrcs = append(mtrcs, metrics.MakeGaugeMetric("CgroupPidsLimit", metrics.Gauge(*pidsLimit)))
+	}
+
+	cpu, err := p.pollCPU()
+	if err != nil {
+		return nil, err
+	}
+	io, err := p.pollIO()
+	if err != nil {
+		return nil, err
+	}
+	p.deltas.commit()
+
+	return append(append(mtrcs, cpu...), io...), nil
+}
+
+// pollCPU читает счетчики времени процессора и троттлинга из cpu.stat
+func (p *CgroupPoller) pollCPU() (metrics.Metrics, error) {
+	var mtrcs metrics.Metrics
+	err := p.scanLines("cpu.stat", func(fields []string) error {
+		// usage_usec 12345
+		if len(fields) != 2 {
+			return nil
+		}
+		name, ok := cgroupCPUStat[fields[0]]
+		if !ok {
+			return nil
+		}
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("bad cpu.stat value %s: %w", fields[0], err)
+		}
+		mtrcs = append(mtrcs, p.deltas.counter(name, nil, value))
+		return nil
+	})
+
+	return mtrcs, err
+}
+
+// pollIO читает счетчики ввода-вывода по устройствам из io.stat
+func (p *CgroupPoller) pollIO() (metrics.Metrics, error) {
+	var mtrcs metrics.Metrics
+	err := p.scanLines("io.stat", func(fields []string) error {
+		// 8:0 rbytes=1024 wbytes=2048 rios=1 wios=2 dbytes=0 dios=0
+		if len(fields) < 2 {
+			return nil
+		}
+		labels := metrics.Labels{"device": fields[0]}
+		for _, field := range fields[1:] {
+			key, raw, ok := strings.Cut(field, "=")
+			name, known := cgroupIOStat[key]
+			if !ok || !known {
+				continue
+			}
+			value, err := strconv.ParseUint(raw, 10, 64)
+			if err != nil {
+				return fmt.Errorf("bad io.stat value %s: %w", field, err)
+			}
+			mtrcs = append(mtrcs, p.deltas.counter(name, labels, value))
+		}
+		return nil
+	})
+
+	return mtrcs, err
+}
+
+// readValue читает файл с одним числом. nil - файла нет или значение "max", то есть без ограничения
+func (p *CgroupPoller) readValue(name string) (*uint64, error) {
+	raw, err := os.ReadFile(filepath.Join(p.path, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	raw = bytes.TrimSpace(raw)
+	if string(raw) == "max" {
+		return nil, nil
+	}
+	value, err := strconv.ParseUint(string(raw), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("bad %s value: %w", name, err)
+	}
+
+	return &value, nil
+}
+
+// scanLines вызывает fn для полей каждой строки файла. Отсутствующий файл пропускается
+func (p *CgroupPoller) scanLines(name string, fn func(fields []string) error) error {
+	f, err := os.Open(filepath.Join(p.path, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if err := fn(strings.Fields(scanner.Text())); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
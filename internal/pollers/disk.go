@@ -0,0 +1,142 @@
+package pollers
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+	"github.com/shirou/gopsutil/disk"
+
+	"github.com/vleukhin/prom-light/internal/metrics"
+)
+
+func init() {
+	Register("disk", func(options json.RawMessage) (Poller, error) {
+		opts := DiskOptions{}
+		if err := decodeOptions(options, &opts); err != nil {
+			return nil, err
+		}
+		return NewDiskPoller(opts)
+	})
+}
+
+// defaultDiskDevicesExclude устройства, которые не опрашиваются, если фильтр устройств не задан
+var defaultDiskDevicesExclude = []string{"loop*", "ram*"}
+
+// DiskOptions настройки сборщика метрик дисков
+type DiskOptions struct {
+	// Mountpoints фильтр точек монтирования
+	Mountpoints Filter `json:"mountpoints"`
+	// FSTypes фильтр типов файловых систем
+	FSTypes Filter `json:"fs_types"`
+	// Devices фильтр устройств для счетчиков ввода-вывода, по умолчанию исключаются loop и ram устройства
+	Devices Filter `json:"devices"`
+	// AllPartitions включает виртуальные файловые системы, например tmpfs
+	AllPartitions bool `json:"all_partitions"`
+}
+
+// DiskPoller собирает заполненность файловых систем и счетчики ввода-вывода дисков
+type DiskPoller struct {
+	opts   DiskOptions
+	deltas *counterDeltas
+
+	partitions func(all bool) ([]disk.PartitionStat, error)
+	usage      func(path string) (*disk.UsageStat, error)
+	ioCounters func(names ...string) (map[string]disk.IOCountersStat, error)
+}
+
+// NewDiskPoller создает сборщик метрик дисков
+func NewDiskPoller(opts DiskOptions) (*DiskPoller, error) {
+	for name, f := range map[string]Filter{"mountpoints": opts.Mountpoints, "fs_types": opts.FSTypes, "devices": opts.Devices} {
+		if err := f.validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	if opts.Devices.IsEmpty() {
+		opts.Devices.Exclude = defaultDiskDevicesExclude
+	}
+
+	return &DiskPoller{
+		opts:       opts,
+		deltas:     newCounterDeltas(),
+		partitions: disk.Partitions,
+		usage:      disk.Usage,
+		ioCounters: disk.IOCounters,
+	}, nil
+}
+
+func (p *DiskPoller) Poll() (metrics.Metrics, error) {
+	mtrcs, err := p.pollUsage()
+	if err != nil {
+		return nil, err
+	}
+	io, err := p.pollIO()
+	if err != nil {
+		return nil, err
+	}
+	p.deltas.commit()
+
+	return append(mtrcs, io...), nil
+}
+
+// pollUsage собирает заполненность файловых систем. Файловые системы, которые не удалось
+// опросить, например из-за прав доступа, пропускаются
+func (p *DiskPoller) pollUsage() (metrics.Metrics, error) {
+	partitions, err := p.partitions(p.opts.AllPartitions)
+	if err != nil {
+		return nil, err
+	}
+
+	mtrcs := make(metrics.Metrics, 0, len(partitions)*7)
+	for _, partition := range partitions {
+		if !p.opts.Mountpoints.Match(partition.Mountpoint) || !p.opts.FSTypes.Match(partition.Fstype) {
+			continue
+		}
+		usage, err := p.usage(partition.Mountpoint)
+		if err != nil {
+			log.Warn().Err(err).Str("mountpoint", partition.Mountpoint).Msg("Failed to get disk usage")
+			continue
+		}
+
+		labels := metrics.Labels{"mountpoint": partition.Mountpoint, "fstype": partition.Fstype, "device": partition.Device}
+		for name, value := range map[string]metrics.Gauge{
+			"DiskTotal":       metrics.Gauge(usage.Total),
+			"DiskUsed":        metrics.Gauge(usage.Used),
+			"DiskFree":        metrics.Gauge(usage.Free),
+			"DiskUsedPercent": metrics.Gauge(usage.UsedPercent),
+			"DiskInodesTotal": metrics.Gauge(usage.InodesTotal),
+			"DiskInodesUsed":  metrics.Gauge(usage.InodesUsed),
+			"DiskInodesFree":  metrics.Gauge(usage.InodesFree),
+		} {
+			m := metrics.MakeGaugeMetric(name, value)
+			m.Labels = labels
+			mtrcs = append(mtrcs, m)
+		}
+	}
+
+	return mtrcs, nil
+}
+
+// pollIO собирает счетчики ввода-вывода устройств
+func (p *DiskPoller) pollIO() (metrics.Metrics, error) {
+	counters, err := p.ioCounters()
+	if err != nil {
+		return nil, err
+	}
+
+	mtrcs := make(metrics.Metrics, 0, len(counters)*4)
+	for device, c := range counters {
+		if !p.opts.Devices.Match(device) {
+			continue
+		}
+		labels := metrics.Labels{"device": device}
+		mtrcs = append(mtrcs,
+			p.deltas.counter("DiskReadBytes", labels, c.ReadBytes),
+			p.deltas.counter("DiskWriteBytes", labels, c.WriteBytes),
+			p.deltas.counter("DiskReads", labels, c.ReadCount),
+			p.deltas.counter("DiskWrites", labels, c.WriteCount),
+		)
+	}
+
+	return mtrcs, nil
+}
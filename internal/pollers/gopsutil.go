@@ -1,16 +1,38 @@
 package pollers
 
 import (
+	"encoding/json"
+	"errors"
 	"strconv"
 	"time"
 
 	"github.com/shirou/gopsutil/cpu"
 	"github.com/shirou/gopsutil/mem"
 
+	"github.com/vleukhin/prom-light/internal/config"
 	"github.com/vleukhin/prom-light/internal/metrics"
 )
 
+func init() {
+	Register("ps", newPsPoller)
+}
+
+// PsPoller собирает метрики памяти и загрузки процессоров системы
 type PsPoller struct {
+	// CPUInterval время, за которое измеряется загрузка процессоров, по умолчанию 1 секунда
+	CPUInterval config.Duration `json:"cpu_interval"`
+}
+
+func newPsPoller(options json.RawMessage) (Poller, error) {
+	p := PsPoller{}
+	if err := decodeOptions(options, &p); err != nil {
+		return nil, err
+	}
+	if p.CPUInterval.Duration < 0 {
+		return nil, errors.New("negative cpu_interval")
+	}
+
+	return p, nil
 }
 
 func (p PsPoller) Poll() (metrics.Metrics, error) {
@@ -25,7 +47,11 @@ func (p PsPoller) Poll() (metrics.Metrics, error) {
 	if err != nil {
 		return mtrcs, err
 	}
-	utilization, err = cpu.Percent(time.Second, true)
+	interval := p.CPUInterval.Duration
+	if interval == 0 {
+		interval = time.Second
+	}
+	utilization, err = cpu.Percent(interval, true)
 	if err != nil {
 		return mtrcs, err
 	}
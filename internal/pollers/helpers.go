@@ -0,0 +1,104 @@
+package pollers
+
+import (
+	"fmt"
+	"path"
+	"sync"
+
+	"github.com/vleukhin/prom-light/internal/metrics"
+)
+
+// Filter отбирает объекты по имени: имя должно подходить под один из шаблонов Include,
+// если они заданы, и не подходить ни под один из шаблонов Exclude.
+// Шаблоны в формате path.Match, например "/mnt/*" или "loop*"
+type Filter struct {
+	Include []string `json:"include"`
+	Exclude []string `json:"exclude"`
+}
+
+// IsEmpty проверяет, что фильтр пропускает все имена
+func (f Filter) IsEmpty() bool {
+	return len(f.Include) == 0 && len(f.Exclude) == 0
+}
+
+// validate проверяет шаблоны фильтра
+func (f Filter) validate() error {
+	for _, patterns := range [][]string{f.Include, f.Exclude} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("bad pattern %q: %w", pattern, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Match проверяет, что имя проходит фильтр
+func (f Filter) Match(name string) bool {
+	if len(f.Include) > 0 && !matchAny(f.Include, name) {
+		return false
+	}
+
+	return !matchAny(f.Exclude, name)
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// counterDeltas превращает накопленные системой значения счетчиков в приросты с прошлого опроса,
+// потому что агент складывает значения счетчиков из опросов
+type counterDeltas struct {
+	mutex sync.Mutex
+	prev  map[string]uint64
+	// current значения счетчиков текущего опроса, становятся prev после commit
+	current map[string]uint64
+}
+
+func newCounterDeltas() *counterDeltas {
+	return &counterDeltas{prev: make(map[string]uint64), current: make(map[string]uint64)}
+}
+
+// delta выдает прирост счетчика key с прошлого опроса. При первом опросе прирост
+// неизвестен и счетчик выдается с нулевым приростом, при сбросе счетчика
+// (значение уменьшилось) приростом считается все значение
+func (d *counterDeltas) delta(key string, value uint64) metrics.Counter {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	prev, ok := d.prev[key]
+	d.current[key] = value
+	switch {
+	case !ok:
+		return 0
+	case value < prev:
+		return metrics.Counter(value)
+	default:
+		return metrics.Counter(value - prev)
+	}
+}
+
+// counter создает метрику счетчика с приростом значения value с прошлого опроса
+func (d *counterDeltas) counter(name string, labels metrics.Labels, value uint64) metrics.Metric {
+	m := metrics.MakeCounterMetric(name, d.delta(metrics.Metric{Name: name, Labels: labels}.ID(), value))
+	m.Labels = labels
+
+	return m
+}
+
+// commit завершает успешный опрос. Счетчики, которых не было в опросе, забываются,
+// чтобы значения отключенных устройств и интерфейсов не копились
+func (d *counterDeltas) commit() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.prev = d.current
+	d.current = make(map[string]uint64, len(d.prev))
+}
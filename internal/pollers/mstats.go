@@ -1,12 +1,23 @@
 package pollers
 
 import (
+	"encoding/json"
 	"math/rand"
 	"runtime"
 
 	"github.com/vleukhin/prom-light/internal/metrics"
 )
 
+func init() {
+	Register("memstats", func(options json.RawMessage) (Poller, error) {
+		if err := decodeOptions(options, &struct{}{}); err != nil {
+			return nil, err
+		}
+		return MemStatsPoller{}, nil
+	})
+}
+
+// MemStatsPoller собирает метрики рантайма Go самого агента
 type MemStatsPoller struct {
 }
 
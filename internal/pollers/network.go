@@ -0,0 +1,178 @@
+package pollers
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/vleukhin/prom-light/internal/metrics"
+)
+
+func init() {
+	Register("network", func(options json.RawMessage) (Poller, error) {
+		opts := NetworkOptions{}
+		if err := decodeOptions(options, &opts); err != nil {
+			return nil, err
+		}
+		return NewNetworkPoller(opts)
+	})
+}
+
+// NetworkOptions настройки сборщика сетевых метрик
+type NetworkOptions struct {
+	// ProcPath путь к procfs, по умолчанию /proc
+	ProcPath string `json:"proc_path"`
+	// Interfaces фильтр сетевых интерфейсов по имени
+	Interfaces Filter `json:"interfaces"`
+}
+
+// netDevFields имена счетчиков из /proc/net/dev в порядке колонок
+var netDevFields = []string{
+	"NetRxBytes", "NetRxPackets", "NetRxErrors", "NetRxDropped", "", "", "", "",
+	"NetTxBytes", "NetTxPackets", "NetTxErrors", "NetTxDropped",
+}
+
+// tcpStates состояния TCP соединений по кодам из /proc/net/tcp
+var tcpStates = map[string]string{
+	"01": "established",
+	"02": "syn_sent",
+	"03": "syn_recv",
+	"04": "fin_wait1",
+	"05": "fin_wait2",
+	"06": "time_wait",
+	"07": "close",
+	"08": "close_wait",
+	"09": "last_ack",
+	"0A": "listen",
+	"0B": "closing",
+	"0C": "new_syn_recv",
+}
+
+// NetworkPoller собирает счетчики сетевых интерфейсов и количество TCP соединений
+// по состояниям из procfs Linux
+type NetworkPoller struct {
+	opts   NetworkOptions
+	deltas *counterDeltas
+}
+
+// NewNetworkPoller создает сборщик сетевых метрик
+func NewNetworkPoller(opts NetworkOptions) (*NetworkPoller, error) {
+	if err := opts.Interfaces.validate(); err != nil {
+		return nil, fmt.Errorf("interfaces: %w", err)
+	}
+	if opts.ProcPath == "" {
+		opts.ProcPath = "/proc"
+	}
+
+	return &NetworkPoller{opts: opts, deltas: newCounterDeltas()}, nil
+}
+
+func (p *NetworkPoller) Poll() (metrics.Metrics, error) {
+	mtrcs, err := p.pollInterfaces()
+	if err != nil {
+		return nil, err
+	}
+	conns, err := p.pollConnections()
+	if err != nil {
+		return nil, err
+	}
+	p.deltas.commit()
+
+	return append(mtrcs, conns...), nil
+}
+
+// pollInterfaces читает счетчики интерфейсов из /proc/net/dev
+func (p *NetworkPoller) pollInterfaces() (metrics.Metrics, error) {
+	f, err := os.Open(filepath.Join(p.opts.ProcPath, "net", "dev"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var mtrcs metrics.Metrics
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Строки заголовка не содержат двоеточия
+		name, values, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		name = strings.TrimSpace(name)
+		if !p.opts.Interfaces.Match(name) {
+			continue
+		}
+		fields := strings.Fields(values)
+		if len(fields) < len(netDevFields) {
+			return nil, fmt.Errorf("bad /proc/net/dev line for %s", name)
+		}
+
+		labels := metrics.Labels{"interface": name}
+		for i, metricName := range netDevFields {
+			if metricName == "" {
+				continue
+			}
+			value, err := strconv.ParseUint(fields[i], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("bad /proc/net/dev value for %s: %w", name, err)
+			}
+			mtrcs = append(mtrcs, p.deltas.counter(metricName, labels, value))
+		}
+	}
+
+	return mtrcs, scanner.Err()
+}
+
+// pollConnections считает TCP соединения IPv4 и IPv6 по состояниям. Состояния без соединений
+// выдаются с нулем, чтобы значение не зависало на последнем ненулевом
+func (p *NetworkPoller) pollConnections() (metrics.Metrics, error) {
+	counts := make(map[string]int, len(tcpStates))
+	for _, file := range []string{"tcp", "tcp6"} {
+		err := countTCPStates(filepath.Join(p.opts.ProcPath, "net", file), counts)
+		// tcp6 нет, если IPv6 отключен
+		if errors.Is(err, os.ErrNotExist) && file == "tcp6" {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	mtrcs := make(metrics.Metrics, 0, len(tcpStates))
+	for _, state := range tcpStates {
+		m := metrics.MakeGaugeMetric("TCPConnections", metrics.Gauge(counts[state]))
+		m.Labels = metrics.Labels{"state": state}
+		mtrcs = append(mtrcs, m)
+	}
+
+	return mtrcs, nil
+}
+
+// countTCPStates считает соединения из файла формата /proc/net/tcp по состояниям
+func countTCPStates(name string, counts map[string]int) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	// Первая строка - заголовок
+	scanner.Scan()
+	for scanner.Scan() {
+		// sl local_address rem_address st ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+		if state, ok := tcpStates[strings.ToUpper(fields[3])]; ok {
+			counts[state]++
+		}
+	}
+
+	return scanner.Err()
+}
@@ -1,6 +1,17 @@
 package pollers
 
-import "testing"
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/shirou/gopsutil/disk"
+
+	"github.com/vleukhin/prom-light/internal/metrics"
+)
 
 func TestPollers(t *testing.T) {
 	t.Run("ps", func(t *testing.T) {
@@ -17,6 +28,29 @@ func TestPollers(t *testing.T) {
 			t.Errorf("Got error from mstats poller")
 		}
 	})
+	t.Run("disk", func(t *testing.T) {
+		poller, err := NewDiskPoller(DiskOptions{})
+		if err != nil {
+			t.Fatalf("NewDiskPoller() error = %v", err)
+		}
+		_, err = poller.Poll()
+		if err != nil {
+			t.Errorf("Got error from disk poller: %v", err)
+		}
+	})
+	t.Run("process", func(t *testing.T) {
+		poller, err := NewProcessPoller(ProcessOptions{Processes: []ProcessMatcher{{Name: "self", Cmdline: "."}}})
+		if err != nil {
+			t.Fatalf("NewProcessPoller() error = %v", err)
+		}
+		mtrcs, err := poller.Poll()
+		if err != nil {
+			t.Fatalf("Got error from process poller: %v", err)
+		}
+		if m, ok := findMetric(mtrcs, "ProcessCount", metrics.Labels{"process": "self"}); !ok || *m.Value == 0 {
+			t.Errorf("ProcessCount{self} = %v, %v; want at least this test process", m, ok)
+		}
+	})
 }
 
 func BenchmarkPollers(b *testing.B) {
@@ -35,3 +69,497 @@ func BenchmarkPollers(b *testing.B) {
 		}
 	})
 }
+
+func TestRegistry(t *testing.T) {
+	names := Names()
+	for _, name := range []string{"memstats", "ps", "disk", "network"} {
+		if _, err := New(name, nil); err != nil {
+			t.Errorf("New(%q) error = %v; registered: %v", name, err, names)
+		}
+	}
+
+	p, err := New("ps", []byte(`{"cpu_interval":"10ms"}`))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if got := p.(PsPoller).CPUInterval.Duration; got != 10*time.Millisecond {
+		t.Errorf("CPUInterval = %v; want %v", got, 10*time.Millisecond)
+	}
+
+	if _, err := New("unknown", nil); err == nil {
+		t.Errorf("New() unknown poller expected error")
+	}
+	if _, err := New("memstats", []byte(`{"interval":1}`)); err == nil {
+		t.Errorf("New() unknown option expected error")
+	}
+}
+
+func TestFilter_Match(t *testing.T) {
+	f := Filter{Include: []string{"/", "/mnt/*"}, Exclude: []string{"/mnt/cdrom"}}
+	for name, want := range map[string]bool{
+		"/":          true,
+		"/mnt/data":  true,
+		"/mnt/cdrom": false,
+		"/boot":      false,
+		"/mnt/a/b":   false,
+	} {
+		if got := f.Match(name); got != want {
+			t.Errorf("Match(%q) = %v; want %v", name, got, want)
+		}
+	}
+	if !(Filter{}).Match("anything") {
+		t.Errorf("empty filter must match everything")
+	}
+	if err := (Filter{Exclude: []string{"["}}).validate(); err == nil {
+		t.Errorf("validate() bad pattern expected error")
+	}
+}
+
+func TestCounterDeltas(t *testing.T) {
+	d := newCounterDeltas()
+	poll := func(values map[string]uint64) map[string]metrics.Counter {
+		deltas := make(map[string]metrics.Counter, len(values))
+		for key, value := range values {
+			deltas[key] = d.delta(key, value)
+		}
+		d.commit()
+		return deltas
+	}
+
+	poll(map[string]uint64{"sda": 100, "sdb": 50})
+	if got := poll(map[string]uint64{"sda": 150}); got["sda"] != 50 {
+		t.Errorf("delta(sda) = %v; want 50", got["sda"])
+	}
+	if _, ok := d.prev["sdb"]; ok {
+		t.Errorf("counter of device missing from the last poll is still stored")
+	}
+	// Вернувшееся устройство считается новым, а сброшенный счетчик учитывается целиком
+	if got := poll(map[string]uint64{"sda": 20, "sdb": 70}); got["sda"] != 20 || got["sdb"] != 0 {
+		t.Errorf("deltas = %v; want sda 20 after reset and sdb 0 as new", got)
+	}
+}
+
+// findMetric ищет метрику с именем name и метками labels
+func findMetric(mtrcs metrics.Metrics, name string, labels metrics.Labels) (metrics.Metric, bool) {
+	for _, m := range mtrcs {
+		if m.Name == name && reflect.DeepEqual(m.Labels, labels) {
+			return m, true
+		}
+	}
+
+	return metrics.Metric{}, false
+}
+
+func TestDiskPoller_Poll(t *testing.T) {
+	poller, err := NewDiskPoller(DiskOptions{
+		Mountpoints: Filter{Exclude: []string{"/boot"}},
+		FSTypes:     Filter{Exclude: []string{"vfat"}},
+	})
+	if err != nil {
+		t.Fatalf("NewDiskPoller() error = %v", err)
+	}
+	poller.partitions = func(bool) ([]disk.PartitionStat, error) {
+		return []disk.PartitionStat{
+			{Device: "/dev/sda1", Mountpoint: "/", Fstype: "ext4"},
+			{Device: "/dev/sda2", Mountpoint: "/boot", Fstype: "ext4"},
+			{Device: "/dev/sdb1", Mountpoint: "/efi", Fstype: "vfat"},
+			{Device: "/dev/sdc1", Mountpoint: "/secret", Fstype: "xfs"},
+		}, nil
+	}
+	poller.usage = func(path string) (*disk.UsageStat, error) {
+		if path == "/secret" {
+			return nil, errors.New("permission denied")
+		}
+		return &disk.UsageStat{Path: path, Total: 100, Used: 90, Free: 10, UsedPercent: 90, InodesFree: 5}, nil
+	}
+	read := uint64(1000)
+	poller.ioCounters = func(...string) (map[string]disk.IOCountersStat, error) {
+		read += 500
+		return map[string]disk.IOCountersStat{
+			"sda":   {Name: "sda", ReadBytes: read, ReadCount: 10},
+			"loop0": {Name: "loop0", ReadBytes: read},
+		}, nil
+	}
+
+	mtrcs, err := poller.Poll()
+	if err != nil {
+		t.Fatalf("Poll() error = %v", err)
+	}
+	root := metrics.Labels{"mountpoint": "/", "fstype": "ext4", "device": "/dev/sda1"}
+	if m, ok := findMetric(mtrcs, "DiskFree", root); !ok || *m.Value != 10 {
+		t.Errorf("DiskFree{/} = %v, %v; want 10", m, ok)
+	}
+	if m, ok := findMetric(mtrcs, "DiskInodesFree", root); !ok || *m.Value != 5 {
+		t.Errorf("DiskInodesFree{/} = %v, %v; want 5", m, ok)
+	}
+	for _, m := range mtrcs {
+		if mp := m.Labels["mountpoint"]; mp != "" && mp != "/" {
+			t.Errorf("unexpected metric %s for filtered out mountpoint %s", m.Name, mp)
+		}
+		if m.Labels["device"] == "loop0" {
+			t.Errorf("unexpected metric %s for loop device", m.Name)
+		}
+	}
+	sda := metrics.Labels{"device": "sda"}
+	if m, ok := findMetric(mtrcs, "DiskReadBytes", sda); !ok || *m.Delta != 0 {
+		t.Errorf("DiskReadBytes{sda} first poll = %v, %v; want 0", m, ok)
+	}
+
+	mtrcs, err = poller.Poll()
+	if err != nil {
+		t.Fatalf("Poll() error = %v", err)
+	}
+	if m, ok := findMetric(mtrcs, "DiskReadBytes", sda); !ok || *m.Delta != 500 {
+		t.Errorf("DiskReadBytes{sda} = %v, %v; want delta 500", m, ok)
+	}
+	if m, ok := findMetric(mtrcs, "DiskReads", sda); !ok || *m.Delta != 0 {
+		t.Errorf("DiskReads{sda} = %v, %v; want delta 0", m, ok)
+	}
+}
+
+// writeFiles создает файлы в каталоге root
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("MkdirAll() error = %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+	}
+}
+
+func TestNetworkPoller_Poll(t *testing.T) {
+	const devHeader = `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+`
+	const tcpHeader = "  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode\n"
+	proc := t.TempDir()
+	writeFiles(t, proc, map[string]string{
+		"net/dev": devHeader +
+			"    lo:  1000      10    0    0    0     0          0         0     1000      10    0    0    0     0       0          0\n" +
+			"  eth0:  5000      50    1    2    0     0          0         0     3000      30    3    4    0     0       0          0\n",
+		"net/tcp": tcpHeader +
+			"   0: 00000000:1F90 00000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 1 1 0 100 0 0 10 0\n" +
+			"   1: 0100007F:1F90 0100007F:D2F0 01 00000000:00000000 00:00000000 00000000  1000        0 2 1 0 20 4 30 10 -1\n" +
+			"   2: 0100007F:D2F0 0100007F:1F90 01 00000000:00000000 00:00000000 00000000  1000        0 3 1 0 20 4 30 10 -1\n",
+	})
+
+	poller, err := NewNetworkPoller(NetworkOptions{ProcPath: proc, Interfaces: Filter{Exclude: []string{"lo"}}})
+	if err != nil {
+		t.Fatalf("NewNetworkPoller() error = %v", err)
+	}
+	mtrcs, err := poller.Poll()
+	if err != nil {
+		t.Fatalf("Poll() error = %v", err)
+	}
+	for state, want := range map[string]metrics.Gauge{"established": 2, "listen": 1, "time_wait": 0} {
+		if m, ok := findMetric(mtrcs, "TCPConnections", metrics.Labels{"state": state}); !ok || *m.Value != want {
+			t.Errorf("TCPConnections{%s} = %v, %v; want %v", state, m, ok, want)
+		}
+	}
+	for _, m := range mtrcs {
+		if m.Labels["interface"] == "lo" {
+			t.Errorf("unexpected metric %s for filtered out interface", m.Name)
+		}
+	}
+
+	writeFiles(t, proc, map[string]string{
+		"net/dev": devHeader +
+			"  eth0:  5600      56    1    3    0     0          0         0     3100      31    3    4    0     0       0          0\n",
+		"net/tcp6": tcpHeader +
+			"   0: 00000000000000000000000000000000:1F90 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 4 1 0 100 0 0 10 0\n",
+	})
+	mtrcs, err = poller.Poll()
+	if err != nil {
+		t.Fatalf("Poll() error = %v", err)
+	}
+	eth0 := metrics.Labels{"interface": "eth0"}
+	for name, want := range map[string]metrics.Counter{
+		"NetRxBytes":   600,
+		"NetRxPackets": 6,
+		"NetRxErrors":  0,
+		"NetRxDropped": 1,
+		"NetTxBytes":   100,
+		"NetTxPackets": 1,
+	} {
+		if m, ok := findMetric(mtrcs, name, eth0); !ok || *m.Delta != want {
+			t.Errorf("%s{eth0} = %v, %v; want delta %v", name, m, ok, want)
+		}
+	}
+	if m, ok := findMetric(mtrcs, "TCPConnections", metrics.Labels{"state": "listen"}); !ok || *m.Value != 2 {
+		t.Errorf("TCPConnections{listen} with tcp6 = %v, %v; want 2", m, ok)
+	}
+
+	missing, err := NewNetworkPoller(NetworkOptions{ProcPath: t.TempDir()})
+	if err != nil {
+		t.Fatalf("NewNetworkPoller() error = %v", err)
+	}
+	if _, err := missing.Poll(); err == nil {
+		t.Errorf("Poll() without procfs expected error")
+	}
+}
+
+// fakeProcessSource процессы для тестов
+type fakeProcessSource struct {
+	ids       map[int32]processIdentity
+	resources map[int32]processStats
+	// failStats процессы, сведения о ресурсах которых временно недоступны
+	failStats map[int32]bool
+}
+
+func (f *fakeProcessSource) start(id processIdentity, stats processStats) {
+	f.ids[id.PID] = id
+	f.resources[id.PID] = stats
+}
+
+func (f *fakeProcessSource) stop(pid int32) {
+	delete(f.ids, pid)
+	delete(f.resources, pid)
+}
+
+func (f *fakeProcessSource) pids() ([]int32, error) {
+	pids := make([]int32, 0, len(f.ids))
+	for pid := range f.ids {
+		pids = append(pids, pid)
+	}
+	return pids, nil
+}
+
+func (f *fakeProcessSource) identity(pid int32) (processIdentity, error) {
+	id, ok := f.ids[pid]
+	if !ok {
+		return id, errors.New("process does not exist")
+	}
+	return id, nil
+}
+
+func (f *fakeProcessSource) stats(pid int32) (processStats, error) {
+	if f.failStats[pid] {
+		return processStats{}, errors.New("temporary failure")
+	}
+	stats, ok := f.resources[pid]
+	if !ok {
+		return stats, errors.New("process does not exist")
+	}
+	return stats, nil
+}
+
+func TestProcessPoller_Poll(t *testing.T) {
+	now := time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)
+	pidfile := filepath.Join(t.TempDir(), "db.pid")
+	if err := os.WriteFile(pidfile, []byte("20\n"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	poller, err := NewProcessPoller(ProcessOptions{Processes: []ProcessMatcher{
+		{Name: "nginx", ProcessName: "nginx"},
+		{Name: "app", Cmdline: `java .*-jar app\.jar`},
+		{Name: "db", Pidfile: pidfile},
+	}})
+	if err != nil {
+		t.Fatalf("NewProcessPoller() error = %v", err)
+	}
+	source := &fakeProcessSource{ids: make(map[int32]processIdentity), resources: make(map[int32]processStats), failStats: make(map[int32]bool)}
+	poller.source = source
+	poller.now = func() time.Time { return now }
+
+	source.start(processIdentity{PID: 10, Name: "nginx", CreateTime: now.Add(-time.Hour)}, processStats{CPUTime: 5 * time.Second, RSS: 100, FDs: 10, Threads: 1})
+	source.start(processIdentity{PID: 11, Name: "nginx", CreateTime: now.Add(-time.Minute)}, processStats{CPUTime: time.Second, RSS: 50, FDs: 5, Threads: 2})
+	source.start(processIdentity{PID: 12, Name: "java", Cmdline: "java -Xmx1g -jar app.jar"}, processStats{})
+	source.start(processIdentity{PID: 13, Name: "java", Cmdline: "java -jar other.jar"}, processStats{})
+	source.start(processIdentity{PID: 20, Name: "postgres", CreateTime: now.Add(-2 * time.Hour)}, processStats{CPUTime: time.Second})
+
+	poll := func() metrics.Metrics {
+		t.Helper()
+		mtrcs, err := poller.Poll()
+		if err != nil {
+			t.Fatalf("Poll() error = %v", err)
+		}
+		return mtrcs
+	}
+	assertGauge := func(mtrcs metrics.Metrics, name, process string, want metrics.Gauge) {
+		t.Helper()
+		if m, ok := findMetric(mtrcs, name, metrics.Labels{"process": process}); !ok || *m.Value != want {
+			t.Errorf("%s{%s} = %v, %v; want %v", name, process, m, ok, want)
+		}
+	}
+	assertCounter := func(mtrcs metrics.Metrics, name, process string, want metrics.Counter) {
+		t.Helper()
+		if m, ok := findMetric(mtrcs, name, metrics.Labels{"process": process}); !ok || *m.Delta != want {
+			t.Errorf("%s{%s} = %v, %v; want delta %v", name, process, m, ok, want)
+		}
+	}
+
+	// Первый опрос - точка отсчета для счетчиков
+	mtrcs := poll()
+	assertGauge(mtrcs, "ProcessCount", "nginx", 2)
+	assertGauge(mtrcs, "ProcessRSS", "nginx", 150)
+	assertGauge(mtrcs, "ProcessOpenFDs", "nginx", 15)
+	assertGauge(mtrcs, "ProcessThreads", "nginx", 3)
+	assertGauge(mtrcs, "ProcessUptimeSeconds", "nginx", 3600)
+	assertCounter(mtrcs, "ProcessCPUTimeMs", "nginx", 0)
+	assertCounter(mtrcs, "ProcessStarts", "nginx", 0)
+	assertGauge(mtrcs, "ProcessCount", "app", 1)
+	assertGauge(mtrcs, "ProcessCount", "db", 1)
+	assertGauge(mtrcs, "ProcessUptimeSeconds", "db", 7200)
+
+	source.resources[10] = processStats{CPUTime: 7 * time.Second, RSS: 100, FDs: 10, Threads: 1}
+	mtrcs = poll()
+	assertCounter(mtrcs, "ProcessCPUTimeMs", "nginx", 2000)
+
+	// Сведения о процессе временно недоступны: это не перезапуск
+	source.failStats[11] = true
+	mtrcs = poll()
+	assertGauge(mtrcs, "ProcessCount", "nginx", 1)
+	assertCounter(mtrcs, "ProcessCPUTimeMs", "nginx", 0)
+	delete(source.failStats, 11)
+	source.resources[11] = processStats{CPUTime: 2 * time.Second, RSS: 50, FDs: 5, Threads: 2}
+	mtrcs = poll()
+	assertCounter(mtrcs, "ProcessCPUTimeMs", "nginx", 1000)
+	assertCounter(mtrcs, "ProcessStarts", "nginx", 0)
+
+	// Ошибка одного matcher не сдвигает точки отсчета остальных
+	source.resources[11] = processStats{CPUTime: 3 * time.Second, RSS: 50, FDs: 5, Threads: 2}
+	if err := os.WriteFile(pidfile, []byte("garbage"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if _, err := poller.Poll(); err == nil {
+		t.Errorf("Poll() with bad pidfile expected error")
+	}
+	if err := os.WriteFile(pidfile, []byte("20"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	mtrcs = poll()
+	assertCounter(mtrcs, "ProcessCPUTimeMs", "nginx", 1000)
+
+	// Перезапуск: накопленное время нового процесса меньше старого, но счетчик не уменьшается
+	source.stop(10)
+	source.start(processIdentity{PID: 10, Name: "nginx", CreateTime: now}, processStats{CPUTime: 500 * time.Millisecond})
+	source.stop(20)
+	source.start(processIdentity{PID: 21, Name: "postgres", CreateTime: now}, processStats{CPUTime: 300 * time.Millisecond})
+	if err := os.WriteFile(pidfile, []byte("21"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	mtrcs = poll()
+	assertCounter(mtrcs, "ProcessCPUTimeMs", "nginx", 500)
+	assertCounter(mtrcs, "ProcessStarts", "nginx", 1)
+	assertGauge(mtrcs, "ProcessUptimeSeconds", "nginx", 60)
+	assertCounter(mtrcs, "ProcessCPUTimeMs", "db", 300)
+	assertCounter(mtrcs, "ProcessStarts", "db", 1)
+
+	// Процесс не запущен
+	if err := os.Remove(pidfile); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	mtrcs = poll()
+	assertGauge(mtrcs, "ProcessCount", "db", 0)
+	assertGauge(mtrcs, "ProcessUptimeSeconds", "db", 0)
+}
+
+func TestNewProcessPoller(t *testing.T) {
+	for name, matchers := range map[string][]ProcessMatcher{
+		"no matchers":    nil,
+		"no name":        {{ProcessName: "nginx"}},
+		"no criteria":    {{Name: "nginx"}},
+		"two criteria":   {{Name: "nginx", ProcessName: "nginx", Pidfile: "/run/nginx.pid"}},
+		"bad regexp":     {{Name: "app", Cmdline: "("}},
+		"duplicate name": {{Name: "nginx", ProcessName: "nginx"}, {Name: "nginx", Pidfile: "/run/nginx.pid"}},
+	} {
+		if _, err := NewProcessPoller(ProcessOptions{Processes: matchers}); err == nil {
+			t.Errorf("NewProcessPoller() %s expected error", name)
+		}
+	}
+}
+
+// copyFixture копирует каталог testdata/name во временный каталог, чтобы тест мог менять файлы
+func copyFixture(t *testing.T, name string) string {
+	dir := t.TempDir()
+	entries, err := os.ReadDir(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("ReadDir() error = %v", err)
+	}
+	files := make(map[string]string, len(entries))
+	for _, e := range entries {
+		raw, err := os.ReadFile(filepath.Join("testdata", name, e.Name()))
+		if err != nil {
+			t.Fatalf("ReadFile() error = %v", err)
+		}
+		files[e.Name()] = string(raw)
+	}
+	writeFiles(t, dir, files)
+
+	return dir
+}
+
+func TestCgroupPoller_Poll(t *testing.T) {
+	dir := copyFixture(t, "cgroup")
+	poller, err := NewCgroupPoller(CgroupOptions{Path: dir})
+	if err != nil {
+		t.Fatalf("NewCgroupPoller() error = %v", err)
+	}
+
+	mtrcs, err := poller.Poll()
+	if err != nil {
+		t.Fatalf("Poll() error = %v", err)
+	}
+	for name, want := range map[string]metrics.Gauge{
+		"CgroupMemoryUsage":       268435456,
+		"CgroupMemoryLimit":       536870912,
+		"CgroupMemoryUsedPercent": 50,
+		"CgroupPids":              12,
+	} {
+		if m, ok := findMetric(mtrcs, name, nil); !ok || *m.Value != want {
+			t.Errorf("%s = %v, %v; want %v", name, m, ok, want)
+		}
+	}
+	if _, ok := findMetric(mtrcs, "CgroupPidsLimit", nil); ok {
+		t.Errorf("CgroupPidsLimit reported for unlimited pids")
+	}
+	if m, ok := findMetric(mtrcs, "CgroupCPUThrottledPeriods", nil); !ok || *m.Delta != 0 {
+		t.Errorf("CgroupCPUThrottledPeriods first poll = %v, %v; want 0", m, ok)
+	}
+
+	writeFiles(t, dir, map[string]string{
+		"cpu.stat":   "usage_usec 2500000\nuser_usec 1600000\nsystem_usec 900000\nnr_periods 110\nnr_throttled 10\nthrottled_usec 500000\n",
+		"io.stat":    "8:0 rbytes=5120 wbytes=8192 rios=2 wios=2 dbytes=0 dios=0\n",
+		"memory.max": "max\n",
+	})
+	mtrcs, err = poller.Poll()
+	if err != nil {
+		t.Fatalf("Poll() error = %v", err)
+	}
+	for name, want := range map[string]metrics.Counter{
+		"CgroupCPUUsageUsec":        1000000,
+		"CgroupCPUPeriods":          10,
+		"CgroupCPUThrottledPeriods": 3,
+		"CgroupCPUThrottledUsec":    150000,
+	} {
+		if m, ok := findMetric(mtrcs, name, nil); !ok || *m.Delta != want {
+			t.Errorf("%s = %v, %v; want delta %v", name, m, ok, want)
+		}
+	}
+	if m, ok := findMetric(mtrcs, "CgroupIOReadBytes", metrics.Labels{"device": "8:0"}); !ok || *m.Delta != 1024 {
+		t.Errorf("CgroupIOReadBytes{8:0} = %v, %v; want delta 1024", m, ok)
+	}
+	for _, name := range []string{"CgroupMemoryLimit", "CgroupMemoryUsedPercent"} {
+		if _, ok := findMetric(mtrcs, name, nil); ok {
+			t.Errorf("%s reported for unlimited memory", name)
+		}
+	}
+
+	// Файлы выключенных контроллеров пропускаются
+	for _, name := range []string{"io.stat", "pids.current", "pids.max"} {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			t.Fatalf("Remove() error = %v", err)
+		}
+	}
+	if _, err := poller.Poll(); err != nil {
+		t.Errorf("Poll() without io and pids controllers error = %v", err)
+	}
+
+	if _, err := NewCgroupPoller(CgroupOptions{Path: t.TempDir()}); err == nil {
+		t.Errorf("NewCgroupPoller() not cgroup directory expected error")
+	}
+}
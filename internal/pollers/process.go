@@ -0,0 +1,334 @@
+package pollers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/process"
+
+	"github.com/vleukhin/prom-light/internal/metrics"
+)
+
+func init() {
+	Register("process", func(options json.RawMessage) (Poller, error) {
+		opts := ProcessOptions{}
+		if err := decodeOptions(options, &opts); err != nil {
+			return nil, err
+		}
+		return NewProcessPoller(opts)
+	})
+}
+
+// ProcessOptions настройки сборщика метрик процессов
+type ProcessOptions struct {
+	Processes []ProcessMatcher `json:"processes"`
+}
+
+// ProcessMatcher выбирает процессы одним из способов: по имени процесса, регулярному выражению
+// для командной строки или pid-файлу. Name - значение метки process у метрик выбранных процессов
+type ProcessMatcher struct {
+	Name        string `json:"name"`
+	ProcessName string `json:"process_name"`
+	Cmdline     string `json:"cmdline"`
+	Pidfile     string `json:"pidfile"`
+}
+
+// processIdentity описывает процесс для выбора. Процесс определяется парой pid и времени запуска,
+// потому что pid может достаться новому процессу
+type processIdentity struct {
+	PID        int32
+	CreateTime time.Time
+	Name       string
+	Cmdline    string
+}
+
+// processStats ресурсы, потребляемые процессом
+type processStats struct {
+	CPUTime time.Duration
+	RSS     uint64
+	FDs     int32
+	Threads int32
+}
+
+// processSource источник сведений о процессах системы
+type processSource interface {
+	pids() ([]int32, error)
+	identity(pid int32) (processIdentity, error)
+	stats(pid int32) (processStats, error)
+}
+
+// processKey ключ процесса, отличающий перезапущенный процесс с тем же pid
+type processKey struct {
+	pid        int32
+	createTime int64
+}
+
+// processMatcher подготовленный ProcessMatcher
+type processMatcher struct {
+	ProcessMatcher
+	cmdline *regexp.Regexp
+}
+
+// ProcessPoller собирает метрики выбранных процессов. Метрики процессов одного
+// ProcessMatcher складываются и выдаются с меткой process
+type ProcessPoller struct {
+	mutex    sync.Mutex
+	matchers []processMatcher
+	source   processSource
+	now      func() time.Time
+	// cpu время процессора, учтенное для процесса на прошлом опросе, по ключам процессов каждого matcher
+	cpu      []map[processKey]time.Duration
+	baseline bool
+}
+
+// NewProcessPoller создает сборщик метрик процессов
+func NewProcessPoller(opts ProcessOptions) (*ProcessPoller, error) {
+	if len(opts.Processes) == 0 {
+		return nil, errors.New("no processes to match")
+	}
+
+	matchers := make([]processMatcher, 0, len(opts.Processes))
+	names := make(map[string]bool, len(opts.Processes))
+	for _, pm := range opts.Processes {
+		if pm.Name == "" {
+			return nil, errors.New("process matcher without name")
+		}
+		if names[pm.Name] {
+			return nil, fmt.Errorf("process matcher %q configured twice", pm.Name)
+		}
+		names[pm.Name] = true
+
+		set := 0
+		for _, v := range []string{pm.ProcessName, pm.Cmdline, pm.Pidfile} {
+			if v != "" {
+				set++
+			}
+		}
+		if set != 1 {
+			return nil, fmt.Errorf("process matcher %q: exactly one of process_name, cmdline, pidfile is required", pm.Name)
+		}
+
+		m := processMatcher{ProcessMatcher: pm}
+		if pm.Cmdline != "" {
+			re, err := regexp.Compile(pm.Cmdline)
+			if err != nil {
+				return nil, fmt.Errorf("process matcher %q: %w", pm.Name, err)
+			}
+			m.cmdline = re
+		}
+		matchers = append(matchers, m)
+	}
+
+	cpu := make([]map[processKey]time.Duration, len(matchers))
+	for i := range cpu {
+		cpu[i] = make(map[processKey]time.Duration)
+	}
+
+	return &ProcessPoller{
+		matchers: matchers,
+		source:   psProcessSource{},
+		now:      time.Now,
+		cpu:      cpu,
+	}, nil
+}
+
+// Poll выдает для каждого matcher количество процессов, суммарные RSS, открытые файлы и потоки,
+// время работы самого старого процесса и приросты времени процессора и числа запусков.
+// Прирост времени процессора считается по каждому процессу отдельно, поэтому
+// перезапуск процесса не уменьшает счетчик. Процессы, запущенные после первого опроса,
+// учитываются целиком, а уже работавшие на первом опросе служат точкой отсчета
+func (p *ProcessPoller) Poll() (metrics.Metrics, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	var all []processIdentity
+	if p.needsScan() {
+		pids, err := p.source.pids()
+		if err != nil {
+			return nil, err
+		}
+		all = make([]processIdentity, 0, len(pids))
+		for _, pid := range pids {
+			// Процесс мог завершиться после получения списка
+			if id, err := p.source.identity(pid); err == nil {
+				all = append(all, id)
+			}
+		}
+	}
+
+	now := p.now()
+	mtrcs := make(metrics.Metrics, 0, len(p.matchers)*7)
+	// Точки отсчета меняются только после опроса всех matcher, чтобы ошибка одного из них
+	// не потеряла приросты, уже посчитанные для остальных
+	next := make([]map[processKey]time.Duration, len(p.matchers))
+	for i, m := range p.matchers {
+		matched, err := p.match(m, all)
+		if err != nil {
+			return nil, err
+		}
+
+		var (
+			total   processStats
+			cpu     time.Duration
+			count   int
+			starts  int
+			oldest  time.Time
+			current = make(map[processKey]time.Duration, len(matched))
+		)
+		for _, id := range matched {
+			key := processKey{pid: id.PID, createTime: id.CreateTime.UnixNano()}
+			prev, seen := p.cpu[i][key]
+			stats, err := p.source.stats(id.PID)
+			if err != nil {
+				// Процесс остается точкой отсчета, иначе на следующем опросе он сочтется запущенным заново
+				if seen {
+					current[key] = prev
+				}
+				continue
+			}
+			count++
+			switch {
+			case seen:
+				if stats.CPUTime > prev {
+					cpu += stats.CPUTime - prev
+				}
+			case p.baseline:
+				cpu += stats.CPUTime
+				starts++
+			}
+			current[key] = stats.CPUTime
+
+			total.RSS += stats.RSS
+			total.FDs += stats.FDs
+			total.Threads += stats.Threads
+			if oldest.IsZero() || id.CreateTime.Before(oldest) {
+				oldest = id.CreateTime
+			}
+		}
+		next[i] = current
+
+		var uptime time.Duration
+		if !oldest.IsZero() {
+			uptime = now.Sub(oldest)
+		}
+		labels := metrics.Labels{"process": m.Name}
+		for _, metric := range []metrics.Metric{
+			metrics.MakeGaugeMetric("ProcessCount", metrics.Gauge(count)),
+			metrics.MakeGaugeMetric("ProcessRSS", metrics.Gauge(total.RSS)),
+			metrics.MakeGaugeMetric("ProcessOpenFDs", metrics.Gauge(total.FDs)),
+			metrics.MakeGaugeMetric("ProcessThreads", metrics.Gauge(total.Threads)),
+			metrics.MakeGaugeMetric("ProcessUptimeSeconds", metrics.Gauge(uptime.Seconds())),
+			metrics.MakeCounterMetric("ProcessCPUTimeMs", metrics.Counter(cpu.Milliseconds())),
+			metrics.MakeCounterMetric("ProcessStarts", metrics.Counter(starts)),
+		} {
+			metric.Labels = labels
+			mtrcs = append(mtrcs, metric)
+		}
+	}
+	p.cpu = next
+	p.baseline = true
+
+	return mtrcs, nil
+}
+
+// needsScan проверяет, нужен ли список всех процессов. Для pid-файлов он не нужен
+func (p *ProcessPoller) needsScan() bool {
+	for _, m := range p.matchers {
+		if m.Pidfile == "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// match выбирает процессы matcher из списка всех процессов или по pid-файлу.
+// Отсутствующий pid-файл или процесс из него означают, что процесс не запущен
+func (p *ProcessPoller) match(m processMatcher, all []processIdentity) ([]processIdentity, error) {
+	if m.Pidfile != "" {
+		raw, err := os.ReadFile(m.Pidfile)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		pid, err := strconv.ParseInt(strings.TrimSpace(string(raw)), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("bad pidfile %s: %w", m.Pidfile, err)
+		}
+		id, err := p.source.identity(int32(pid))
+		if err != nil {
+			return nil, nil
+		}
+		return []processIdentity{id}, nil
+	}
+
+	var matched []processIdentity
+	for _, id := range all {
+		if (m.ProcessName != "" && id.Name == m.ProcessName) || (m.cmdline != nil && m.cmdline.MatchString(id.Cmdline)) {
+			matched = append(matched, id)
+		}
+	}
+
+	return matched, nil
+}
+
+// psProcessSource сведения о процессах из gopsutil
+type psProcessSource struct{}
+
+func (psProcessSource) pids() ([]int32, error) {
+	return process.Pids()
+}
+
+func (psProcessSource) identity(pid int32) (processIdentity, error) {
+	id := processIdentity{PID: pid}
+	proc, err := process.NewProcess(pid)
+	if err != nil {
+		return id, err
+	}
+	created, err := proc.CreateTime()
+	if err != nil {
+		return id, err
+	}
+	id.CreateTime = time.UnixMilli(created)
+	if id.Name, err = proc.Name(); err != nil {
+		return id, err
+	}
+	// Командная строка может быть недоступна, например у процессов ядра
+	id.Cmdline, _ = proc.Cmdline()
+
+	return id, nil
+}
+
+func (psProcessSource) stats(pid int32) (processStats, error) {
+	var stats processStats
+	proc, err := process.NewProcess(pid)
+	if err != nil {
+		return stats, err
+	}
+	times, err := proc.Times()
+	if err != nil {
+		return stats, err
+	}
+	stats.CPUTime = time.Duration((times.User + times.System) * float64(time.Second))
+	mem, err := proc.MemoryInfo()
+	if err != nil {
+		return stats, err
+	}
+	stats.RSS = mem.RSS
+	if stats.Threads, err = proc.NumThreads(); err != nil {
+		return stats, err
+	}
+	// Открытые файлы чужих процессов недоступны без прав, остальные метрики при этом полезны
+	stats.FDs, _ = proc.NumFDs()
+
+	return stats, nil
+}
@@ -0,0 +1,81 @@
+package pollers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/vleukhin/prom-light/internal/metrics"
+)
+
+// Poller сборщик метрик
+type Poller interface {
+	// Poll сбор метрик
+	Poll() (metrics.Metrics, error)
+}
+
+// Factory создает сборщик с настройками options из конфига агента.
+// options пустой, если настройки не заданы
+type Factory func(options json.RawMessage) (Poller, error)
+
+var (
+	registryMutex sync.RWMutex
+	registry      = make(map[string]Factory)
+)
+
+// Register регистрирует сборщик под именем name. Сборщики регистрируются в init,
+// повторная регистрация имени - ошибка программы
+func Register(name string, factory Factory) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	if _, ok := registry[name]; ok {
+		panic("pollers: poller " + name + " registered twice")
+	}
+	registry[name] = factory
+}
+
+// New создает зарегистрированный сборщик name
+func New(name string, options json.RawMessage) (Poller, error) {
+	registryMutex.RLock()
+	factory, ok := registry[name]
+	registryMutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown poller %q, available: %v", name, Names())
+	}
+
+	p, err := factory(options)
+	if err != nil {
+		return nil, fmt.Errorf("poller %q: %w", name, err)
+	}
+
+	return p, nil
+}
+
+// Names выдает имена зарегистрированных сборщиков
+func Names() []string {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// decodeOptions декодирует настройки сборщика. Неизвестные поля - ошибка,
+// чтобы опечатка в конфиге не оставалась незамеченной
+func decodeOptions(options json.RawMessage, v interface{}) error {
+	if len(options) == 0 {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(options))
+	dec.DisallowUnknownFields()
+
+	return dec.Decode(v)
+}
@@ -2,10 +2,16 @@ package server
 
 import (
 	"context"
+	"errors"
+	"hash"
 
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
 
+	"github.com/vleukhin/prom-light/internal/crypt"
 	"github.com/vleukhin/prom-light/internal/metrics"
+	"github.com/vleukhin/prom-light/internal/middlewares"
 	"github.com/vleukhin/prom-light/internal/proto"
 	"github.com/vleukhin/prom-light/internal/storage"
 
@@ -22,20 +28,54 @@ type GRPSServer struct {
 
 type MetricsServer struct {
 	proto.UnimplementedMetricsServer
-	store storage.MetricsStorage
+	store         storage.MetricsStorage
+	hasher        hash.Hash
+	identityLabel string
+	// deleteAllowed разрешает удаление метрик. Удаление доступно только при заданной доверенной подсети
+	deleteAllowed bool
 }
 
-func newMetricsServer(store storage.MetricsStorage) proto.MetricsServer {
+func newMetricsServer(store storage.MetricsStorage, hasher hash.Hash, identityLabel string, deleteAllowed bool) proto.MetricsServer {
 	return &MetricsServer{
-		store: store,
+		store:         store,
+		hasher:        hasher,
+		identityLabel: identityLabel,
+		deleteAllowed: deleteAllowed,
 	}
 }
 
+// identityLabels выдает метку с идентификатором клиента из его сертификата
+func (s MetricsServer) identityLabels(ctx context.Context) metrics.Labels {
+	if s.identityLabel == "" {
+		return nil
+	}
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return nil
+	}
+	identity := crypt.PeerIdentity(&info.State)
+	if identity == "" {
+		return nil
+	}
+
+	return metrics.Labels{s.identityLabel: identity}
+}
+
 func (s MetricsServer) UpdateMetric(ctx context.Context, request *proto.UpdateMetricRequest) (*proto.UpdateMetricResponse, error) {
 	m, err := metrics.FromProto(request.Metric)
 	if err != nil {
 		return nil, status.Errorf(codes.InvalidArgument, "unknown metric type '%s'", request.Metric.Type)
 	}
+	if !m.IsValid(s.hasher) {
+		return nil, status.Error(codes.InvalidArgument, "invalid hash")
+	}
+	if identity := s.identityLabels(ctx); identity != nil {
+		m.Labels = m.Labels.Merge(identity)
+	}
 
 	err = s.store.SetMetric(ctx, m)
 	if err != nil {
@@ -46,7 +86,7 @@ func (s MetricsServer) UpdateMetric(ctx context.Context, request *proto.UpdateMe
 }
 
 func (s MetricsServer) UpdateMetricsBatch(ctx context.Context, request *proto.UpdateMetricsBatchRequest) (*proto.UpdateMetricsBatchResponse, error) {
-	mtrcs := make(metrics.Metrics, len(request.Metrics))
+	mtrcs := make(metrics.Metrics, 0, len(request.Metrics))
 	for _, i := range request.Metrics {
 		m, err := metrics.FromProto(i)
 		if err != nil {
@@ -54,6 +94,14 @@ func (s MetricsServer) UpdateMetricsBatch(ctx context.Context, request *proto.Up
 		}
 		mtrcs = append(mtrcs, m)
 	}
+	if !mtrcs.IsValid(s.hasher) {
+		return nil, status.Error(codes.InvalidArgument, "invalid hash")
+	}
+	if identity := s.identityLabels(ctx); identity != nil {
+		for i := range mtrcs {
+			mtrcs[i].Labels = mtrcs[i].Labels.Merge(identity)
+		}
+	}
 	err := s.store.SetMetrics(ctx, mtrcs)
 	if err != nil {
 		return nil, status.Error(codes.Internal, "failed to update metrics")
@@ -65,26 +113,71 @@ func (s MetricsServer) GetMetric(ctx context.Context, request *proto.GetMetricRe
 	var m metrics.Metric
 	switch request.Type {
 	case proto.MetricType_GAUGE:
-		v, err := s.store.GetGauge(ctx, request.Name)
+		v, err := s.store.GetGauge(ctx, request.Name, request.Labels)
 		if err != nil {
 			return nil, status.Error(codes.Internal, "failed to get gauge")
 		}
 		m = metrics.MakeGaugeMetric(request.Name, v)
 	case proto.MetricType_COUNTER:
-		v, err := s.store.GetCounter(ctx, request.Name)
+		v, err := s.store.GetCounter(ctx, request.Name, request.Labels)
 		if err != nil {
 			return nil, status.Error(codes.Internal, "failed to get counter")
 		}
 		m = metrics.MakeCounterMetric(request.Name, v)
+	case proto.MetricType_HISTOGRAM:
+		v, err := s.store.GetHistogram(ctx, request.Name, request.Labels)
+		if err != nil {
+			return nil, status.Error(codes.Internal, "failed to get histogram")
+		}
+		m = metrics.MakeHistogramMetric(request.Name, v)
+	case proto.MetricType_SUMMARY:
+		v, err := s.store.GetSummary(ctx, request.Name, request.Labels)
+		if err != nil {
+			return nil, status.Error(codes.Internal, "failed to get summary")
+		}
+		m = metrics.MakeSummaryMetric(request.Name, v)
 	default:
 		return nil, status.Errorf(codes.InvalidArgument, "unknown metric type '%s'", request.Type)
 	}
 
+	m.Labels = metrics.Labels(request.Labels).Merge(nil)
+
 	return &proto.GetMetricResponse{
 		Metric: metrics.ToProto(m),
 	}, nil
 }
 
+// DeleteMetrics удаляет серии, подходящие под фильтр, вместе с историей
+func (s MetricsServer) DeleteMetrics(ctx context.Context, request *proto.DeleteMetricsRequest) (*proto.DeleteMetricsResponse, error) {
+	if !s.deleteAllowed {
+		return nil, status.Error(codes.PermissionDenied, "deletion requires a trusted subnet")
+	}
+
+	m := storage.Matcher{
+		Name:       request.Name,
+		NamePrefix: request.NamePrefix,
+		NameRegex:  request.NameRegex,
+		Labels:     request.Labels,
+	}
+	for _, t := range request.Types {
+		metricType, err := metrics.TypeFromProto(t)
+		if err != nil {
+			return nil, err
+		}
+		m.Types = append(m.Types, metricType)
+	}
+
+	deleted, err := s.store.DeleteByMatcher(ctx, m)
+	if err != nil {
+		if errors.Is(err, storage.ErrInvalidQuery) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		return nil, status.Error(codes.Internal, "failed to delete metrics")
+	}
+
+	return &proto.DeleteMetricsResponse{Deleted: int64(deleted)}, nil
+}
+
 func (s GRPSServer) ListenAndServe() error {
 	listener, err := net.Listen("tcp", s.addr)
 	if err != nil {
@@ -99,9 +192,30 @@ func (s GRPSServer) Shutdown(_ context.Context) error {
 	return nil
 }
 
-func NewGRPCServer(addr string, store storage.MetricsStorage) GRPSServer {
-	server := grpc.NewServer()
-	proto.RegisterMetricsServer(server, newMetricsServer(store))
+// NewGRPCServer создает gRPC сервер метрик. Если задана доверенная подсеть, запросы из других
+// адресов отклоняются, без нее отклоняется удаление метрик. creds задает TLS, nil означает соединение без шифрования
+func NewGRPCServer(
+	addr string,
+	store storage.MetricsStorage,
+	hasher hash.Hash,
+	trustedSubnet net.IPNet,
+	creds credentials.TransportCredentials,
+	identityLabel string,
+) GRPSServer {
+	var opts []grpc.ServerOption
+	if creds != nil {
+		opts = append(opts, grpc.Creds(creds))
+	}
+	if trustedSubnet.IP != nil {
+		trusted := middlewares.NewTrustedIPsMiddleware(trustedSubnet)
+		opts = append(opts,
+			grpc.ChainUnaryInterceptor(trusted.UnaryInterceptor),
+			grpc.ChainStreamInterceptor(trusted.StreamInterceptor),
+		)
+	}
+
+	server := grpc.NewServer(opts...)
+	proto.RegisterMetricsServer(server, newMetricsServer(store, hasher, identityLabel, trustedSubnet.IP != nil))
 
 	return GRPSServer{
 		addr:   addr,
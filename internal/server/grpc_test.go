@@ -0,0 +1,192 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/vleukhin/prom-light/internal/agent"
+	"github.com/vleukhin/prom-light/internal/config"
+	"github.com/vleukhin/prom-light/internal/metrics"
+	"github.com/vleukhin/prom-light/internal/proto"
+	"github.com/vleukhin/prom-light/internal/storage"
+)
+
+// startGRPCServer запускает gRPC сервер на свободном порту и выдает его адрес
+func startGRPCServer(t *testing.T, s GRPSServer) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		_ = s.server.Serve(listener)
+	}()
+	t.Cleanup(s.server.Stop)
+
+	return listener.Addr().String()
+}
+
+func TestGRPCServer_Hash(t *testing.T) {
+	ctx := context.Background()
+	mockStorage := storage.NewMockStorage()
+	key := []byte("secret")
+	addr := startGRPCServer(t, NewGRPCServer("", mockStorage, hmac.New(sha256.New, key), net.IPNet{}, nil, ""))
+
+	client, err := agent.NewGRPCClient(addr, nil, nil, agent.RetryPolicy{})
+	require.NoError(t, err)
+	defer client.ShutDown()
+
+	signed := metrics.Metrics{metrics.MakeGaugeMetric("Alloc", 5.5), metrics.MakeCounterMetric("PollCount", 2)}.
+		Sign(hmac.New(sha256.New, key))
+	require.NoError(t, client.SendBatchMetricsToServer(ctx, signed))
+	require.NoError(t, client.SendMetricToServer(ctx, signed[1]))
+	mockStorage.AssertGaugeStoredWithValue(t, "Alloc", 5.5)
+	mockStorage.AssertCounterStoredWithValue(t, "PollCount", 4)
+
+	forged := metrics.Metrics{metrics.MakeGaugeMetric("Alloc", 1)}.Sign(hmac.New(sha256.New, []byte("other")))
+	err = client.SendMetricToServer(ctx, forged[0])
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	err = client.SendBatchMetricsToServer(ctx, append(signed, forged...))
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	mockStorage.AssertGaugeStoredWithValue(t, "Alloc", 5.5)
+}
+
+func TestGRPCServer_TrustedSubnet(t *testing.T) {
+	ctx := context.Background()
+	_, loopback, err := net.ParseCIDR("127.0.0.0/8")
+	require.NoError(t, err)
+	_, private, err := net.ParseCIDR("10.0.0.0/8")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		subnet net.IPNet
+		ip     net.IP
+		want   codes.Code
+	}{
+		{name: "Trusted peer address", subnet: *loopback, ip: nil, want: codes.OK},
+		{name: "Trusted address from metadata of trusted peer", subnet: *loopback, ip: net.IPv4(127, 0, 0, 2), want: codes.OK},
+		{name: "Untrusted address from metadata of trusted peer", subnet: *loopback, ip: net.IPv4(192, 168, 1, 1), want: codes.PermissionDenied},
+		{name: "Untrusted peer address", subnet: *private, ip: nil, want: codes.PermissionDenied},
+		{name: "Trusted address from metadata of untrusted peer", subnet: *private, ip: net.IPv4(10, 1, 2, 3), want: codes.PermissionDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := startGRPCServer(t, NewGRPCServer("", storage.NewMockStorage(), nil, tt.subnet, nil, ""))
+			client, err := agent.NewGRPCClient(addr, tt.ip, nil, agent.RetryPolicy{})
+			require.NoError(t, err)
+			defer client.ShutDown()
+
+			err = client.SendMetricToServer(ctx, metrics.MakeGaugeMetric("Alloc", 1))
+			assert.Equal(t, tt.want, status.Code(err))
+		})
+	}
+}
+
+func TestGRPCServer_TLS(t *testing.T) {
+	ctx := context.Background()
+	ca := newTestCA(t)
+	serverCfg := &tls.Config{Certificates: []tls.Certificate{ca.issue(t, "prom-light", nil, true)}}
+	mockStorage := storage.NewMockStorage()
+	addr := startGRPCServer(t, NewGRPCServer("", mockStorage, nil, net.IPNet{}, credentials.NewTLS(serverCfg), ""))
+
+	client, err := agent.NewGRPCClient(addr, nil, ca.clientConfig(nil), agent.RetryPolicy{})
+	require.NoError(t, err)
+	defer client.ShutDown()
+	require.NoError(t, client.SendMetricToServer(ctx, metrics.MakeGaugeMetric("Alloc", 2)))
+	mockStorage.AssertGaugeStoredWithValue(t, "Alloc", 2)
+
+	// Клиент без TLS не может обратиться к серверу
+	plain, err := agent.NewGRPCClient(addr, nil, nil, agent.RetryPolicy{})
+	require.NoError(t, err)
+	defer plain.ShutDown()
+	assert.Error(t, plain.SendMetricToServer(ctx, metrics.MakeGaugeMetric("Alloc", 3)))
+}
+
+func TestGRPCServer_DeleteMetrics(t *testing.T) {
+	_, subnet, err := net.ParseCIDR("127.0.0.0/8")
+	require.NoError(t, err)
+	_, private, err := net.ParseCIDR("10.0.0.0/8")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		subnet  net.IPNet
+		realIP  string
+		request *proto.DeleteMetricsRequest
+		want    codes.Code
+		deleted int64
+	}{
+		{
+			name:    "Delete by type and prefix",
+			subnet:  *subnet,
+			request: &proto.DeleteMetricsRequest{Types: []proto.MetricType{proto.MetricType_GAUGE}, NamePrefix: "CPU"},
+			want:    codes.OK,
+			deleted: 2,
+		},
+		{
+			name:    "Delete by labels",
+			subnet:  *subnet,
+			request: &proto.DeleteMetricsRequest{Labels: map[string]string{"host": "edge-01"}},
+			want:    codes.OK,
+			deleted: 1,
+		},
+		{
+			name:    "Empty matcher",
+			subnet:  *subnet,
+			request: &proto.DeleteMetricsRequest{},
+			want:    codes.InvalidArgument,
+		},
+		{
+			name:    "Untrusted peer with trusted address in metadata",
+			subnet:  *private,
+			realIP:  "10.0.0.1",
+			request: &proto.DeleteMetricsRequest{Name: "Alloc"},
+			want:    codes.PermissionDenied,
+		},
+		{
+			name:    "Trusted subnet is not configured",
+			request: &proto.DeleteMetricsRequest{Name: "Alloc"},
+			want:    codes.PermissionDenied,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage := storage.NewMockStorage()
+			edge := metrics.MakeGaugeMetric("CPUUtilization1", 10)
+			edge.Labels = metrics.Labels{"host": "edge-01"}
+			require.NoError(t, mockStorage.SetMetrics(context.Background(), metrics.Metrics{
+				metrics.MakeGaugeMetric("CPUUtilization1", 5),
+				edge,
+				metrics.MakeCounterMetric("CPUUtilization1", 1),
+				metrics.MakeGaugeMetric("Alloc", 1),
+			}))
+			addr := startGRPCServer(t, NewGRPCServer("", mockStorage, nil, tt.subnet, nil, ""))
+
+			conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+			require.NoError(t, err)
+			defer conn.Close()
+			ctx := context.Background()
+			if tt.realIP != "" {
+				ctx = metadata.AppendToOutgoingContext(ctx, strings.ToLower(config.XRealIPHeader), tt.realIP)
+			}
+
+			response, err := proto.NewMetricsClient(conn).DeleteMetrics(ctx, tt.request)
+			require.Equal(t, tt.want, status.Code(err))
+			if tt.want == codes.OK {
+				assert.Equal(t, tt.deleted, response.Deleted)
+			}
+		})
+	}
+}
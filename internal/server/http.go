@@ -2,6 +2,7 @@ package server
 
 import (
 	"crypto/rsa"
+	"crypto/tls"
 	"hash"
 	"net"
 	"net/http"
@@ -13,37 +14,69 @@ import (
 	"github.com/vleukhin/prom-light/internal/storage"
 )
 
+// HTTPServer HTTP сервер метрик. Если заданы настройки TLS, сервер принимает только HTTPS
+type HTTPServer struct {
+	*http.Server
+}
+
+// NewHTTPServer создает HTTP сервер метрик. tlsCfg задает TLS, nil означает HTTP без шифрования
 func NewHTTPServer(
 	addr string,
 	str storage.MetricsStorage,
 	hasher hash.Hash,
 	key *rsa.PrivateKey,
 	trustedSubnet net.IPNet,
-) *http.Server {
-	router := NewRouter(str, hasher, key, trustedSubnet)
-	return &http.Server{Addr: addr, Handler: router}
+	tlsCfg *tls.Config,
+	identityLabel string,
+) HTTPServer {
+	router := NewRouter(str, hasher, key, trustedSubnet, identityLabel)
+	return HTTPServer{&http.Server{Addr: addr, Handler: router, TLSConfig: tlsCfg}}
+}
+
+// ListenAndServe начинает прием запросов по HTTP или HTTPS
+func (s HTTPServer) ListenAndServe() error {
+	if s.TLSConfig != nil {
+		// Сертификат уже загружен в TLSConfig
+		return s.Server.ListenAndServeTLS("", "")
+	}
+
+	return s.Server.ListenAndServe()
 }
 
 // NewRouter создает новый роутер
-func NewRouter(str storage.MetricsStorage, hasher hash.Hash, key *rsa.PrivateKey, trustedSubnet net.IPNet) *mux.Router {
+func NewRouter(str storage.MetricsStorage, hasher hash.Hash, key *rsa.PrivateKey, trustedSubnet net.IPNet, identityLabel string) *mux.Router {
 	homeHandler := httpHandlers.NewHomeHandler(str)
-	metricsController := httpHandlers.NewMetricsController(str, hasher)
+	metricsController := httpHandlers.NewMetricsController(str, hasher, identityLabel)
+	prometheusController := httpHandlers.NewPrometheusController(str)
+
+	remoteWriteController := httpHandlers.NewRemoteWriteController(str)
 
 	r := mux.NewRouter()
 	r.Use(middlewares.GZIPEncode)
-	r.Use(middlewares.NewDecryptMiddleware(key).Handle)
 	if trustedSubnet.IP != nil {
 		r.Use(middlewares.NewTrustedIPsMiddleware(trustedSubnet).Handle)
 	}
-	r.Handle("/", http.HandlerFunc(homeHandler.Home)).Methods(http.MethodGet, http.MethodHead)
-	r.Handle("/update/", http.HandlerFunc(metricsController.UpdateMetricJSON)).Methods(http.MethodPost)
-	r.Handle("/updates/", http.HandlerFunc(metricsController.UpdateMetricsBatch)).Methods(http.MethodPost)
-	r.Handle("/update/{type}/{name}/{value}", http.HandlerFunc(metricsController.UpdateMetric)).Methods(http.MethodPost)
-	r.Handle("/value/", http.HandlerFunc(metricsController.GetMetricJSON)).Methods(http.MethodPost)
-	r.Handle("/value/{type}/{name}", http.HandlerFunc(metricsController.GetMetric)).Methods(http.MethodGet, http.MethodHead)
-	r.Handle("/ping", pingHandler(str)).Methods(http.MethodGet, http.MethodHead)
-
+	// Prometheus не шифрует тело запроса, поэтому remote write регистрируется вне роутера с расшифровкой
+	r.Handle("/api/v1/write", http.HandlerFunc(remoteWriteController.Write)).Methods(http.MethodPost)
 	r.PathPrefix("/debug/pprof/").Handler(http.DefaultServeMux)
 
+	api := r.NewRoute().Subrouter()
+	api.Use(middlewares.NewDecryptMiddleware(key).Handle)
+	api.Handle("/", http.HandlerFunc(homeHandler.Home)).Methods(http.MethodGet, http.MethodHead)
+	api.Handle("/update/", http.HandlerFunc(metricsController.UpdateMetricJSON)).Methods(http.MethodPost)
+	api.Handle("/write", http.HandlerFunc(metricsController.WriteLineProtocol)).Methods(http.MethodPost)
+	api.Handle("/updates/", http.HandlerFunc(metricsController.UpdateMetricsBatch)).Methods(http.MethodPost)
+	api.Handle("/update/{type}/{name}/{value}", http.HandlerFunc(metricsController.UpdateMetric)).Methods(http.MethodPost)
+	api.Handle("/value/", http.HandlerFunc(metricsController.GetMetricJSON)).Methods(http.MethodPost)
+	api.Handle("/value/{type}/{name}", http.HandlerFunc(metricsController.GetMetric)).Methods(http.MethodGet, http.MethodHead)
+	// Удаление доступно только из доверенной подсети, без нее запросы на удаление отклоняются.
+	// Доверенным должен быть адрес соединения, а не только присланный клиентом X-Real-IP
+	api.Handle("/value/{type}/{name}", middlewares.NewTrustedIPsMiddleware(trustedSubnet).HandlePeer(http.HandlerFunc(metricsController.DeleteMetric))).
+		Methods(http.MethodDelete)
+	api.Handle("/api/v1/metrics", http.HandlerFunc(metricsController.ListMetrics)).Methods(http.MethodGet, http.MethodHead)
+	api.Handle("/history/{name}", http.HandlerFunc(metricsController.GetMetricHistory)).Methods(http.MethodGet, http.MethodHead)
+	api.Handle("/metrics", http.HandlerFunc(prometheusController.Metrics)).Methods(http.MethodGet, http.MethodHead)
+	api.Handle("/ping", pingHandler(str)).Methods(http.MethodGet, http.MethodHead)
+
 	return r
 }
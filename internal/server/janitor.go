@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/vleukhin/prom-light/internal/config"
+	"github.com/vleukhin/prom-light/internal/metrics"
+	"github.com/vleukhin/prom-light/internal/storage"
+)
+
+// Janitor периодически удаляет из хранилища серии, которые давно не обновлялись
+type Janitor struct {
+	store    storage.MetricsExpirer
+	policy   storage.StalenessPolicy
+	interval time.Duration
+	done     chan struct{}
+	once     sync.Once
+}
+
+// NewJanitor создает Janitor, который проверяет серии каждые interval
+func NewJanitor(store storage.MetricsExpirer, policy storage.StalenessPolicy, interval time.Duration) *Janitor {
+	return &Janitor{
+		store:    store,
+		policy:   policy,
+		interval: interval,
+		done:     make(chan struct{}),
+	}
+}
+
+// Run удаляет устаревшие серии до вызова Shutdown
+func (j *Janitor) Run() {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-j.done:
+			return
+		case now := <-ticker.C:
+			j.sweep(context.Background(), now)
+		}
+	}
+}
+
+// Shutdown останавливает удаление устаревших серий
+func (j *Janitor) Shutdown(_ context.Context) error {
+	j.once.Do(func() {
+		close(j.done)
+	})
+	return nil
+}
+
+func (j *Janitor) sweep(ctx context.Context, now time.Time) {
+	deleted, err := j.store.DeleteStale(ctx, j.policy, now)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to delete stale series")
+		return
+	}
+	if deleted > 0 {
+		log.Info().Msgf("Deleted %d stale series", deleted)
+	}
+}
+
+// stalenessPolicy строит политику устаревания серий из конфига.
+// Время жизни задается в интервалах проверки
+func stalenessPolicy(cfg *config.ServerConfig) (storage.StalenessPolicy, error) {
+	interval := cfg.StaleInterval.Duration
+	policy := storage.StalenessPolicy{TTL: time.Duration(cfg.StaleIntervals) * interval}
+	if cfg.StaleIntervals < 0 {
+		return policy, errors.New("stale intervals must not be negative")
+	}
+	for _, o := range cfg.StaleOverrides {
+		switch o.Type {
+		case "", metrics.GaugeTypeName, metrics.CounterTypeName, metrics.HistogramTypeName, metrics.SummaryTypeName:
+		default:
+			return policy, errors.New("unknown metric type in stale override: " + o.Type)
+		}
+		if o.Type == "" && o.Prefix == "" {
+			return policy, errors.New("stale override must have type or prefix")
+		}
+		if o.Intervals < 0 {
+			return policy, errors.New("stale override intervals must not be negative")
+		}
+		policy.Overrides = append(policy.Overrides, storage.StalenessOverride{
+			Type:   o.Type,
+			Prefix: o.Prefix,
+			TTL:    time.Duration(o.Intervals) * interval,
+		})
+	}
+
+	return policy, nil
+}
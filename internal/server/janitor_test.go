@@ -0,0 +1,60 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vleukhin/prom-light/internal/config"
+	"github.com/vleukhin/prom-light/internal/metrics"
+	"github.com/vleukhin/prom-light/internal/storage"
+)
+
+func TestJanitor_Run(t *testing.T) {
+	ctx := context.Background()
+	mockStorage := storage.NewMockStorage()
+	require.NoError(t, mockStorage.SetMetrics(ctx, metrics.Metrics{
+		metrics.MakeGaugeMetric("Alloc", 1),
+		metrics.MakeCounterMetric("PollCount", 1),
+	}))
+
+	policy, err := stalenessPolicy(&config.ServerConfig{
+		StaleInterval:  config.Duration{Duration: 10 * time.Millisecond},
+		StaleIntervals: 2,
+		StaleOverrides: []config.StaleOverride{{Type: metrics.CounterTypeName, Intervals: 0}},
+	})
+	require.NoError(t, err)
+	janitor := NewJanitor(mockStorage, policy, 10*time.Millisecond)
+	go janitor.Run()
+	defer janitor.Shutdown(ctx)
+
+	assert.Eventually(t, func() bool {
+		_, err := mockStorage.GetGauge(ctx, "Alloc", nil)
+		return err != nil
+	}, time.Second, 10*time.Millisecond)
+	_, err = mockStorage.GetCounter(ctx, "PollCount", nil)
+	assert.NoError(t, err)
+}
+
+func TestStalenessPolicy(t *testing.T) {
+	policy, err := stalenessPolicy(&config.ServerConfig{
+		StaleInterval:  config.Duration{Duration: time.Minute},
+		StaleIntervals: 3,
+		StaleOverrides: []config.StaleOverride{{Prefix: "CPU", Intervals: 10}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 3*time.Minute, policy.TTLFor(metrics.GaugeTypeName, "Alloc"))
+	assert.Equal(t, 10*time.Minute, policy.TTLFor(metrics.GaugeTypeName, "CPUUtilization1"))
+
+	for _, overrides := range [][]config.StaleOverride{
+		{{Intervals: 1}},
+		{{Type: "timer", Intervals: 1}},
+		{{Prefix: "CPU", Intervals: -1}},
+	} {
+		_, err := stalenessPolicy(&config.ServerConfig{StaleInterval: config.Duration{Duration: time.Minute}, StaleOverrides: overrides})
+		assert.Error(t, err)
+	}
+}
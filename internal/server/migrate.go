@@ -0,0 +1,63 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/vleukhin/prom-light/internal/config"
+	"github.com/vleukhin/prom-light/internal/storage"
+)
+
+// Команды подкоманды migrate
+const (
+	MigrateUp     = "up"
+	MigrateDown   = "down"
+	MigrateStatus = "status"
+)
+
+// Migrate применяет миграции схемы PostgreSQL без запуска сервера.
+// command - MigrateUp, MigrateDown или MigrateStatus, steps - количество откатываемых миграций для MigrateDown
+func Migrate(ctx context.Context, cfg *config.ServerConfig, command string, steps int) error {
+	if cfg.DSN == "" {
+		return errors.New("migrations require database DSN")
+	}
+	if command == MigrateDown && steps < 1 {
+		return errors.New("number of migrations to revert must be positive")
+	}
+
+	str, err := storage.NewPostgresStorage(cfg.DSN, cfg.DBConnTimeout.Duration, cfg.HistoryRetention.Duration)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = str.ShutDown(ctx)
+	}()
+
+	switch command {
+	case MigrateUp:
+		applied, err := str.MigrateUp(ctx)
+		if err != nil {
+			return err
+		}
+		log.Info().Msgf("Applied %d migrations", len(applied))
+	case MigrateDown:
+		reverted, err := str.MigrateDown(ctx, steps)
+		if err != nil {
+			return err
+		}
+		log.Info().Msgf("Reverted %d migrations", len(reverted))
+	case MigrateStatus:
+		current, latest, err := str.SchemaVersion(ctx)
+		if err != nil {
+			return err
+		}
+		log.Info().Msgf("Schema version %d, latest migration %d", current, latest)
+	default:
+		return fmt.Errorf("unknown migrate command: %s", command)
+	}
+
+	return nil
+}
@@ -8,11 +8,14 @@ import (
 	"net/http"
 
 	"github.com/pkg/errors"
+	"google.golang.org/grpc/credentials"
 
 	"github.com/rs/zerolog/log"
 
 	"github.com/vleukhin/prom-light/internal/config"
 	"github.com/vleukhin/prom-light/internal/crypt"
+	"github.com/vleukhin/prom-light/internal/graphite"
+	"github.com/vleukhin/prom-light/internal/statsd"
 	"github.com/vleukhin/prom-light/internal/storage"
 )
 
@@ -23,9 +26,12 @@ type Server interface {
 
 // App описывает сервер сбора метрик
 type App struct {
-	cfg    *config.ServerConfig
-	str    storage.MetricsStorage
-	server Server
+	cfg      *config.ServerConfig
+	str      storage.MetricsStorage
+	server   Server
+	statsd   *statsd.Server
+	graphite *graphite.Server
+	janitor  *Janitor
 }
 
 // NewApp создает новый сервер сбора метрик
@@ -46,6 +52,28 @@ func NewApp(cfg *config.ServerConfig) (*App, error) {
 		server: server,
 	}
 
+	if cfg.StatsdAddr != "" {
+		app.statsd = statsd.NewServer(cfg.StatsdAddr, cfg.StatsdTCPAddr, cfg.StatsdFlushInterval.Duration, str)
+	}
+
+	if cfg.GraphiteAddr != "" {
+		mapper, err := graphite.NewMapper(cfg.GraphiteMappings)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create graphite mapper")
+		}
+		app.graphite = graphite.NewServer(cfg.GraphiteAddr, mapper, str)
+	}
+
+	if cfg.StaleInterval.Duration > 0 {
+		policy, err := stalenessPolicy(cfg)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to configure stale series expiry")
+		}
+		if policy.IsEnabled() {
+			app.janitor = NewJanitor(str, policy, cfg.StaleInterval.Duration)
+		}
+	}
+
 	err = str.Migrate(context.Background())
 	if err != nil {
 		return nil, err
@@ -66,11 +94,22 @@ func newServer(cfg *config.ServerConfig, str storage.MetricsStorage) (Server, er
 		return nil, err
 	}
 
+	tlsCfg, err := serverTLSConfig(cfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to configure TLS")
+	}
+
 	switch cfg.Protocol {
 	case config.ProtocolHTTP:
-		server = NewHTTPServer(cfg.Addr, str, hasher, privateKey, cfg.TrustedSubnet)
+		server = NewHTTPServer(cfg.Addr, str, hasher, privateKey, cfg.TrustedSubnet, tlsCfg, cfg.TLSIdentityLabel)
 	case config.ProtocolGRPC:
-		server = NewGRPCServer(cfg.Addr, str)
+		var creds credentials.TransportCredentials
+		if tlsCfg != nil {
+			creds = credentials.NewTLS(tlsCfg)
+		} else if privateKey != nil {
+			log.Warn().Msg("gRPC transport does not use crypto key, configure TLS to encrypt traffic")
+		}
+		server = NewGRPCServer(cfg.Addr, str, hasher, cfg.TrustedSubnet, creds, cfg.TLSIdentityLabel)
 	default:
 		return nil, errors.New("unknown protocol: " + cfg.Protocol)
 	}
@@ -84,17 +123,25 @@ func newStorage(cfg *config.ServerConfig) (storage.MetricsStorage, error) {
 
 	switch true {
 	case cfg.DSN != "":
-		str, err = storage.NewPostgresStorage(cfg.DSN, cfg.DBConnTimeout.Duration)
+		str, err = storage.NewPostgresStorage(cfg.DSN, cfg.DBConnTimeout.Duration, cfg.HistoryRetention.Duration)
+		if err != nil {
+			return nil, err
+		}
+	case cfg.StoreFile != "" && cfg.StoreWAL && cfg.StoreInterval.Duration == 0:
+		str, err = storage.NewWALFileStorage(cfg.StoreFile, cfg.WALCheckpoint.Duration, cfg.Restore, cfg.HistoryRetention.Duration)
 		if err != nil {
 			return nil, err
 		}
 	case cfg.StoreFile != "":
-		str, err = storage.NewFileStorage(cfg.StoreFile, cfg.StoreInterval.Duration, cfg.Restore)
+		if cfg.StoreWAL {
+			log.Warn().Msg("Write-ahead log is used only in sync mode, ignoring it")
+		}
+		str, err = storage.NewFileStorage(cfg.StoreFile, cfg.StoreInterval.Duration, cfg.Restore, cfg.HistoryRetention.Duration)
 		if err != nil {
 			return nil, err
 		}
 	default:
-		str = storage.NewMemoryStorage()
+		str = storage.NewMemoryStorage(cfg.HistoryRetention.Duration)
 	}
 
 	return str, err
@@ -102,6 +149,22 @@ func newStorage(cfg *config.ServerConfig) (storage.MetricsStorage, error) {
 
 // Run запускает сервер сбора метрик
 func (s *App) Run(err chan<- error) {
+	if s.statsd != nil {
+		log.Info().Msgf("StatsD server listen at: %s", s.cfg.StatsdAddr)
+		go func() {
+			err <- s.statsd.ListenAndServe()
+		}()
+	}
+	if s.graphite != nil {
+		log.Info().Msgf("Graphite server listen at: %s", s.cfg.GraphiteAddr)
+		go func() {
+			err <- s.graphite.ListenAndServe()
+		}()
+	}
+	if s.janitor != nil {
+		log.Info().Msgf("Stale series are checked every %s", s.cfg.StaleInterval.Duration)
+		go s.janitor.Run()
+	}
 	log.Info().Msgf("Metrics %s server listen at: %s", s.cfg.Protocol, s.cfg.Addr)
 	err <- s.server.ListenAndServe()
 }
@@ -112,6 +175,24 @@ func (s *App) Stop(ctx context.Context) error {
 		return err
 	}
 
+	if s.statsd != nil {
+		if err := s.statsd.Shutdown(ctx); err != nil {
+			return err
+		}
+	}
+
+	if s.graphite != nil {
+		if err := s.graphite.Shutdown(ctx); err != nil {
+			return err
+		}
+	}
+
+	if s.janitor != nil {
+		if err := s.janitor.Shutdown(ctx); err != nil {
+			return err
+		}
+	}
+
 	return s.str.ShutDown(ctx)
 }
 
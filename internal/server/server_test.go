@@ -3,15 +3,28 @@ package server
 import (
 	"bytes"
 	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"io"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/vleukhin/prom-light/internal/agent"
 	"github.com/vleukhin/prom-light/internal/config"
+	"github.com/vleukhin/prom-light/internal/crypt"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/vleukhin/prom-light/internal/metrics"
@@ -121,7 +134,7 @@ func TestUpdateMetricHandler_ServeHTTP(t *testing.T) {
 	}
 
 	mockStorage := storage.NewMockStorage()
-	testServer := httptest.NewServer(NewRouter(mockStorage, nil, nil, net.IPNet{}))
+	testServer := httptest.NewServer(NewRouter(mockStorage, nil, nil, net.IPNet{}, ""))
 	defer testServer.Close()
 
 	for _, tt := range tests {
@@ -213,17 +226,17 @@ func TestGetMetricHandler_ServeHTTP(t *testing.T) {
 	}
 
 	mockStorage := storage.NewMockStorage()
-	testServer := httptest.NewServer(NewRouter(mockStorage, nil, nil, net.IPNet{}))
+	testServer := httptest.NewServer(NewRouter(mockStorage, nil, nil, net.IPNet{}, ""))
 	defer testServer.Close()
 	ctx := context.Background()
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			for name, value := range tt.metrics.gauges {
-				_ = mockStorage.SetGauge(ctx, name, value)
+				_ = mockStorage.SetGauge(ctx, name, nil, value)
 			}
 			for name, value := range tt.metrics.counters {
-				_ = mockStorage.IncCounter(ctx, name, value)
+				_ = mockStorage.IncCounter(ctx, name, nil, value)
 			}
 
 			req, err := http.NewRequest(tt.request.method, testServer.URL+tt.request.URI, nil)
@@ -247,8 +260,8 @@ func TestGetMetricHandler_ServeHTTP(t *testing.T) {
 
 func TestHomeHandler_ServeHTTP(t *testing.T) {
 	mockStorage := storage.NewMockStorage()
-	_ = mockStorage.IncCounter(context.Background(), "foo", 1)
-	testServer := httptest.NewServer(NewRouter(mockStorage, nil, nil, net.IPNet{}))
+	_ = mockStorage.IncCounter(context.Background(), "foo", nil, 1)
+	testServer := httptest.NewServer(NewRouter(mockStorage, nil, nil, net.IPNet{}, ""))
 	req, err := http.NewRequest(http.MethodGet, testServer.URL, nil)
 	require.NoError(t, err)
 
@@ -304,12 +317,24 @@ func TestUpdateMetricJSONHandler_ServeHTTP(t *testing.T) {
 				},
 			},
 		},
+		{
+			name:    "Labelled gauge",
+			payload: []byte(`{"id":"TestGauge","type":"gauge","value":5.5,"labels":{"host":"a"}}`),
+			want: want{
+				code: http.StatusOK,
+				metric: metrics.Metric{
+					Name:  `TestGauge{host="a"}`,
+					Type:  metrics.GaugeTypeName,
+					Value: &testGauge,
+				},
+			},
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			mockStorage := storage.NewMockStorage()
-			testServer := httptest.NewServer(NewRouter(mockStorage, nil, nil, net.IPNet{}))
+			testServer := httptest.NewServer(NewRouter(mockStorage, nil, nil, net.IPNet{}, ""))
 			defer testServer.Close()
 
 			req, err := http.NewRequest(http.MethodPost, testServer.URL+"/update/", bytes.NewBuffer(tt.payload))
@@ -378,7 +403,7 @@ func TestBatchUpdateMetricJSONHandler_ServeHTTP(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			mockStorage := storage.NewMockStorage()
-			testServer := httptest.NewServer(NewRouter(mockStorage, nil, nil, net.IPNet{}))
+			testServer := httptest.NewServer(NewRouter(mockStorage, nil, nil, net.IPNet{}, ""))
 			defer testServer.Close()
 
 			req, err := http.NewRequest(http.MethodPost, testServer.URL+"/updates/", bytes.NewBuffer(tt.payload))
@@ -404,6 +429,63 @@ func TestBatchUpdateMetricJSONHandler_ServeHTTP(t *testing.T) {
 	}
 }
 
+func TestBatchUpdateEncrypted_ServeHTTP(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	payload := []byte(`[{"id":"TestCounter","type":"counter","delta":5},{"id":"TestGauge","type":"gauge","value":5.5}]`)
+
+	envelope, err := crypt.EncryptEnvelope(&key.PublicKey, payload)
+	require.NoError(t, err)
+	legacy, err := crypt.EncryptOAEP(&key.PublicKey, payload, nil)
+	require.NoError(t, err)
+
+	for name, body := range map[string][]byte{"Envelope": envelope, "Legacy": legacy} {
+		t.Run(name, func(t *testing.T) {
+			mockStorage := storage.NewMockStorage()
+			testServer := httptest.NewServer(NewRouter(mockStorage, nil, key, net.IPNet{}, ""))
+			defer testServer.Close()
+
+			response, err := http.Post(testServer.URL+"/updates/", "application/json", bytes.NewReader(body))
+			require.NoError(t, err)
+			defer response.Body.Close()
+			require.Equal(t, http.StatusOK, response.StatusCode)
+
+			mockStorage.AssertCounterStoredWithValue(t, "TestCounter", 5)
+			mockStorage.AssertGaugeStoredWithValue(t, "TestGauge", 5.5)
+		})
+	}
+
+	t.Run("Other key", func(t *testing.T) {
+		other, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+		body, err := crypt.EncryptEnvelope(&other.PublicKey, payload)
+		require.NoError(t, err)
+		testServer := httptest.NewServer(NewRouter(storage.NewMockStorage(), nil, key, net.IPNet{}, ""))
+		defer testServer.Close()
+
+		response, err := http.Post(testServer.URL+"/updates/", "application/json", bytes.NewReader(body))
+		require.NoError(t, err)
+		defer response.Body.Close()
+		require.Equal(t, http.StatusBadRequest, response.StatusCode)
+	})
+}
+
+func TestHTTPClient_Encrypted(t *testing.T) {
+	ctx := context.Background()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	mockStorage := storage.NewMockStorage()
+	testServer := httptest.NewServer(NewRouter(mockStorage, nil, key, net.IPNet{}, ""))
+	defer testServer.Close()
+
+	client := agent.NewHTTPClient(strings.TrimPrefix(testServer.URL, "http://"), net.IPv4(127, 0, 0, 1), time.Second, &key.PublicKey, nil, agent.RetryPolicy{})
+	require.NoError(t, client.SendMetricToServer(ctx, metrics.MakeGaugeMetric("Alloc", 5.5)))
+	require.NoError(t, client.SendBatchMetricsToServer(ctx, metrics.Metrics{metrics.MakeCounterMetric("PollCount", 2)}))
+
+	mockStorage.AssertGaugeStoredWithValue(t, "Alloc", 5.5)
+	mockStorage.AssertCounterStoredWithValue(t, "PollCount", 2)
+}
+
 func TestGetMetricJSONHandler_ServeHTTP(t *testing.T) {
 	type want struct {
 		code     int
@@ -447,6 +529,15 @@ func TestGetMetricJSONHandler_ServeHTTP(t *testing.T) {
 				code:     200,
 				response: `{"id":"TestGauge","type":"gauge","value":99.99}`,
 			},
+		}, {
+			name:    "Get labelled gauge",
+			payload: []byte(`{"id":"TestGauge","type":"gauge","labels":{"host":"a"}}`),
+			metrics: storedMetrics{
+				gauges: map[string]metrics.Gauge{"TestGauge": 99.99},
+			},
+			want: want{
+				code: 404,
+			},
 		},
 	}
 
@@ -455,14 +546,14 @@ func TestGetMetricJSONHandler_ServeHTTP(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			mockStorage := storage.NewMockStorage()
-			testServer := httptest.NewServer(NewRouter(mockStorage, nil, nil, net.IPNet{}))
+			testServer := httptest.NewServer(NewRouter(mockStorage, nil, nil, net.IPNet{}, ""))
 			defer testServer.Close()
 
 			for name, value := range tt.metrics.gauges {
-				_ = mockStorage.SetGauge(ctx, name, value)
+				_ = mockStorage.SetGauge(ctx, name, nil, value)
 			}
 			for name, value := range tt.metrics.counters {
-				_ = mockStorage.IncCounter(ctx, name, value)
+				_ = mockStorage.IncCounter(ctx, name, nil, value)
 			}
 
 			req, err := http.NewRequest(http.MethodPost, testServer.URL+"/value/", bytes.NewBuffer(tt.payload))
@@ -484,6 +575,535 @@ func TestGetMetricJSONHandler_ServeHTTP(t *testing.T) {
 	}
 }
 
+func TestGetMetricHistoryHandler_ServeHTTP(t *testing.T) {
+	mockStorage := storage.NewMockStorage()
+	ctx := context.Background()
+	_ = mockStorage.SetGauge(ctx, "HeapAlloc", nil, 1)
+	_ = mockStorage.SetGauge(ctx, "HeapAlloc", nil, 2)
+
+	testServer := httptest.NewServer(NewRouter(mockStorage, nil, nil, net.IPNet{}, ""))
+	defer testServer.Close()
+
+	t.Run("Last hour", func(t *testing.T) {
+		response, err := http.Get(testServer.URL + "/history/HeapAlloc")
+		require.NoError(t, err)
+		defer response.Body.Close()
+		require.Equal(t, http.StatusOK, response.StatusCode)
+
+		var history metrics.Metrics
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&history))
+		require.Len(t, history, 2)
+		require.Equal(t, metrics.Gauge(1), *history[0].Value)
+		require.Equal(t, metrics.Gauge(2), *history[1].Value)
+	})
+
+	t.Run("Empty period", func(t *testing.T) {
+		response, err := http.Get(testServer.URL + "/history/HeapAlloc?from=2000-01-01T00:00:00Z&to=2000-01-02T00:00:00Z")
+		require.NoError(t, err)
+		defer response.Body.Close()
+		require.Equal(t, http.StatusOK, response.StatusCode)
+
+		body, err := io.ReadAll(response.Body)
+		require.NoError(t, err)
+		require.Equal(t, "[]", string(body))
+	})
+
+	t.Run("Bad period", func(t *testing.T) {
+		response, err := http.Get(testServer.URL + "/history/HeapAlloc?from=yesterday")
+		require.NoError(t, err)
+		defer response.Body.Close()
+		require.Equal(t, http.StatusBadRequest, response.StatusCode)
+	})
+}
+
+func TestPrometheusHandler_ServeHTTP(t *testing.T) {
+	ctx := context.Background()
+	mockStorage := storage.NewMockStorage()
+	_ = mockStorage.SetGauge(ctx, "HeapAlloc", nil, 1.5)
+	_ = mockStorage.SetGauge(ctx, "cpu.utilization", metrics.Labels{"cpu": "1", "host-name": `a"b`}, 12)
+	_ = mockStorage.IncCounter(ctx, "PollCount", nil, 5)
+	_ = mockStorage.IncCounter(ctx, "requests_total", metrics.Labels{"code": "200"}, 7)
+
+	testServer := httptest.NewServer(NewRouter(mockStorage, nil, nil, net.IPNet{}, ""))
+	defer testServer.Close()
+
+	tests := []struct {
+		name        string
+		accept      string
+		contentType string
+		body        string
+	}{
+		{
+			name:        "Prometheus text format by default",
+			contentType: "text/plain; version=0.0.4; charset=utf-8",
+			body: `# TYPE HeapAlloc gauge
+HeapAlloc 1.5
+# TYPE PollCount counter
+PollCount 5
+# TYPE cpu_utilization gauge
+cpu_utilization{cpu="1",host_name="a\"b"} 12
+# TYPE requests_total counter
+requests_total{code="200"} 7
+`,
+		},
+		{
+			name:        "OpenMetrics",
+			accept:      "application/openmetrics-text; version=1.0.0,text/plain;version=0.0.4;q=0.5",
+			contentType: "application/openmetrics-text; version=1.0.0; charset=utf-8",
+			body: `# TYPE HeapAlloc gauge
+HeapAlloc 1.5
+# TYPE PollCount counter
+PollCount_total 5
+# TYPE cpu_utilization gauge
+cpu_utilization{cpu="1",host_name="a\"b"} 12
+# TYPE requests counter
+requests_total{code="200"} 7
+# EOF
+`,
+		},
+		{
+			name:        "Text format preferred",
+			accept:      "application/openmetrics-text;q=0.2,text/plain",
+			contentType: "text/plain; version=0.0.4; charset=utf-8",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, testServer.URL+"/metrics", nil)
+			require.NoError(t, err)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+
+			response, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer response.Body.Close()
+
+			require.Equal(t, http.StatusOK, response.StatusCode)
+			require.Equal(t, tt.contentType, response.Header.Get("Content-Type"))
+
+			if tt.body != "" {
+				body, err := io.ReadAll(response.Body)
+				require.NoError(t, err)
+				require.Equal(t, tt.body, string(body))
+			}
+		})
+	}
+}
+
+func TestRemoteWriteHandler_ServeHTTP(t *testing.T) {
+	payload, err := os.ReadFile("testdata/remote_write.bin")
+	require.NoError(t, err)
+
+	_, trustedSubnet, err := net.ParseCIDR("10.0.0.0/8")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name          string
+		payload       []byte
+		realIP        string
+		trustedSubnet net.IPNet
+		code          int
+	}{
+		{
+			name:    "Recorded payload",
+			payload: payload,
+			code:    http.StatusNoContent,
+		},
+		{
+			name:    "Not compressed",
+			payload: []byte("test"),
+			code:    http.StatusBadRequest,
+		},
+		{
+			name:          "Trusted subnet",
+			payload:       payload,
+			realIP:        "10.0.0.1",
+			trustedSubnet: *trustedSubnet,
+			code:          http.StatusNoContent,
+		},
+		{
+			name:          "Untrusted subnet",
+			payload:       payload,
+			realIP:        "192.168.0.1",
+			trustedSubnet: *trustedSubnet,
+			code:          http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage := storage.NewMockStorage()
+			testServer := httptest.NewServer(NewRouter(mockStorage, nil, nil, tt.trustedSubnet, ""))
+			defer testServer.Close()
+
+			req, err := http.NewRequest(http.MethodPost, testServer.URL+"/api/v1/write", bytes.NewBuffer(tt.payload))
+			require.NoError(t, err)
+			req.Header.Set("Content-Encoding", "snappy")
+			req.Header.Set("Content-Type", "application/x-protobuf")
+			if tt.realIP != "" {
+				req.Header.Set(config.XRealIPHeader, tt.realIP)
+			}
+
+			response, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer response.Body.Close()
+			require.Equal(t, tt.code, response.StatusCode)
+
+			if tt.code == http.StatusNoContent {
+				mockStorage.AssertGaugeStoredWithValue(t, `go_goroutines{instance="localhost:9090",job="prometheus"}`, 33)
+				mockStorage.AssertGaugeStoredWithValue(t, `process_resident_memory_bytes{instance="localhost:9090",job="prometheus"}`, 4.2e+07)
+				mockStorage.AssertGaugeStoredWithValue(t, `up{job="node"}`, 1)
+			}
+		})
+	}
+}
+
+// slowCounterStorage отдает значение счетчика с задержкой, чтобы параллельные запросы
+// успели прочитать его до записи приращения
+type slowCounterStorage struct {
+	storage.MetricsStorage
+}
+
+func (s slowCounterStorage) GetCounter(ctx context.Context, name string, labels metrics.Labels) (metrics.Counter, error) {
+	value, err := s.MetricsStorage.GetCounter(ctx, name, labels)
+	time.Sleep(10 * time.Millisecond)
+	return value, err
+}
+
+// brokenCounterStorage не может прочитать счетчики, например из-за недоступной БД
+type brokenCounterStorage struct {
+	storage.MetricsStorage
+}
+
+func (s brokenCounterStorage) GetCounter(context.Context, string, metrics.Labels) (metrics.Counter, error) {
+	return 0, errors.New("connection closed")
+}
+
+func TestWriteLineProtocolHandler_ServeHTTP(t *testing.T) {
+	ctx := context.Background()
+	mockStorage := storage.NewMockStorage()
+	_ = mockStorage.IncCounter(ctx, "net_bytes_total", metrics.Labels{"host": "a"}, 100)
+
+	testServer := httptest.NewServer(NewRouter(mockStorage, nil, nil, net.IPNet{}, ""))
+	defer testServer.Close()
+
+	t.Run("Valid lines", func(t *testing.T) {
+		payload := "cpu,host=a usage_idle=98.5,value=3i 1465839830\n" +
+			"net,host=a bytes_total=150i,iface=\"eth0\" 1465839830\n" +
+			"net,host=a bytes_total=170i 1465839840\n"
+		response, err := http.Post(testServer.URL+"/write?precision=s", "text/plain", bytes.NewBufferString(payload))
+		require.NoError(t, err)
+		defer response.Body.Close()
+		require.Equal(t, http.StatusNoContent, response.StatusCode)
+
+		mockStorage.AssertGaugeStoredWithValue(t, `cpu_usage_idle{host="a"}`, 98.5)
+		mockStorage.AssertGaugeStoredWithValue(t, `cpu{host="a"}`, 3)
+		mockStorage.AssertCounterStoredWithValue(t, `net_bytes_total{host="a"}`, 170)
+	})
+
+	t.Run("Concurrent counters", func(t *testing.T) {
+		counterStorage := storage.NewMockStorage()
+		slowServer := httptest.NewServer(NewRouter(slowCounterStorage{counterStorage}, nil, nil, net.IPNet{}, ""))
+		defer slowServer.Close()
+
+		// Каждый запрос присылает одно и то же накопленное значение, приращение должно учесться один раз
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				response, err := http.Post(slowServer.URL+"/write", "text/plain", bytes.NewBufferString("req,host=b count_total=50i\n"))
+				if assert.NoError(t, err) {
+					response.Body.Close()
+					assert.Equal(t, http.StatusNoContent, response.StatusCode)
+				}
+			}()
+		}
+		wg.Wait()
+
+		counterStorage.AssertCounterStoredWithValue(t, `req_count_total{host="b"}`, 50)
+	})
+
+	t.Run("Stored counter is unavailable", func(t *testing.T) {
+		brokenStorage := storage.NewMockStorage()
+		brokenServer := httptest.NewServer(NewRouter(brokenCounterStorage{brokenStorage}, nil, nil, net.IPNet{}, ""))
+		defer brokenServer.Close()
+
+		response, err := http.Post(brokenServer.URL+"/write", "text/plain", bytes.NewBufferString("req,host=c count_total=50i\n"))
+		require.NoError(t, err)
+		defer response.Body.Close()
+		require.Equal(t, http.StatusInternalServerError, response.StatusCode)
+
+		_, err = brokenStorage.GetCounter(context.Background(), "req_count_total", metrics.Labels{"host": "c"})
+		assert.ErrorIs(t, err, storage.ErrMetricNotFound)
+	})
+
+	t.Run("Bad line", func(t *testing.T) {
+		response, err := http.Post(testServer.URL+"/write", "text/plain", bytes.NewBufferString("cpu\n"))
+		require.NoError(t, err)
+		defer response.Body.Close()
+		require.Equal(t, http.StatusBadRequest, response.StatusCode)
+	})
+
+	t.Run("Bad precision", func(t *testing.T) {
+		response, err := http.Post(testServer.URL+"/write?precision=d", "text/plain", bytes.NewBufferString("cpu value=1\n"))
+		require.NoError(t, err)
+		defer response.Body.Close()
+		require.Equal(t, http.StatusBadRequest, response.StatusCode)
+	})
+
+	t.Run("Signed body", func(t *testing.T) {
+		signedServer := httptest.NewServer(NewRouter(mockStorage, hmac.New(sha256.New, []byte("key")), nil, net.IPNet{}, ""))
+		defer signedServer.Close()
+
+		payload := []byte("load value=1.5\n")
+		hasher := hmac.New(sha256.New, []byte("key"))
+		hasher.Write(payload)
+
+		for hash, code := range map[string]int{
+			hex.EncodeToString(hasher.Sum(nil)): http.StatusNoContent,
+			"bad":                               http.StatusBadRequest,
+		} {
+			req, err := http.NewRequest(http.MethodPost, signedServer.URL+"/write", bytes.NewBuffer(payload))
+			require.NoError(t, err)
+			req.Header.Set(config.HashHeader, hash)
+
+			response, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			response.Body.Close()
+			require.Equal(t, code, response.StatusCode)
+		}
+	})
+}
+
+func TestPrometheusHandler_Distributions(t *testing.T) {
+	ctx := context.Background()
+	mockStorage := storage.NewMockStorage()
+	h := metrics.MakeHistogramMetric("request_duration", metrics.Histogram{
+		Buckets: []metrics.Bucket{{UpperBound: 0.1, Count: 2}, {UpperBound: 1, Count: 3}},
+		Sum:     1.7,
+		Count:   4,
+	})
+	h.Labels = metrics.Labels{"path": "/"}
+	_ = mockStorage.SetMetric(ctx, h)
+	_ = mockStorage.SetMetric(ctx, metrics.MakeSummaryMetric("gc_pause", metrics.Summary{
+		Quantiles: []metrics.Quantile{{Quantile: 0.5, Value: 0.01}, {Quantile: 0.99, Value: 0.2}},
+		Sum:       1.5,
+		Count:     30,
+	}))
+
+	testServer := httptest.NewServer(NewRouter(mockStorage, nil, nil, net.IPNet{}, ""))
+	defer testServer.Close()
+
+	response, err := http.Get(testServer.URL + "/metrics")
+	require.NoError(t, err)
+	defer response.Body.Close()
+	require.Equal(t, http.StatusOK, response.StatusCode)
+
+	body, err := io.ReadAll(response.Body)
+	require.NoError(t, err)
+	require.Equal(t, `# TYPE gc_pause summary
+gc_pause{quantile="0.5"} 0.01
+gc_pause{quantile="0.99"} 0.2
+gc_pause_sum 1.5
+gc_pause_count 30
+# TYPE request_duration histogram
+request_duration_bucket{le="0.1",path="/"} 2
+request_duration_bucket{le="1",path="/"} 3
+request_duration_bucket{le="+Inf",path="/"} 4
+request_duration_sum{path="/"} 1.7
+request_duration_count{path="/"} 4
+`, string(body))
+}
+
+func TestUpdateHistogramHandler_ServeHTTP(t *testing.T) {
+	mockStorage := storage.NewMockStorage()
+	testServer := httptest.NewServer(NewRouter(mockStorage, nil, nil, net.IPNet{}, ""))
+	defer testServer.Close()
+
+	payload := `{"id":"latency","type":"histogram","histogram":{"buckets":[{"le":0.5,"count":1},{"le":1,"count":2}],"sum":1.2,"count":2}}`
+	for i := 0; i < 2; i++ {
+		response, err := http.Post(testServer.URL+"/update/", "application/json", bytes.NewBufferString(payload))
+		require.NoError(t, err)
+		response.Body.Close()
+		require.Equal(t, http.StatusOK, response.StatusCode)
+	}
+
+	response, err := http.Post(testServer.URL+"/value/", "application/json", bytes.NewBufferString(`{"id":"latency","type":"histogram"}`))
+	require.NoError(t, err)
+	defer response.Body.Close()
+	require.Equal(t, http.StatusOK, response.StatusCode)
+
+	body, err := io.ReadAll(response.Body)
+	require.NoError(t, err)
+	require.Equal(t, `{"id":"latency","type":"histogram","histogram":{"buckets":[{"le":0.5,"count":2},{"le":1,"count":4}],"sum":2.4,"count":4}}`, string(body))
+
+	response, err = http.Post(testServer.URL+"/update/histogram/latency/1", "text/plain", nil)
+	require.NoError(t, err)
+	defer response.Body.Close()
+	require.Equal(t, http.StatusBadRequest, response.StatusCode)
+}
+
+func TestListMetricsHandler_ServeHTTP(t *testing.T) {
+	ctx := context.Background()
+	mockStorage := storage.NewMockStorage()
+	edge := metrics.MakeGaugeMetric("HeapSys", 4)
+	edge.Labels = metrics.Labels{"agent": "edge-01"}
+	require.NoError(t, mockStorage.SetMetrics(ctx, metrics.Metrics{
+		metrics.MakeGaugeMetric("HeapAlloc", 1),
+		metrics.MakeGaugeMetric("HeapInuse", 2),
+		metrics.MakeCounterMetric("PollCount", 3),
+		edge,
+	}))
+	testServer := httptest.NewServer(NewRouter(mockStorage, nil, nil, net.IPNet{}, ""))
+	defer testServer.Close()
+
+	list := func(query string) (int, storage.MetricsPage) {
+		response, err := http.Get(testServer.URL + "/api/v1/metrics?" + query)
+		require.NoError(t, err)
+		defer response.Body.Close()
+
+		var page storage.MetricsPage
+		if response.StatusCode == http.StatusOK {
+			require.NoError(t, json.NewDecoder(response.Body).Decode(&page))
+		}
+		return response.StatusCode, page
+	}
+	ids := func(page storage.MetricsPage) []string {
+		result := make([]string, 0, len(page.Metrics))
+		for _, m := range page.Metrics {
+			result = append(result, m.ID())
+		}
+		return result
+	}
+
+	status, page := list("type=gauge&name_prefix=Heap&label=agent=edge-01")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, []string{`HeapSys{agent="edge-01"}`}, ids(page))
+
+	status, page = list("name_regex=Inuse|Count&sort=-type")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, []string{"HeapInuse", "PollCount"}, ids(page))
+
+	var paginated []string
+	cursor := ""
+	for i := 0; i < 3; i++ {
+		status, page = list("limit=2&cursor=" + cursor)
+		require.Equal(t, http.StatusOK, status)
+		paginated = append(paginated, ids(page)...)
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	require.Equal(t, []string{"HeapAlloc", "HeapInuse", `HeapSys{agent="edge-01"}`, "PollCount"}, paginated)
+
+	status, page = list("name_prefix=Unknown")
+	require.Equal(t, http.StatusOK, status)
+	require.NotNil(t, page.Metrics)
+	require.Empty(t, page.Metrics)
+
+	for _, query := range []string{"type=timer", "label=agent", "sort=value", "limit=0", "limit=1001", "name_regex=(", "cursor=broken"} {
+		status, _ = list(query)
+		require.Equal(t, http.StatusBadRequest, status, query)
+	}
+}
+
+func TestDeleteMetricHandler_ServeHTTP(t *testing.T) {
+	_, trustedSubnet, err := net.ParseCIDR("127.0.0.0/8")
+	require.NoError(t, err)
+	_, privateSubnet, err := net.ParseCIDR("10.0.0.0/8")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name          string
+		trustedSubnet net.IPNet
+		realIP        string
+		uri           string
+		wantStatus    int
+		wantStored    []string
+	}{
+		{
+			name:          "Delete all series",
+			trustedSubnet: *trustedSubnet,
+			uri:           "/value/gauge/CPUUtilization1",
+			wantStatus:    http.StatusOK,
+			wantStored:    []string{"CPUUtilization1", "CPUUtilization2"},
+		},
+		{
+			name:          "Delete labelled series",
+			trustedSubnet: *trustedSubnet,
+			realIP:        "127.0.0.2",
+			uri:           "/value/gauge/CPUUtilization1?host=edge-01",
+			wantStatus:    http.StatusOK,
+			wantStored:    []string{"CPUUtilization1", "CPUUtilization1", "CPUUtilization2"},
+		},
+		{
+			name:          "Unknown metric",
+			trustedSubnet: *trustedSubnet,
+			uri:           "/value/gauge/Unknown",
+			wantStatus:    http.StatusNotFound,
+		},
+		{
+			name:          "Untrusted address behind trusted proxy",
+			trustedSubnet: *trustedSubnet,
+			realIP:        "192.168.1.1",
+			uri:           "/value/gauge/CPUUtilization1",
+			wantStatus:    http.StatusForbidden,
+		},
+		{
+			name:          "Trusted address in header from untrusted peer",
+			trustedSubnet: *privateSubnet,
+			realIP:        "10.0.0.1",
+			uri:           "/value/gauge/CPUUtilization1",
+			wantStatus:    http.StatusForbidden,
+		},
+		{
+			name:       "Trusted subnet is not configured",
+			uri:        "/value/gauge/CPUUtilization1",
+			wantStatus: http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage := storage.NewMockStorage()
+			edge := metrics.MakeGaugeMetric("CPUUtilization1", 10)
+			edge.Labels = metrics.Labels{"host": "edge-01"}
+			require.NoError(t, mockStorage.SetMetrics(context.Background(), metrics.Metrics{
+				metrics.MakeGaugeMetric("CPUUtilization1", 5),
+				edge,
+				metrics.MakeGaugeMetric("CPUUtilization2", 7),
+				metrics.MakeCounterMetric("CPUUtilization1", 1),
+			}))
+			testServer := httptest.NewServer(NewRouter(mockStorage, nil, nil, tt.trustedSubnet, ""))
+			defer testServer.Close()
+
+			req, err := http.NewRequest(http.MethodDelete, testServer.URL+tt.uri, nil)
+			require.NoError(t, err)
+			if tt.realIP != "" {
+				req.Header.Set(config.XRealIPHeader, tt.realIP)
+			}
+			response, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer response.Body.Close()
+			require.Equal(t, tt.wantStatus, response.StatusCode)
+
+			if tt.wantStored == nil {
+				return
+			}
+			all, err := mockStorage.GetAllMetrics(context.Background())
+			require.NoError(t, err)
+			stored := make([]string, 0, len(all))
+			for _, m := range all {
+				stored = append(stored, m.Name)
+			}
+			require.ElementsMatch(t, tt.wantStored, stored)
+		})
+	}
+}
+
 func TestServer_Start(t *testing.T) {
 	server, err := NewApp(&config.ServerConfig{
 		Addr:      "localhost:9999",
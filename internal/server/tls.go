@@ -0,0 +1,45 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+
+	"github.com/vleukhin/prom-light/internal/config"
+)
+
+// serverTLSConfig создает настройки TLS сервера. Если сертификат не задан, выдает nil.
+// Если задан TLSClientCA, клиенты обязаны предъявить сертификат, подписанный этим CA
+func serverTLSConfig(cfg *config.ServerConfig) (*tls.Config, error) {
+	if cfg.TLSCert == "" {
+		if cfg.TLSClientCA != "" {
+			return nil, errors.New("client CA requires server certificate")
+		}
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey)
+	if err != nil {
+		return nil, err
+	}
+	tlsCfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if cfg.TLSClientCA != "" {
+		pem, err := os.ReadFile(cfg.TLSClientCA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in " + cfg.TLSClientCA)
+		}
+		tlsCfg.ClientCAs = pool
+		tlsCfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsCfg, nil
+}
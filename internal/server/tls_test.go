@@ -0,0 +1,206 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/vleukhin/prom-light/internal/agent"
+	"github.com/vleukhin/prom-light/internal/config"
+	"github.com/vleukhin/prom-light/internal/metrics"
+	"github.com/vleukhin/prom-light/internal/storage"
+)
+
+// testCA удостоверяющий центр, выпускающий сертификаты в памяти
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "prom-light test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue выпускает сертификат. Сертификат сервера выпускается для 127.0.0.1
+func (ca *testCA) issue(t *testing.T, commonName string, dnsNames []string, server bool) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if server {
+		template.IPAddresses = []net.IP{net.IPv4(127, 0, 0, 1)}
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// serverConfig выдает настройки TLS сервера, требующего клиентский сертификат
+func (ca *testCA) serverConfig(t *testing.T) *tls.Config {
+	return &tls.Config{
+		Certificates: []tls.Certificate{ca.issue(t, "prom-light", nil, true)},
+		ClientCAs:    ca.pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+}
+
+// clientConfig выдает настройки TLS агента с клиентским сертификатом cert
+func (ca *testCA) clientConfig(cert *tls.Certificate) *tls.Config {
+	cfg := &tls.Config{RootCAs: ca.pool}
+	if cert != nil {
+		cfg.Certificates = []tls.Certificate{*cert}
+	}
+
+	return cfg
+}
+
+func TestHTTPServer_MutualTLS(t *testing.T) {
+	ctx := context.Background()
+	ca := newTestCA(t)
+	mockStorage := storage.NewMockStorage()
+
+	testServer := httptest.NewUnstartedServer(NewRouter(mockStorage, nil, nil, net.IPNet{}, "agent"))
+	testServer.TLS = ca.serverConfig(t)
+	testServer.StartTLS()
+	defer testServer.Close()
+	addr := strings.TrimPrefix(testServer.URL, "https://")
+
+	byCN := ca.issue(t, "edge-01", []string{"edge-01.example.com"}, false)
+	bySAN := ca.issue(t, "", []string{"edge-02.example.com"}, false)
+	foreign := newTestCA(t).issue(t, "edge-03", nil, false)
+
+	tests := []struct {
+		name     string
+		tlsCfg   *tls.Config
+		identity string
+		wantErr  bool
+	}{
+		{name: "Identity from common name", tlsCfg: ca.clientConfig(&byCN), identity: "edge-01"},
+		{name: "Identity from SAN", tlsCfg: ca.clientConfig(&bySAN), identity: "edge-02.example.com"},
+		{name: "Missing client certificate", tlsCfg: ca.clientConfig(nil), wantErr: true},
+		{name: "Certificate of unknown CA", tlsCfg: ca.clientConfig(&foreign), wantErr: true},
+		{name: "Plain HTTP", tlsCfg: nil, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := agent.NewHTTPClient(addr, net.IPv4(127, 0, 0, 1), time.Second, nil, tt.tlsCfg, agent.RetryPolicy{})
+			// Агент не может подменить идентификатор собственной меткой
+			m := metrics.MakeGaugeMetric("Alloc", 7)
+			m.Labels = metrics.Labels{"agent": "spoofed"}
+
+			err := client.SendBatchMetricsToServer(ctx, metrics.Metrics{m})
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			id := metrics.Metric{Name: "Alloc", Labels: metrics.Labels{"agent": tt.identity}}.ID()
+			mockStorage.AssertGaugeStoredWithValue(t, id, 7)
+		})
+	}
+}
+
+func TestGRPCServer_MutualTLS(t *testing.T) {
+	ctx := context.Background()
+	ca := newTestCA(t)
+	mockStorage := storage.NewMockStorage()
+	addr := startGRPCServer(t, NewGRPCServer("", mockStorage, nil, net.IPNet{}, credentials.NewTLS(ca.serverConfig(t)), "agent"))
+
+	cert := ca.issue(t, "edge-01", nil, false)
+	client, err := agent.NewGRPCClient(addr, nil, ca.clientConfig(&cert), agent.RetryPolicy{})
+	require.NoError(t, err)
+	defer client.ShutDown()
+	require.NoError(t, client.SendMetricToServer(ctx, metrics.MakeCounterMetric("PollCount", 3)))
+	mockStorage.AssertCounterStoredWithValue(t, `PollCount{agent="edge-01"}`, 3)
+
+	anonymous, err := agent.NewGRPCClient(addr, nil, ca.clientConfig(nil), agent.RetryPolicy{})
+	require.NoError(t, err)
+	defer anonymous.ShutDown()
+	assert.Error(t, anonymous.SendMetricToServer(ctx, metrics.MakeCounterMetric("PollCount", 1)))
+}
+
+func TestServerTLSConfig(t *testing.T) {
+	ca := newTestCA(t)
+	cert := ca.issue(t, "prom-light", nil, true)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	files := map[string]*pem.Block{
+		"cert.pem": {Type: "CERTIFICATE", Bytes: cert.Certificate[0]},
+		"key.pem":  {Type: "PRIVATE KEY", Bytes: keyDER},
+		"ca.pem":   {Type: "CERTIFICATE", Bytes: ca.cert.Raw},
+	}
+	for name, block := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), pem.EncodeToMemory(block), 0600))
+	}
+
+	tlsCfg, err := serverTLSConfig(&config.ServerConfig{})
+	require.NoError(t, err)
+	assert.Nil(t, tlsCfg)
+
+	tlsCfg, err = serverTLSConfig(&config.ServerConfig{
+		TLSCert: filepath.Join(dir, "cert.pem"),
+		TLSKey:  filepath.Join(dir, "key.pem"),
+	})
+	require.NoError(t, err)
+	assert.Len(t, tlsCfg.Certificates, 1)
+	assert.Equal(t, tls.NoClientCert, tlsCfg.ClientAuth)
+
+	tlsCfg, err = serverTLSConfig(&config.ServerConfig{
+		TLSCert:     filepath.Join(dir, "cert.pem"),
+		TLSKey:      filepath.Join(dir, "key.pem"),
+		TLSClientCA: filepath.Join(dir, "ca.pem"),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, tls.RequireAndVerifyClientCert, tlsCfg.ClientAuth)
+
+	_, err = serverTLSConfig(&config.ServerConfig{TLSClientCA: filepath.Join(dir, "ca.pem")})
+	assert.Error(t, err)
+}
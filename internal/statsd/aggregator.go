@@ -0,0 +1,176 @@
+package statsd
+
+import (
+	"math"
+	"sync"
+
+	"github.com/vleukhin/prom-light/internal/metrics"
+)
+
+// timerBuckets границы корзин гистограмм, в которые превращаются таймеры, в миллисекундах
+var timerBuckets = []float64{5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+type series struct {
+	name   string
+	labels metrics.Labels
+}
+
+type counterState struct {
+	series
+	value float64
+}
+
+type gaugeState struct {
+	series
+	value float64
+	dirty bool
+}
+
+type timerState struct {
+	series
+	values  []float64
+	weights []float64
+}
+
+type setState struct {
+	series
+	values map[string]struct{}
+}
+
+// aggregator накапливает значения StatsD между сбросами в хранилище
+type aggregator struct {
+	mutex    sync.Mutex
+	counters map[string]*counterState
+	gauges   map[string]*gaugeState
+	timers   map[string]*timerState
+	sets     map[string]*setState
+}
+
+func newAggregator() *aggregator {
+	return &aggregator{
+		counters: make(map[string]*counterState),
+		gauges:   make(map[string]*gaugeState),
+		timers:   make(map[string]*timerState),
+		sets:     make(map[string]*setState),
+	}
+}
+
+// add учитывает значение в агрегатах
+func (a *aggregator) add(s sample) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	key := metrics.Metric{Name: s.name, Labels: s.labels}.ID()
+	sr := series{name: s.name, labels: s.labels}
+
+	switch s.typ {
+	case counterType:
+		c, ok := a.counters[key]
+		if !ok {
+			c = &counterState{series: sr}
+			a.counters[key] = c
+		}
+		c.value += s.value / s.rate
+	case gaugeType:
+		g, ok := a.gauges[key]
+		if !ok {
+			g = &gaugeState{series: sr}
+			a.gauges[key] = g
+		}
+		if s.relative {
+			g.value += s.value
+		} else {
+			g.value = s.value
+		}
+		g.dirty = true
+	case timerType, histogramType:
+		t, ok := a.timers[key]
+		if !ok {
+			t = &timerState{series: sr}
+			a.timers[key] = t
+		}
+		t.values = append(t.values, s.value)
+		t.weights = append(t.weights, 1/s.rate)
+	case setType:
+		st, ok := a.sets[key]
+		if !ok {
+			st = &setState{series: sr, values: make(map[string]struct{})}
+			a.sets[key] = st
+		}
+		st.values[s.raw] = struct{}{}
+	}
+}
+
+// flush выдает накопленные за интервал метрики и сбрасывает агрегаты.
+// Значения gauge сохраняются между интервалами, но выдаются только после обновления
+func (a *aggregator) flush() metrics.Metrics {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	var result metrics.Metrics
+	for _, c := range a.counters {
+		result = append(result, makeCounter(c.name, c.labels, c.value))
+	}
+	for _, g := range a.gauges {
+		if !g.dirty {
+			continue
+		}
+		result = append(result, makeGauge(g.name, g.labels, g.value))
+		g.dirty = false
+	}
+	for _, t := range a.timers {
+		result = append(result, flushTimer(t))
+	}
+	for _, st := range a.sets {
+		result = append(result, makeGauge(st.name, st.labels, float64(len(st.values))))
+	}
+
+	a.counters = make(map[string]*counterState)
+	a.timers = make(map[string]*timerState)
+	a.sets = make(map[string]*setState)
+
+	return result
+}
+
+// flushTimer превращает значения таймера в гистограмму.
+// Значения, полученные с частотой выборки, учитываются с весом 1/rate
+func flushTimer(t *timerState) metrics.Metric {
+	counts := make([]float64, len(timerBuckets))
+	var sum, count float64
+	for i, v := range t.values {
+		w := t.weights[i]
+		for j, bound := range timerBuckets {
+			if v <= bound {
+				counts[j] += w
+			}
+		}
+		sum += v * w
+		count += w
+	}
+
+	h := metrics.NewHistogram(timerBuckets)
+	for j := range h.Buckets {
+		h.Buckets[j].Count = uint64(math.Round(counts[j]))
+	}
+	h.Sum = sum
+	h.Count = uint64(math.Round(count))
+
+	m := metrics.MakeHistogramMetric(t.name, h)
+	m.Labels = t.labels
+
+	return m
+}
+
+func makeCounter(name string, labels metrics.Labels, value float64) metrics.Metric {
+	m := metrics.MakeCounterMetric(name, metrics.Counter(math.Round(value)))
+	m.Labels = labels
+
+	return m
+}
+
+func makeGauge(name string, labels metrics.Labels, value float64) metrics.Metric {
+	m := metrics.MakeGaugeMetric(name, metrics.Gauge(value))
+	m.Labels = labels
+
+	return m
+}
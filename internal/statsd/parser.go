@@ -0,0 +1,96 @@
+package statsd
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/vleukhin/prom-light/internal/metrics"
+)
+
+// Типы метрик StatsD
+const (
+	counterType   = "c"
+	gaugeType     = "g"
+	timerType     = "ms"
+	histogramType = "h"
+	setType       = "s"
+)
+
+// sample одно значение метрики StatsD
+type sample struct {
+	name   string
+	typ    string
+	value  float64
+	raw    string
+	rate   float64
+	labels metrics.Labels
+	// relative признак относительного изменения gauge (+N или -N)
+	relative bool
+}
+
+// parseLine разбирает строку вида name:value|type[|@rate][|#tag:value,...]
+func parseLine(line string) (sample, error) {
+	s := sample{rate: 1}
+
+	name, rest, found := strings.Cut(line, ":")
+	if !found || name == "" {
+		return s, errors.New("missing metric name")
+	}
+	s.name = name
+
+	parts := strings.Split(rest, "|")
+	if len(parts) < 2 {
+		return s, errors.New("missing metric type")
+	}
+	s.raw = parts[0]
+	s.typ = parts[1]
+
+	for _, part := range parts[2:] {
+		switch {
+		case strings.HasPrefix(part, "@"):
+			rate, err := strconv.ParseFloat(part[1:], 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return s, errors.New("invalid sample rate: " + part)
+			}
+			s.rate = rate
+		case strings.HasPrefix(part, "#"):
+			s.labels = parseTags(part[1:])
+		}
+	}
+
+	switch s.typ {
+	case setType:
+		return s, nil
+	case counterType, gaugeType, timerType, histogramType:
+	default:
+		return s, errors.New("unknown metric type: " + s.typ)
+	}
+
+	value, err := strconv.ParseFloat(s.raw, 64)
+	if err != nil {
+		return s, errors.New("invalid metric value: " + s.raw)
+	}
+	s.value = value
+	s.relative = s.typ == gaugeType && (s.raw[0] == '+' || s.raw[0] == '-')
+
+	return s, nil
+}
+
+// parseTags разбирает теги в формате DogStatsD: tag:value,tag2:value2.
+// Тег без значения превращается в метку с пустым значением
+func parseTags(raw string) metrics.Labels {
+	if raw == "" {
+		return nil
+	}
+	labels := make(metrics.Labels)
+	for _, tag := range strings.Split(raw, ",") {
+		k, v, _ := strings.Cut(tag, ":")
+		if k == "" {
+			continue
+		}
+		labels[k] = v
+	}
+
+	return labels
+}
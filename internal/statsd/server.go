@@ -0,0 +1,118 @@
+package statsd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/vleukhin/prom-light/internal/lineserver"
+	"github.com/vleukhin/prom-light/internal/storage"
+)
+
+// ErrServerClosed возвращается ListenAndServe после вызова Shutdown
+var ErrServerClosed = errors.New("statsd: server closed")
+
+const (
+	// maxPacketSize максимальный размер UDP пакета
+	maxPacketSize = 65535
+	// defaultFlushInterval интервал сброса агрегатов, если он не задан
+	defaultFlushInterval = 10 * time.Second
+)
+
+// Server принимает метрики по протоколу StatsD и периодически сохраняет их агрегаты в хранилище
+type Server struct {
+	udpAddr string
+	tcpAddr string
+	agg     *aggregator
+	lines   *lineserver.Server
+}
+
+// NewServer создает StatsD сервер. Пустой tcpAddr отключает прием метрик по TCP
+func NewServer(udpAddr, tcpAddr string, flushInterval time.Duration, store storage.MetricsSetter) *Server {
+	if flushInterval <= 0 {
+		flushInterval = defaultFlushInterval
+	}
+	agg := newAggregator()
+	return &Server{
+		udpAddr: udpAddr,
+		tcpAddr: tcpAddr,
+		agg:     agg,
+		lines: lineserver.New(lineserver.Options{
+			Name:          "StatsD",
+			FlushInterval: flushInterval,
+			Collect:       agg.flush,
+			Store:         store,
+			ErrClosed:     ErrServerClosed,
+		}),
+	}
+}
+
+// ListenAndServe начинает прием метрик и блокируется до вызова Shutdown
+func (s *Server) ListenAndServe() error {
+	conn, err := net.ListenPacket("udp", s.udpAddr)
+	if err != nil {
+		return err
+	}
+	var listener net.Listener
+	if s.tcpAddr != "" {
+		if listener, err = net.Listen("tcp", s.tcpAddr); err != nil {
+			_ = conn.Close()
+			return err
+		}
+	}
+
+	return s.Serve(conn, listener)
+}
+
+// Serve принимает метрики из UDP сокета conn и, если listener не nil, из TCP соединений.
+// Блокируется до вызова Shutdown
+func (s *Server) Serve(conn net.PacketConn, listener net.Listener) error {
+	if listener != nil {
+		go func() {
+			if err := s.lines.ServeTCP(listener, s.handleLine); !errors.Is(err, ErrServerClosed) {
+				log.Error().Err(err).Msg("Failed to accept StatsD connection")
+			}
+		}()
+	}
+
+	return s.lines.Serve(conn, func() error {
+		buf := make([]byte, maxPacketSize)
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				return err
+			}
+			s.handlePacket(buf[:n])
+		}
+	})
+}
+
+// Shutdown прекращает прием метрик, закрывает открытые TCP соединения
+// и сохраняет накопленные агрегаты. Если контекст завершится раньше, возвращается его ошибка
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.lines.Shutdown(ctx)
+}
+
+func (s *Server) handlePacket(packet []byte) {
+	for _, line := range bytes.Split(packet, []byte("\n")) {
+		s.handleLine(string(line))
+	}
+}
+
+func (s *Server) handleLine(line string) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+	smpl, err := parseLine(line)
+	if err != nil {
+		log.Debug().Msgf("Skipping bad StatsD line %q: %s", line, err)
+		return
+	}
+	s.agg.add(smpl)
+}
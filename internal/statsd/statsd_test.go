@@ -0,0 +1,169 @@
+package statsd
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vleukhin/prom-light/internal/metrics"
+	"github.com/vleukhin/prom-light/internal/storage"
+)
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    sample
+		wantErr bool
+	}{
+		{
+			name: "Counter",
+			line: "requests:1|c",
+			want: sample{name: "requests", typ: counterType, value: 1, raw: "1", rate: 1},
+		},
+		{
+			name: "Counter with sample rate",
+			line: "requests:2|c|@0.5",
+			want: sample{name: "requests", typ: counterType, value: 2, raw: "2", rate: 0.5},
+		},
+		{
+			name: "Gauge with tags",
+			line: "temperature:3.2|g|#host:a,dc:eu",
+			want: sample{name: "temperature", typ: gaugeType, value: 3.2, raw: "3.2", rate: 1, labels: metrics.Labels{"host": "a", "dc": "eu"}},
+		},
+		{
+			name: "Relative gauge",
+			line: "queue:-4|g",
+			want: sample{name: "queue", typ: gaugeType, value: -4, raw: "-4", rate: 1, relative: true},
+		},
+		{
+			name: "Timer",
+			line: "latency:320|ms|@0.1",
+			want: sample{name: "latency", typ: timerType, value: 320, raw: "320", rate: 0.1},
+		},
+		{
+			name: "Set",
+			line: "users:alice|s",
+			want: sample{name: "users", typ: setType, raw: "alice", rate: 1},
+		},
+		{
+			name:    "Missing type",
+			line:    "requests:1",
+			wantErr: true,
+		},
+		{
+			name:    "Unknown type",
+			line:    "requests:1|x",
+			wantErr: true,
+		},
+		{
+			name:    "Bad value",
+			line:    "requests:abc|c",
+			wantErr: true,
+		},
+		{
+			name:    "Bad sample rate",
+			line:    "requests:1|c|@2",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseLine(tt.line)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestAggregator_Flush(t *testing.T) {
+	agg := newAggregator()
+	for _, line := range []string{
+		"requests:1|c",
+		"requests:1|c|@0.5",
+		"queue:10|g",
+		"queue:-4|g",
+		"latency:10|ms",
+		"latency:20|ms",
+		"latency:30|ms|@0.5",
+		"users:alice|s",
+		"users:bob|s",
+		"users:alice|s",
+	} {
+		s, err := parseLine(line)
+		require.NoError(t, err)
+		agg.add(s)
+	}
+
+	got := make(map[string]string)
+	for _, m := range agg.flush() {
+		got[m.ID()] = m.Type + ":" + m.String()
+	}
+	assert.Equal(t, map[string]string{
+		"requests": "counter:3",
+		"queue":    "gauge:6.000",
+		"latency":  "histogram:[5=0,10=1,25=2,50=4,100=4,250=4,500=4,1000=4,2500=4,5000=4,10000=4] sum=90 count=4",
+		"users":    "gauge:2.000",
+	}, got)
+
+	t.Run("Next interval", func(t *testing.T) {
+		assert.Empty(t, agg.flush())
+
+		s, err := parseLine("queue:+1|g")
+		require.NoError(t, err)
+		agg.add(s)
+
+		mtrcs := agg.flush()
+		require.Len(t, mtrcs, 1)
+		assert.Equal(t, metrics.Gauge(7), *mtrcs[0].Value)
+	})
+}
+
+func TestServer(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMockStorage()
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server := NewServer("", "", 10*time.Millisecond, store)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- server.Serve(udp, listener)
+	}()
+
+	client, err := net.Dial("udp", udp.LocalAddr().String())
+	require.NoError(t, err)
+	_, err = client.Write([]byte("requests:1|c\nrequests:2|c\ntemperature:21.5|g|#room:kitchen"))
+	require.NoError(t, err)
+	require.NoError(t, client.Close())
+
+	tcp, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	_, err = tcp.Write([]byte("requests:4|c\n"))
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		value, err := store.GetCounter(ctx, "requests", nil)
+		return err == nil && value == 7
+	}, time.Second, 5*time.Millisecond)
+
+	// Соединение остается открытым: Shutdown закрывает его и сохраняет принятые метрики
+	require.NoError(t, server.Shutdown(ctx))
+	require.ErrorIs(t, <-errCh, ErrServerClosed)
+	require.NoError(t, server.Shutdown(ctx), "repeated Shutdown")
+	_ = tcp.Close()
+
+	store.AssertCounterStoredWithValue(t, "requests", 7)
+	store.AssertGaugeStoredWithValue(t, `temperature{room="kitchen"}`, 21.5)
+}
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"time"
 
 	"github.com/vleukhin/prom-light/internal/metrics"
 )
@@ -15,6 +16,8 @@ type AllMetrics struct {
 type MetricsStorage interface {
 	MetricsGetter
 	MetricsSetter
+	MetricsRangeGetter
+	MetricsExpirer
 	Ping(ctx context.Context) error
 	ShutDown(ctx context.Context) error
 	CleanUp(ctx context.Context) error
@@ -23,14 +26,40 @@ type MetricsStorage interface {
 
 // MetricsGetter описывает интерфейс получения метрик
 type MetricsGetter interface {
-	GetGauge(ctx context.Context, metricName string) (metrics.Gauge, error)
-	GetCounter(ctx context.Context, metricName string) (metrics.Counter, error)
+	// GetGauge и GetCounter возвращают ErrMetricNotFound, если серии нет в хранилище
+	GetGauge(ctx context.Context, metricName string, labels metrics.Labels) (metrics.Gauge, error)
+	GetCounter(ctx context.Context, metricName string, labels metrics.Labels) (metrics.Counter, error)
+	GetHistogram(ctx context.Context, metricName string, labels metrics.Labels) (metrics.Histogram, error)
+	GetSummary(ctx context.Context, metricName string, labels metrics.Labels) (metrics.Summary, error)
 	GetAllMetrics(ctx context.Context) (metrics.Metrics, error)
+	// QueryMetrics выдает страницу метрик, подходящих под фильтр запроса.
+	// Для некорректного запроса возвращает ошибку ErrInvalidQuery
+	QueryMetrics(ctx context.Context, q MetricsQuery) (MetricsPage, error)
+}
+
+// MetricsRangeGetter описывает интерфейс получения истории значений метрик
+type MetricsRangeGetter interface {
+	// GetRange возвращает значения всех серий метрики с именем metricName
+	// за период [from, to], упорядоченные по времени
+	GetRange(ctx context.Context, metricName string, from, to time.Time) (metrics.Metrics, error)
+}
+
+// MetricsExpirer описывает интерфейс удаления устаревших серий
+type MetricsExpirer interface {
+	// DeleteStale удаляет серии, которые на момент now не обновлялись дольше времени жизни
+	// по политике policy, вместе с их историей, и выдает количество удаленных серий
+	DeleteStale(ctx context.Context, policy StalenessPolicy, now time.Time) (int, error)
 }
 
 // MetricsSetter описывает интерфейс сохранения
 type MetricsSetter interface {
 	SetMetrics(ctx context.Context, mtrcs metrics.Metrics) error
 	SetMetric(ctx context.Context, m metrics.Metric) error
-	IncCounter(ctx context.Context, metricName string, value metrics.Counter) error
+	IncCounter(ctx context.Context, metricName string, labels metrics.Labels, value metrics.Counter) error
+	// DeleteMetric удаляет все серии метрики вместе с историей.
+	// Если метрики нет в хранилище, возвращает ErrMetricNotFound
+	DeleteMetric(ctx context.Context, metricType string, metricName string) error
+	// DeleteByMatcher удаляет все серии, подходящие под фильтр, и выдает их количество.
+	// Пустой фильтр отклоняется с ошибкой ErrEmptyMatcher
+	DeleteByMatcher(ctx context.Context, m Matcher) (int, error)
 }
@@ -2,8 +2,8 @@ package storage
 
 import (
 	"context"
-	"encoding/json"
-	"io"
+	"errors"
+	"fmt"
 	"os"
 	"sync"
 	"time"
@@ -19,48 +19,98 @@ type fileStorage struct {
 	syncMode    bool
 	memStorage  *memoryStorage
 	storeTicker *time.Ticker
+	// wal журнал изменений в режиме WAL, nil - каждое изменение переписывает снимок целиком
+	wal *walLog
 }
 
-func NewFileStorage(fileName string, storeInterval time.Duration, restore bool) (*fileStorage, error) {
-	storage := fileStorage{
+// NewFileStorage создает хранилище метрик с сохранением в файл.
+// История значений за время retention хранится только в памяти и в файл не сохраняется
+func NewFileStorage(fileName string, storeInterval time.Duration, restore bool, retention time.Duration) (*fileStorage, error) {
+	storage := &fileStorage{
 		fileName:   fileName,
-		memStorage: NewMemoryStorage(),
+		memStorage: NewMemoryStorage(retention),
 		mutex:      sync.Mutex{},
 		syncMode:   true,
 	}
+	if err := storage.init(restore); err != nil {
+		return nil, err
+	}
+
+	if storeInterval != 0 {
+		storage.syncMode = false
+		storage.startTicker(storeInterval)
+	}
+
+	return storage, nil
+}
 
+// NewWALFileStorage создает хранилище метрик с сохранением в файл в режиме WAL.
+// Каждое изменение дописывается в журнал рядом с файлом снимка, а раз в checkpointInterval
+// журнал сворачивается в снимок
+func NewWALFileStorage(fileName string, checkpointInterval time.Duration, restore bool, retention time.Duration) (*fileStorage, error) {
+	if checkpointInterval <= 0 {
+		return nil, errors.New("WAL checkpoint interval must be positive")
+	}
+	wal, err := openWAL(fileName + ".wal")
+	if err != nil {
+		log.Error().Str("file", fileName+".wal").Msg("Failed to open WAL")
+		return nil, err
+	}
+
+	storage := &fileStorage{
+		fileName:   fileName,
+		memStorage: NewMemoryStorage(retention),
+		mutex:      sync.Mutex{},
+		wal:        wal,
+	}
+	if err := storage.init(restore); err != nil {
+		_ = wal.close()
+		return nil, err
+	}
+	if !restore {
+		// Записи журнала и снимки от прошлого запуска не нужны: номера записей начинаются
+		// заново, и старые данные не должны смешаться с новыми при следующем восстановлении
+		if err := storage.discardPrevious(); err != nil {
+			_ = wal.close()
+			return nil, err
+		}
+	}
+
+	storage.startTicker(checkpointInterval)
+
+	return storage, nil
+}
+
+func (s *fileStorage) init(restore bool) error {
 	// Try to open file here just to check that everything is ok,
 	// because if something's wrong it's better to know about that now,
 	// on start up, than later when we already have collected data
-	f, err := storage.openFile()
+	f, err := s.openFile()
 	if err != nil {
-		return nil, err
+		return err
 	}
 	if err = f.Close(); err != nil {
-		return nil, err
+		return err
 	}
 
 	if restore {
-		if err := storage.RestoreData(); err != nil {
-			return nil, err
-		}
+		return s.RestoreData()
 	}
 
-	if storeInterval != 0 {
-		storage.syncMode = false
-		storage.storeTicker = time.NewTicker(storeInterval)
-		go func() {
-			for {
-				<-storage.storeTicker.C
-				err := storage.StoreData()
-				if err != nil {
-					log.Error().Msg("Failed to store data to file: " + err.Error())
-				}
-			}
-		}()
-	}
+	return nil
+}
 
-	return &storage, nil
+func (s *fileStorage) startTicker(interval time.Duration) {
+	s.storeTicker = time.NewTicker(interval)
+	go func() {
+		for {
+			<-s.storeTicker.C
+			err := s.StoreData()
+			if err != nil {
+				log.Error().Msg("Failed to store data to file: " + err.Error())
+			}
+		}
+	}()
 }
 
 func (s *fileStorage) openFile() (*os.File, error) {
@@ -72,56 +122,135 @@ func (s *fileStorage) openFile() (*os.File, error) {
 	return f, err
 }
 
+// discardPrevious очищает журнал и записывает пустой снимок вместо данных прошлого запуска
+func (s *fileStorage) discardPrevious() error {
+	if err := s.wal.reset(); err != nil {
+		return err
+	}
+	if err := s.StoreData(); err != nil {
+		return err
+	}
+	if err := os.Remove(s.prevFileName()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// prevFileName файл с предыдущим снимком, из которого данные восстанавливаются,
+// если основной файл поврежден
+func (s *fileStorage) prevFileName() string {
+	return s.fileName + ".prev"
+}
+
+// StoreData атомарно записывает снимок хранилища в файл. В режиме WAL это контрольная точка:
+// снимок запоминает номер последней записи журнала, после чего начинается новый сегмент журнала.
+// Предыдущий сегмент хранится до следующей контрольной точки, чтобы предыдущий снимок вместе
+// с журналом тоже содержал все изменения
 func (s *fileStorage) StoreData() error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	f, err := s.openFile()
+	// Время обновления серий сохраняется в Timestamp, чтобы после восстановления серии продолжали устаревать
+	data, err := s.memStorage.snapshot(context.Background())
 	if err != nil {
 		return err
 	}
-
-	data, err := s.memStorage.GetAllMetrics(context.Background())
+	var lsn uint64
+	if s.wal != nil {
+		lsn = s.wal.lsn
+	}
+	raw, err := encodeSnapshot(data, lsn)
 	if err != nil {
 		return err
 	}
 
-	err = json.NewEncoder(f).Encode(data)
-	if err != nil {
+	if err := writeFileAtomic(s.fileName, s.prevFileName(), raw); err != nil {
+		log.Error().Str("file", s.fileName).Msg("Failed to store data")
 		return err
-	} else {
-		log.Info().Msg("Data stored to file successfully")
 	}
+	// Если новый сегмент не начнется из-за сбоя, записи текущего сегмента будут пропущены
+	// при восстановлении по номеру в снимке
+	if s.wal != nil {
+		if err := s.wal.rotate(); err != nil {
+			log.Error().Str("file", s.wal.file.Name()).Msg("Failed to rotate WAL")
+			return err
+		}
+	}
+	log.Info().Msg("Data stored to file successfully")
 
 	return nil
 }
 
+// readSnapshot читает последний целый снимок и номер последней попавшей в него записи WAL.
+// Если основной файл пуст или поврежден, используется предыдущий снимок. В режиме WAL
+// изменения после него восстанавливаются из предыдущего и текущего сегментов журнала
+func (s *fileStorage) readSnapshot() (metrics.Metrics, uint64, error) {
+	var lastErr error
+	for _, name := range []string{s.fileName, s.prevFileName()} {
+		raw, err := os.ReadFile(name)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+
+		data, lsn, err := decodeSnapshot(raw)
+		if err == nil {
+			if name != s.fileName {
+				log.Warn().Str("file", name).Msg("Data restored from previous snapshot")
+			}
+			return data, lsn, nil
+		}
+		if !errors.Is(err, errEmptySnapshot) {
+			log.Error().Err(err).Str("file", name).Msg("Skipping corrupted snapshot")
+			lastErr = err
+		}
+	}
+
+	return nil, 0, lastErr
+}
+
+// RestoreData восстанавливает данные из снимка, а в режиме WAL применяет поверх него
+// записи журнала, сделанные после снимка
 func (s *fileStorage) RestoreData() error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
-	var data []metrics.Metric
 
-	f, err := s.openFile()
+	data, lsn, err := s.readSnapshot()
 	if err != nil {
-		return err
-	}
-	err = json.NewDecoder(f).Decode(&data)
-	if err != nil && err != io.EOF {
 		log.Error().Msg("Failed to restore data")
 		return err
 	}
 
 	for _, m := range data {
-		if m.IsCounter() {
-			err := s.memStorage.IncCounter(context.Background(), m.Name, *m.Delta)
+		switch m.Type {
+		case metrics.CounterTypeName:
+			err := s.memStorage.IncCounter(context.Background(), m.Name, m.Labels, *m.Delta)
 			if err != nil {
 				return err
 			}
-		} else {
-			err := s.memStorage.SetGauge(context.Background(), m.Name, *m.Value)
+		case metrics.GaugeTypeName:
+			err := s.memStorage.SetGauge(context.Background(), m.Name, m.Labels, *m.Value)
 			if err != nil {
 				return err
 			}
+		default:
+			err := s.memStorage.SetMetric(context.Background(), m)
+			if err != nil {
+				return err
+			}
+		}
+		if m.Timestamp != nil {
+			s.memStorage.setUpdated(m, *m.Timestamp)
+		}
+	}
+
+	if s.wal != nil {
+		if err := s.wal.replay(lsn, s.applyWALEntry); err != nil {
+			log.Error().Msg("Failed to replay WAL")
+			return err
 		}
 	}
 
@@ -130,6 +259,24 @@ func (s *fileStorage) RestoreData() error {
 	return nil
 }
 
+func (s *fileStorage) applyWALEntry(e walEntry) error {
+	switch e.Op {
+	case walOpSet:
+		for _, m := range e.Metrics {
+			if err := s.memStorage.SetMetric(context.Background(), m); err != nil {
+				return err
+			}
+			s.memStorage.setUpdated(m, e.At)
+		}
+	case walOpDelete:
+		s.memStorage.deleteSeries(e.Metrics)
+	default:
+		return fmt.Errorf("unknown WAL operation %q", e.Op)
+	}
+
+	return nil
+}
+
 func (s *fileStorage) ShutDown(_ context.Context) error {
 	if err := s.StoreData(); err != nil {
 		return err
@@ -138,11 +285,53 @@ func (s *fileStorage) ShutDown(_ context.Context) error {
 	if !s.syncMode {
 		s.storeTicker.Stop()
 	}
+	if s.wal != nil {
+		return s.wal.close()
+	}
 
 	return nil
 }
 
+// logSet применяет метрики и записывает примененные в журнал. Если метрика не прошла
+// проверку, в журнал попадают метрики до нее, чтобы журнал совпадал с памятью
+func (s *fileStorage) logSet(ctx context.Context, mtrcs metrics.Metrics) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	applied := 0
+	var setErr error
+	for _, m := range mtrcs {
+		if setErr = s.memStorage.SetMetric(ctx, m); setErr != nil {
+			break
+		}
+		applied++
+	}
+	if applied > 0 {
+		if err := s.wal.append(walEntry{Op: walOpSet, At: time.Now(), Metrics: mtrcs[:applied]}); err != nil {
+			return err
+		}
+	}
+
+	return setErr
+}
+
+// logDelete удаляет серии и записывает удаленные в журнал
+func (s *fileStorage) logDelete(del func() (metrics.Metrics, error)) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	deleted, err := del()
+	if err != nil || len(deleted) == 0 {
+		return len(deleted), err
+	}
+
+	return len(deleted), s.wal.append(walEntry{Op: walOpDelete, At: time.Now(), Metrics: deleted})
+}
+
 func (s *fileStorage) SetMetrics(ctx context.Context, mtrcs metrics.Metrics) error {
+	if s.wal != nil {
+		return s.logSet(ctx, mtrcs)
+	}
 	if err := s.memStorage.SetMetrics(ctx, mtrcs); err != nil {
 		return err
 	}
@@ -154,6 +343,9 @@ func (s *fileStorage) SetMetrics(ctx context.Context, mtrcs metrics.Metrics) err
 	return nil
 }
 func (s *fileStorage) SetMetric(ctx context.Context, m metrics.Metric) error {
+	if s.wal != nil {
+		return s.logSet(ctx, metrics.Metrics{m})
+	}
 	if err := s.memStorage.SetMetric(ctx, m); err != nil {
 		return err
 	}
@@ -165,22 +357,94 @@ func (s *fileStorage) SetMetric(ctx context.Context, m metrics.Metric) error {
 	return nil
 }
 
-func (s *fileStorage) GetGauge(ctx context.Context, metricName string) (metrics.Gauge, error) {
-	return s.memStorage.GetGauge(ctx, metricName)
+func (s *fileStorage) DeleteMetric(ctx context.Context, metricType string, metricName string) error {
+	if s.wal != nil {
+		deleted, err := s.DeleteByMatcher(ctx, Matcher{Types: []string{metricType}, Name: metricName})
+		if err == nil && deleted == 0 {
+			err = ErrMetricNotFound
+		}
+		return err
+	}
+	if err := s.memStorage.DeleteMetric(ctx, metricType, metricName); err != nil {
+		return err
+	}
+	if s.syncMode {
+		return s.StoreData()
+	}
+	return nil
 }
 
-func (s *fileStorage) GetCounter(ctx context.Context, metricName string) (metrics.Counter, error) {
-	return s.memStorage.GetCounter(ctx, metricName)
+func (s *fileStorage) DeleteByMatcher(ctx context.Context, m Matcher) (int, error) {
+	if s.wal != nil {
+		return s.logDelete(func() (metrics.Metrics, error) {
+			return s.memStorage.deleteMatching(m)
+		})
+	}
+	deleted, err := s.memStorage.DeleteByMatcher(ctx, m)
+	if err != nil || deleted == 0 {
+		return deleted, err
+	}
+	if s.syncMode {
+		return deleted, s.StoreData()
+	}
+	return deleted, nil
 }
 
-func (s *fileStorage) IncCounter(ctx context.Context, metricName string, value metrics.Counter) error {
-	return s.memStorage.IncCounter(ctx, metricName, value)
+// DeleteStale удаляет устаревшие серии. В режиме с периодическим сохранением
+// удаление попадет в файл при следующем StoreData
+func (s *fileStorage) DeleteStale(ctx context.Context, policy StalenessPolicy, now time.Time) (int, error) {
+	if s.wal != nil {
+		return s.logDelete(func() (metrics.Metrics, error) {
+			return s.memStorage.deleteStale(policy, now), nil
+		})
+	}
+	deleted, err := s.memStorage.DeleteStale(ctx, policy, now)
+	if err != nil || deleted == 0 {
+		return deleted, err
+	}
+	if s.syncMode {
+		return deleted, s.StoreData()
+	}
+	return deleted, nil
+}
+
+func (s *fileStorage) GetGauge(ctx context.Context, metricName string, labels metrics.Labels) (metrics.Gauge, error) {
+	return s.memStorage.GetGauge(ctx, metricName, labels)
+}
+
+func (s *fileStorage) GetCounter(ctx context.Context, metricName string, labels metrics.Labels) (metrics.Counter, error) {
+	return s.memStorage.GetCounter(ctx, metricName, labels)
+}
+
+func (s *fileStorage) GetHistogram(ctx context.Context, metricName string, labels metrics.Labels) (metrics.Histogram, error) {
+	return s.memStorage.GetHistogram(ctx, metricName, labels)
+}
+
+func (s *fileStorage) GetSummary(ctx context.Context, metricName string, labels metrics.Labels) (metrics.Summary, error) {
+	return s.memStorage.GetSummary(ctx, metricName, labels)
+}
+
+func (s *fileStorage) IncCounter(ctx context.Context, metricName string, labels metrics.Labels, value metrics.Counter) error {
+	if s.wal != nil {
+		m := metrics.MakeCounterMetric(metricName, value)
+		m.Labels = labels
+		return s.logSet(ctx, metrics.Metrics{m})
+	}
+	return s.memStorage.IncCounter(ctx, metricName, labels, value)
 }
 
 func (s *fileStorage) GetAllMetrics(ctx context.Context) (metrics.Metrics, error) {
 	return s.memStorage.GetAllMetrics(ctx)
 }
 
+func (s *fileStorage) QueryMetrics(ctx context.Context, q MetricsQuery) (MetricsPage, error) {
+	return s.memStorage.QueryMetrics(ctx, q)
+}
+
+func (s *fileStorage) GetRange(ctx context.Context, metricName string, from, to time.Time) (metrics.Metrics, error) {
+	return s.memStorage.GetRange(ctx, metricName, from, to)
+}
+
 func (s *fileStorage) Ping(context.Context) error {
 	f, err := s.openFile()
 	if err != nil {
@@ -190,14 +454,24 @@ func (s *fileStorage) Ping(context.Context) error {
 	return f.Close()
 }
 
-func (s *fileStorage) CleanUp(_ context.Context) error {
+func (s *fileStorage) CleanUp(ctx context.Context) error {
 	err := os.Truncate(s.fileName, 0)
 	if err != nil {
 		return err
 	}
-	s.memStorage.gaugeMetrics = make(map[string]metrics.Gauge)
-	s.memStorage.counterMetrics = make(map[string]metrics.Counter)
-	return nil
+	err = os.Remove(s.prevFileName())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if s.wal != nil {
+		s.mutex.Lock()
+		err = s.wal.reset()
+		s.mutex.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return s.memStorage.CleanUp(ctx)
 }
 
 func (s *fileStorage) Migrate(_ context.Context) error {
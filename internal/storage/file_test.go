@@ -1,14 +1,20 @@
 package storage
 
 import (
+	"bytes"
 	"context"
+	"fmt"
+	"os"
+	"reflect"
 	"testing"
 	"time"
+
+	"github.com/vleukhin/prom-light/internal/metrics"
 )
 
 func TestFileStorage(t *testing.T) {
 	ctx := context.Background()
-	storage, err := NewFileStorage("/tmp/metrics_tests", 5*time.Second, false)
+	storage, err := NewFileStorage("/tmp/metrics_tests", 5*time.Second, false, time.Hour)
 	if err != nil {
 		panic(err)
 	}
@@ -20,3 +26,379 @@ func TestFileStorage(t *testing.T) {
 		panic(err)
 	}
 }
+
+func TestFileStorage_RestoreHistogram(t *testing.T) {
+	ctx := context.Background()
+	fileName := t.TempDir() + "/metrics.json"
+	storage, err := NewFileStorage(fileName, 0, false, 0)
+	if err != nil {
+		t.Fatalf("NewFileStorage() error = %v", err)
+	}
+
+	h := metrics.NewHistogram([]float64{1, 10})
+	h.Observe(5, 2)
+	summary := metrics.Summary{Quantiles: []metrics.Quantile{{Quantile: 0.5, Value: 3}}, Sum: 6, Count: 2}
+	err = storage.SetMetrics(ctx, metrics.Metrics{
+		metrics.MakeHistogramMetric("latency", h),
+		metrics.MakeSummaryMetric("duration", summary),
+	})
+	if err != nil {
+		t.Fatalf("SetMetrics() error = %v", err)
+	}
+
+	restored, err := NewFileStorage(fileName, 0, true, 0)
+	if err != nil {
+		t.Fatalf("NewFileStorage() error = %v", err)
+	}
+	storedHistogram, err := restored.GetHistogram(ctx, "latency", nil)
+	if err != nil || !reflect.DeepEqual(storedHistogram, h) {
+		t.Errorf("GetHistogram() = %v, %v; want %v", storedHistogram, err, h)
+	}
+	storedSummary, err := restored.GetSummary(ctx, "duration", nil)
+	if err != nil || !reflect.DeepEqual(storedSummary, summary) {
+		t.Errorf("GetSummary() = %v, %v; want %v", storedSummary, err, summary)
+	}
+}
+
+func TestFileStorage_RestoreUpdatedAt(t *testing.T) {
+	ctx := context.Background()
+	fileName := t.TempDir() + "/metrics.json"
+	storage, err := NewFileStorage(fileName, 0, false, 0)
+	if err != nil {
+		t.Fatalf("NewFileStorage() error = %v", err)
+	}
+	if err := storage.SetMetric(ctx, metrics.MakeGaugeMetric("Alloc", 1)); err != nil {
+		t.Fatalf("SetMetric() error = %v", err)
+	}
+	updated := time.Now()
+
+	restored, err := NewFileStorage(fileName, 0, true, 0)
+	if err != nil {
+		t.Fatalf("NewFileStorage() error = %v", err)
+	}
+	policy := StalenessPolicy{TTL: time.Minute}
+	// Восстановленная серия устаревает по времени последнего обновления, а не восстановления
+	deleted, err := restored.DeleteStale(ctx, policy, updated.Add(2*time.Minute))
+	if err != nil || deleted != 1 {
+		t.Fatalf("DeleteStale() = %v, %v; want 1 deleted", deleted, err)
+	}
+
+	restoredAgain, err := NewFileStorage(fileName, 0, true, 0)
+	if err != nil {
+		t.Fatalf("NewFileStorage() error = %v", err)
+	}
+	all, err := restoredAgain.GetAllMetrics(ctx)
+	if err != nil || len(all) != 0 {
+		t.Errorf("GetAllMetrics() = %v, %v; want deletion persisted", all, err)
+	}
+}
+
+func TestFileStorage_CrashSafety(t *testing.T) {
+	ctx := context.Background()
+
+	// store сохраняет в новый файл два снимка: с Alloc=1 и с Alloc=2
+	store := func(t *testing.T) string {
+		fileName := t.TempDir() + "/metrics.json"
+		storage, err := NewFileStorage(fileName, 0, false, 0)
+		if err != nil {
+			t.Fatalf("NewFileStorage() error = %v", err)
+		}
+		for _, v := range []metrics.Gauge{1, 2} {
+			if err := storage.SetMetric(ctx, metrics.MakeGaugeMetric("Alloc", v)); err != nil {
+				t.Fatalf("SetMetric() error = %v", err)
+			}
+		}
+		return fileName
+	}
+	restore := func(t *testing.T, fileName string) (metrics.Gauge, error) {
+		storage, err := NewFileStorage(fileName, 0, true, 0)
+		if err != nil {
+			return 0, err
+		}
+		return storage.GetGauge(ctx, "Alloc", nil)
+	}
+	truncate := func(t *testing.T, name string, size int64) {
+		if err := os.Truncate(name, size); err != nil {
+			t.Fatalf("Truncate() error = %v", err)
+		}
+	}
+
+	t.Run("Shorter snapshot", func(t *testing.T) {
+		fileName := t.TempDir() + "/metrics.json"
+		storage, err := NewFileStorage(fileName, 0, false, 0)
+		if err != nil {
+			t.Fatalf("NewFileStorage() error = %v", err)
+		}
+		for _, name := range []string{"HeapAlloc", "HeapInuse", "HeapObjects"} {
+			if err := storage.SetMetric(ctx, metrics.MakeGaugeMetric(name, 1)); err != nil {
+				t.Fatalf("SetMetric() error = %v", err)
+			}
+		}
+		if _, err := storage.DeleteByMatcher(ctx, Matcher{NamePrefix: "Heap"}); err != nil {
+			t.Fatalf("DeleteByMatcher() error = %v", err)
+		}
+		if err := storage.SetMetric(ctx, metrics.MakeGaugeMetric("Alloc", 2)); err != nil {
+			t.Fatalf("SetMetric() error = %v", err)
+		}
+
+		if v, err := restore(t, fileName); err != nil || v != 2 {
+			t.Errorf("restore() = %v, %v; want %v", v, err, 2)
+		}
+	})
+
+	t.Run("Truncated snapshot", func(t *testing.T) {
+		// Размер снимка зависит от длины времени обновления, поэтому считается для каждого файла
+		for _, cut := range []func(size int64) int64{
+			func(size int64) int64 { return size - 2 },
+			func(size int64) int64 { return size / 2 },
+			func(int64) int64 { return 0 },
+		} {
+			fileName := store(t)
+			info, err := os.Stat(fileName)
+			if err != nil {
+				t.Fatalf("Stat() error = %v", err)
+			}
+			size := cut(info.Size())
+			truncate(t, fileName, size)
+			if v, err := restore(t, fileName); err != nil || v != 1 {
+				t.Errorf("restore() truncated to %d = %v, %v; want previous snapshot %v", size, v, err, 1)
+			}
+		}
+	})
+
+	t.Run("Corrupted checksum", func(t *testing.T) {
+		fileName := store(t)
+		raw, err := os.ReadFile(fileName)
+		if err != nil {
+			t.Fatalf("ReadFile() error = %v", err)
+		}
+		raw = bytes.Replace(raw, []byte(`"value":2`), []byte(`"value":3`), 1)
+		if err := os.WriteFile(fileName, raw, 0644); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+		if v, err := restore(t, fileName); err != nil || v != 1 {
+			t.Errorf("restore() = %v, %v; want previous snapshot %v", v, err, 1)
+		}
+	})
+
+	t.Run("Crash between renames", func(t *testing.T) {
+		fileName := store(t)
+		if err := os.Remove(fileName); err != nil {
+			t.Fatalf("Remove() error = %v", err)
+		}
+		if v, err := restore(t, fileName); err != nil || v != 1 {
+			t.Errorf("restore() = %v, %v; want previous snapshot %v", v, err, 1)
+		}
+	})
+
+	t.Run("All snapshots corrupted", func(t *testing.T) {
+		fileName := store(t)
+		truncate(t, fileName, 5)
+		truncate(t, fileName+".prev", 5)
+		if _, err := restore(t, fileName); err == nil {
+			t.Errorf("restore() expected error")
+		}
+	})
+
+	t.Run("Snapshot without checksum", func(t *testing.T) {
+		fileName := t.TempDir() + "/metrics.json"
+		legacy := `[{"id":"Alloc","type":"gauge","value":4}]` + "\n" + `garbage from a longer snapshot`
+		if err := os.WriteFile(fileName, []byte(legacy), 0644); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+		if v, err := restore(t, fileName); err != nil || v != 4 {
+			t.Errorf("restore() = %v, %v; want %v", v, err, 4)
+		}
+	})
+}
+
+func TestWALFileStorage(t *testing.T) {
+	ctx := context.Background()
+	storage, err := NewWALFileStorage(t.TempDir()+"/metrics.json", time.Hour, false, time.Hour)
+	if err != nil {
+		t.Fatalf("NewWALFileStorage() error = %v", err)
+	}
+	testStorage(storage, t)
+	if err := storage.CleanUp(ctx); err != nil {
+		t.Fatalf("CleanUp() error = %v", err)
+	}
+	if err := storage.ShutDown(ctx); err != nil {
+		t.Fatalf("ShutDown() error = %v", err)
+	}
+}
+
+func TestWALFileStorage_Restore(t *testing.T) {
+	ctx := context.Background()
+
+	// write пишет в журнал Alloc=1, PollCount=2+3 и удаляет HeapAlloc. Хранилище
+	// не останавливается, как при аварийном завершении
+	write := func(t *testing.T, fileName string, restore bool) *fileStorage {
+		storage, err := NewWALFileStorage(fileName, time.Hour, restore, 0)
+		if err != nil {
+			t.Fatalf("NewWALFileStorage() error = %v", err)
+		}
+		err = storage.SetMetrics(ctx, metrics.Metrics{
+			metrics.MakeGaugeMetric("Alloc", 1),
+			metrics.MakeGaugeMetric("HeapAlloc", 1),
+			metrics.MakeCounterMetric("PollCount", 2),
+		})
+		if err != nil {
+			t.Fatalf("SetMetrics() error = %v", err)
+		}
+		if err := storage.IncCounter(ctx, "PollCount", nil, 3); err != nil {
+			t.Fatalf("IncCounter() error = %v", err)
+		}
+		if err := storage.DeleteMetric(ctx, metrics.GaugeTypeName, "HeapAlloc"); err != nil {
+			t.Fatalf("DeleteMetric() error = %v", err)
+		}
+		return storage
+	}
+	assertRestored := func(t *testing.T, fileName string, wantCounter metrics.Counter) *fileStorage {
+		storage, err := NewWALFileStorage(fileName, time.Hour, true, 0)
+		if err != nil {
+			t.Fatalf("NewWALFileStorage() error = %v", err)
+		}
+		if v, err := storage.GetGauge(ctx, "Alloc", nil); err != nil || v != 1 {
+			t.Errorf("GetGauge() = %v, %v; want %v", v, err, 1)
+		}
+		if v, err := storage.GetCounter(ctx, "PollCount", nil); err != nil || v != wantCounter {
+			t.Errorf("GetCounter() = %v, %v; want %v", v, err, wantCounter)
+		}
+		if _, err := storage.GetGauge(ctx, "HeapAlloc", nil); err == nil {
+			t.Errorf("GetGauge() deleted metric restored")
+		}
+		return storage
+	}
+
+	t.Run("Replay", func(t *testing.T) {
+		fileName := t.TempDir() + "/metrics.json"
+		write(t, fileName, false)
+		assertRestored(t, fileName, 5)
+	})
+
+	t.Run("Replay after checkpoint", func(t *testing.T) {
+		fileName := t.TempDir() + "/metrics.json"
+		storage := write(t, fileName, false)
+		if err := storage.StoreData(); err != nil {
+			t.Fatalf("StoreData() error = %v", err)
+		}
+		if err := storage.IncCounter(ctx, "PollCount", nil, 1); err != nil {
+			t.Fatalf("IncCounter() error = %v", err)
+		}
+		assertRestored(t, fileName, 6)
+	})
+
+	t.Run("Crash before WAL rotation", func(t *testing.T) {
+		fileName := t.TempDir() + "/metrics.json"
+		storage := write(t, fileName, false)
+		wal, err := os.ReadFile(fileName + ".wal")
+		if err != nil {
+			t.Fatalf("ReadFile() error = %v", err)
+		}
+		if err := storage.StoreData(); err != nil {
+			t.Fatalf("StoreData() error = %v", err)
+		}
+		if err := os.WriteFile(fileName+".wal", wal, 0644); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+		assertRestored(t, fileName, 5)
+	})
+
+	// checkpoints пишет в журнал Alloc=1, PollCount=2+3+1+1 с двумя контрольными точками
+	// и портит последний снимок
+	checkpoints := func(t *testing.T) string {
+		fileName := t.TempDir() + "/metrics.json"
+		storage := write(t, fileName, false)
+		for i := 0; i < 2; i++ {
+			if err := storage.StoreData(); err != nil {
+				t.Fatalf("StoreData() error = %v", err)
+			}
+			if err := storage.IncCounter(ctx, "PollCount", nil, 1); err != nil {
+				t.Fatalf("IncCounter() error = %v", err)
+			}
+		}
+		if err := os.Truncate(fileName, 5); err != nil {
+			t.Fatalf("Truncate() error = %v", err)
+		}
+		return fileName
+	}
+
+	t.Run("Previous snapshot", func(t *testing.T) {
+		fileName := checkpoints(t)
+		assertRestored(t, fileName, 7)
+	})
+
+	t.Run("Previous snapshot without WAL segment", func(t *testing.T) {
+		fileName := checkpoints(t)
+		if err := os.Remove(fileName + ".wal.prev"); err != nil {
+			t.Fatalf("Remove() error = %v", err)
+		}
+		if _, err := NewWALFileStorage(fileName, time.Hour, true, 0); err == nil {
+			t.Errorf("NewWALFileStorage() expected error")
+		}
+	})
+
+	t.Run("Torn tail", func(t *testing.T) {
+		fileName := t.TempDir() + "/metrics.json"
+		write(t, fileName, false)
+		f, err := os.OpenFile(fileName+".wal", os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			t.Fatalf("OpenFile() error = %v", err)
+		}
+		if _, err := f.Write([]byte{0, 0, 0, 42, 1, 2}); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatalf("Close() error = %v", err)
+		}
+
+		storage := assertRestored(t, fileName, 5)
+		if err := storage.IncCounter(ctx, "PollCount", nil, 1); err != nil {
+			t.Fatalf("IncCounter() error = %v", err)
+		}
+		assertRestored(t, fileName, 6)
+	})
+
+	t.Run("Start without restore", func(t *testing.T) {
+		fileName := t.TempDir() + "/metrics.json"
+		write(t, fileName, false)
+		write(t, fileName, false)
+		assertRestored(t, fileName, 5)
+	})
+}
+
+func BenchmarkFileStorage_SetMetric(b *testing.B) {
+	ctx := context.Background()
+	for _, size := range []int{100, 1000} {
+		storages := map[string]func(fileName string) (*fileStorage, error){
+			"snapshot": func(fileName string) (*fileStorage, error) {
+				return NewFileStorage(fileName, 0, false, 0)
+			},
+			"wal": func(fileName string) (*fileStorage, error) {
+				return NewWALFileStorage(fileName, time.Hour, false, 0)
+			},
+		}
+		for _, mode := range []string{"snapshot", "wal"} {
+			b.Run(fmt.Sprintf("%s/%d", mode, size), func(b *testing.B) {
+				storage, err := storages[mode](b.TempDir() + "/metrics.json")
+				if err != nil {
+					b.Fatalf("create storage error = %v", err)
+				}
+				mtrcs := make(metrics.Metrics, size)
+				for i := range mtrcs {
+					mtrcs[i] = metrics.MakeGaugeMetric(fmt.Sprintf("Gauge%d", i), metrics.Gauge(i))
+				}
+				if err := storage.SetMetrics(ctx, mtrcs); err != nil {
+					b.Fatalf("SetMetrics() error = %v", err)
+				}
+
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if err := storage.SetMetric(ctx, mtrcs[i%size]); err != nil {
+						b.Fatalf("SetMetric() error = %v", err)
+					}
+				}
+			})
+		}
+	}
+}
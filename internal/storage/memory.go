@@ -3,41 +3,140 @@ package storage
 import (
 	"context"
 	"errors"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/vleukhin/prom-light/internal/metrics"
 )
 
 type memoryStorage struct {
-	mutex          sync.Mutex
-	gaugeMetrics   map[string]metrics.Gauge
-	counterMetrics map[string]metrics.Counter
+	mutex            sync.Mutex
+	gaugeMetrics     map[string]metrics.Metric
+	counterMetrics   map[string]metrics.Metric
+	histogramMetrics map[string]metrics.Metric
+	summaryMetrics   map[string]metrics.Metric
+	history          map[string]metrics.Metrics
+	updated          map[string]time.Time
+	retention        time.Duration
 }
 
-func NewMemoryStorage() *memoryStorage {
+// NewMemoryStorage создает хранилище метрик в памяти.
+// retention задает время хранения истории значений, 0 отключает историю
+func NewMemoryStorage(retention time.Duration) *memoryStorage {
 	return &memoryStorage{
-		gaugeMetrics:   make(map[string]metrics.Gauge),
-		counterMetrics: make(map[string]metrics.Counter),
+		gaugeMetrics:     make(map[string]metrics.Metric),
+		counterMetrics:   make(map[string]metrics.Metric),
+		histogramMetrics: make(map[string]metrics.Metric),
+		summaryMetrics:   make(map[string]metrics.Metric),
+		history:          make(map[string]metrics.Metrics),
+		updated:          make(map[string]time.Time),
+		retention:        retention,
 	}
 }
 
-func (s *memoryStorage) SetGauge(_ context.Context, metricName string, value metrics.Gauge) error {
+func (s *memoryStorage) SetGauge(_ context.Context, metricName string, labels metrics.Labels, value metrics.Gauge) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
-	s.gaugeMetrics[metricName] = value
+	s.record(s.setGauge(metricName, labels, value), nil)
 	return nil
 }
-func (s *memoryStorage) IncCounter(_ context.Context, metricName string, value metrics.Counter) error {
+
+func (s *memoryStorage) IncCounter(_ context.Context, metricName string, labels metrics.Labels, value metrics.Counter) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
-	oldValue, ok := s.counterMetrics[metricName]
-	if !ok {
-		oldValue = 0
-	}
-	s.counterMetrics[metricName] = oldValue + value
+	s.record(s.incCounter(metricName, labels, value), nil)
 	return nil
 }
 
+func (s *memoryStorage) setGauge(metricName string, labels metrics.Labels, value metrics.Gauge) metrics.Metric {
+	m := metrics.MakeGaugeMetric(metricName, value)
+	m.Labels = labels.Merge(nil)
+	s.gaugeMetrics[m.ID()] = m
+	s.touch(m)
+	return m
+}
+
+func (s *memoryStorage) incCounter(metricName string, labels metrics.Labels, value metrics.Counter) metrics.Metric {
+	m := metrics.MakeCounterMetric(metricName, value)
+	m.Labels = labels.Merge(nil)
+	if old, ok := s.counterMetrics[m.ID()]; ok {
+		*m.Delta += *old.Delta
+	}
+	s.counterMetrics[m.ID()] = m
+	s.touch(m)
+	return m
+}
+
+// mergeHistogram добавляет наблюдения гистограммы к сохраненным.
+// Если границы корзин изменились, сохраненная гистограмма заменяется
+func (s *memoryStorage) mergeHistogram(metricName string, labels metrics.Labels, h metrics.Histogram) metrics.Metric {
+	m := metrics.MakeHistogramMetric(metricName, h.Clone())
+	m.Labels = labels.Merge(nil)
+	if old, ok := s.histogramMetrics[m.ID()]; ok {
+		merged := old.Histogram.Merge(h)
+		m.Histogram = &merged
+	}
+	s.histogramMetrics[m.ID()] = m
+	s.touch(m)
+	return m
+}
+
+func (s *memoryStorage) setSummary(metricName string, labels metrics.Labels, summary metrics.Summary) metrics.Metric {
+	m := metrics.MakeSummaryMetric(metricName, summary.Clone())
+	m.Labels = labels.Merge(nil)
+	s.summaryMetrics[m.ID()] = m
+	s.touch(m)
+	return m
+}
+
+// seriesKey ключ серии в истории и времени обновления. Серии разных типов могут иметь одинаковый ID
+func seriesKey(m metrics.Metric) string {
+	return m.Type + ":" + m.ID()
+}
+
+// touch запоминает время последнего обновления серии
+func (s *memoryStorage) touch(m metrics.Metric) {
+	s.updated[seriesKey(m)] = time.Now()
+}
+
+// record сохраняет значение серии в историю и удаляет из нее устаревшие значения
+func (s *memoryStorage) record(m metrics.Metric, at *time.Time) {
+	if s.retention == 0 {
+		return
+	}
+
+	now := time.Now()
+	sample := m.Clone()
+	sample.Timestamp = &now
+	if at != nil {
+		ts := *at
+		sample.Timestamp = &ts
+	}
+
+	// Значения с временем из remote_write, очереди агента или Influx приходят не по порядку,
+	// поэтому значение вставляется по времени, чтобы история оставалась отсортированной
+	key := seriesKey(m)
+	samples := s.history[key]
+	pos := sort.Search(len(samples), func(i int) bool {
+		return samples[i].Timestamp.After(*sample.Timestamp)
+	})
+	samples = append(samples, metrics.Metric{})
+	copy(samples[pos+1:], samples[pos:])
+	samples[pos] = sample
+
+	cutoff := now.Add(-s.retention)
+	i := 0
+	for i < len(samples) && samples[i].Timestamp.Before(cutoff) {
+		i++
+	}
+	if i == len(samples) {
+		delete(s.history, key)
+		return
+	}
+	s.history[key] = samples[i:]
+}
+
 func (s *memoryStorage) SetMetric(_ context.Context, m metrics.Metric) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
@@ -47,16 +146,25 @@ func (s *memoryStorage) SetMetric(_ context.Context, m metrics.Metric) error {
 		if m.Value == nil {
 			return errors.New("nil gauge value")
 		}
-		s.gaugeMetrics[m.Name] = *m.Value
+		s.record(s.setGauge(m.Name, m.Labels, *m.Value), m.Timestamp)
 	case metrics.CounterTypeName:
 		if m.Delta == nil {
 			return errors.New("nil counter value")
 		}
-		oldValue, ok := s.counterMetrics[m.Name]
-		if !ok {
-			oldValue = 0
+		s.record(s.incCounter(m.Name, m.Labels, *m.Delta), m.Timestamp)
+	case metrics.HistogramTypeName:
+		if m.Histogram == nil {
+			return errors.New("nil histogram value")
 		}
-		s.counterMetrics[m.Name] = oldValue + *m.Delta
+		if !m.Histogram.IsValid() {
+			return errors.New("invalid histogram buckets")
+		}
+		s.record(s.mergeHistogram(m.Name, m.Labels, *m.Histogram), m.Timestamp)
+	case metrics.SummaryTypeName:
+		if m.Summary == nil {
+			return errors.New("nil summary value")
+		}
+		s.record(s.setSummary(m.Name, m.Labels, *m.Summary), m.Timestamp)
 	}
 
 	return nil
@@ -72,39 +180,198 @@ func (s *memoryStorage) SetMetrics(ctx context.Context, mtrcs metrics.Metrics) e
 	return nil
 }
 
-func (s *memoryStorage) GetGauge(_ context.Context, metricName string) (metrics.Gauge, error) {
+func (s *memoryStorage) DeleteMetric(ctx context.Context, metricType string, metricName string) error {
+	deleted, err := s.DeleteByMatcher(ctx, Matcher{Types: []string{metricType}, Name: metricName})
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrMetricNotFound
+	}
+
+	return nil
+}
+
+func (s *memoryStorage) DeleteByMatcher(_ context.Context, m Matcher) (int, error) {
+	deleted, err := s.deleteMatching(m)
+	return len(deleted), err
+}
+
+// deleteMatching удаляет серии, подходящие под фильтр, и выдает удаленные серии
+func (s *memoryStorage) deleteMatching(m Matcher) (metrics.Metrics, error) {
+	p, err := m.parse()
+	if err != nil {
+		return nil, err
+	}
+	if p.IsEmpty() {
+		return nil, ErrEmptyMatcher
+	}
+
+	return s.deleteWhere(p.matches), nil
+}
+
+// DeleteStale удаляет серии, которые не обновлялись дольше времени жизни по политике policy
+func (s *memoryStorage) DeleteStale(_ context.Context, policy StalenessPolicy, now time.Time) (int, error) {
+	return len(s.deleteStale(policy, now)), nil
+}
+
+func (s *memoryStorage) deleteStale(policy StalenessPolicy, now time.Time) metrics.Metrics {
+	// deleteWhere вызывает match под блокировкой, поэтому updated читается напрямую
+	return s.deleteWhere(func(m metrics.Metric) bool {
+		ttl := policy.TTLFor(m.Type, m.Name)
+		return ttl > 0 && now.Sub(s.updated[seriesKey(m)]) > ttl
+	})
+}
+
+// deleteSeries удаляет перечисленные серии
+func (s *memoryStorage) deleteSeries(series metrics.Metrics) {
+	keys := make(map[string]bool, len(series))
+	for _, m := range series {
+		keys[seriesKey(m)] = true
+	}
+	s.deleteWhere(func(m metrics.Metric) bool {
+		return keys[seriesKey(m)]
+	})
+}
+
+// deleteWhere удаляет серии, для которых match возвращает true, вместе с историей и выдает удаленные серии
+func (s *memoryStorage) deleteWhere(match func(m metrics.Metric) bool) metrics.Metrics {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
-	value, exists := s.gaugeMetrics[metricName]
+	var deleted metrics.Metrics
+	for _, series := range []map[string]metrics.Metric{s.gaugeMetrics, s.counterMetrics, s.histogramMetrics, s.summaryMetrics} {
+		for id, metric := range series {
+			if !match(metric) {
+				continue
+			}
+			delete(series, id)
+			delete(s.history, seriesKey(metric))
+			delete(s.updated, seriesKey(metric))
+			deleted = append(deleted, metrics.Metric{Name: metric.Name, Type: metric.Type, Labels: metric.Labels})
+		}
+	}
+
+	return deleted
+}
+
+// snapshot выдает все метрики с временем последнего обновления серии в Timestamp
+func (s *memoryStorage) snapshot(ctx context.Context) (metrics.Metrics, error) {
+	all, err := s.GetAllMetrics(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i := range all {
+		if updated, ok := s.updated[seriesKey(all[i])]; ok {
+			all[i].Timestamp = &updated
+		}
+	}
+
+	return all, nil
+}
+
+// setUpdated задает время последнего обновления серии, например при восстановлении из файла
+func (s *memoryStorage) setUpdated(m metrics.Metric, at time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.updated[seriesKey(m)] = at
+}
+
+func (s *memoryStorage) GetGauge(_ context.Context, metricName string, labels metrics.Labels) (metrics.Gauge, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	m, exists := s.gaugeMetrics[metrics.Metric{Name: metricName, Labels: labels}.ID()]
+	if !exists {
+		return 0, ErrMetricNotFound
+	}
+
+	return *m.Value, nil
+}
+
+func (s *memoryStorage) GetCounter(_ context.Context, metricName string, labels metrics.Labels) (metrics.Counter, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	m, exists := s.counterMetrics[metrics.Metric{Name: metricName, Labels: labels}.ID()]
+	if !exists {
+		return 0, ErrMetricNotFound
+	}
+
+	return *m.Delta, nil
+}
+
+func (s *memoryStorage) GetHistogram(_ context.Context, metricName string, labels metrics.Labels) (metrics.Histogram, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	m, exists := s.histogramMetrics[metrics.Metric{Name: metricName, Labels: labels}.ID()]
 	if !exists {
-		return 0, errors.New("unknown gauge")
+		return metrics.Histogram{}, errors.New("unknown histogram")
 	}
 
-	return value, nil
+	return m.Histogram.Clone(), nil
 }
 
-func (s *memoryStorage) GetCounter(_ context.Context, name string) (metrics.Counter, error) {
+func (s *memoryStorage) GetSummary(_ context.Context, metricName string, labels metrics.Labels) (metrics.Summary, error) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
-	value, exists := s.counterMetrics[name]
+	m, exists := s.summaryMetrics[metrics.Metric{Name: metricName, Labels: labels}.ID()]
 	if !exists {
-		return 0, errors.New("unknown counter")
+		return metrics.Summary{}, errors.New("unknown summary")
 	}
 
-	return value, nil
+	return m.Summary.Clone(), nil
 }
 
 func (s *memoryStorage) GetAllMetrics(_ context.Context) (metrics.Metrics, error) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
-	result := make(metrics.Metrics, 0, len(s.gaugeMetrics)+len(s.counterMetrics))
-	for k, v := range s.gaugeMetrics {
-		result = append(result, metrics.MakeGaugeMetric(k, v))
+	result := make(metrics.Metrics, 0, len(s.gaugeMetrics)+len(s.counterMetrics)+len(s.histogramMetrics)+len(s.summaryMetrics))
+	for _, m := range s.gaugeMetrics {
+		result = append(result, m.Clone())
+	}
+	for _, m := range s.counterMetrics {
+		result = append(result, m.Clone())
+	}
+	for _, m := range s.histogramMetrics {
+		result = append(result, m.Clone())
+	}
+	for _, m := range s.summaryMetrics {
+		result = append(result, m.Clone())
+	}
+
+	return result, nil
+}
+
+func (s *memoryStorage) QueryMetrics(ctx context.Context, q MetricsQuery) (MetricsPage, error) {
+	all, err := s.GetAllMetrics(ctx)
+	if err != nil {
+		return MetricsPage{}, err
 	}
-	for k, v := range s.counterMetrics {
-		result = append(result, metrics.MakeCounterMetric(k, v))
+
+	return queryMetrics(all, q)
+}
+
+func (s *memoryStorage) GetRange(_ context.Context, metricName string, from, to time.Time) (metrics.Metrics, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var result metrics.Metrics
+	for _, samples := range s.history {
+		if samples[0].Name != metricName {
+			continue
+		}
+		for _, m := range samples {
+			if m.Timestamp.Before(from) || m.Timestamp.After(to) {
+				continue
+			}
+			result = append(result, m.Clone())
+		}
 	}
 
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Timestamp.Before(*result[j].Timestamp)
+	})
+
 	return result, nil
 }
 
@@ -120,8 +387,12 @@ func (s *memoryStorage) Ping(_ context.Context) error {
 func (s *memoryStorage) CleanUp(_ context.Context) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
-	s.gaugeMetrics = make(map[string]metrics.Gauge)
-	s.counterMetrics = make(map[string]metrics.Counter)
+	s.gaugeMetrics = make(map[string]metrics.Metric)
+	s.counterMetrics = make(map[string]metrics.Metric)
+	s.histogramMetrics = make(map[string]metrics.Metric)
+	s.summaryMetrics = make(map[string]metrics.Metric)
+	s.history = make(map[string]metrics.Metrics)
+	s.updated = make(map[string]time.Time)
 
 	return nil
 }
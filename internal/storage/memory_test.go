@@ -3,13 +3,85 @@ package storage
 import (
 	"context"
 	"testing"
+	"time"
+
+	"github.com/vleukhin/prom-light/internal/metrics"
 )
 
 func TestMemoryStorage(t *testing.T) {
-	storage := NewMemoryStorage()
+	storage := NewMemoryStorage(time.Hour)
 
 	testStorage(storage, t)
 	if err := storage.CleanUp(context.Background()); err != nil {
 		panic(err)
 	}
 }
+
+func TestMemoryStorage_HistoryRetention(t *testing.T) {
+	ctx := context.Background()
+	storage := NewMemoryStorage(time.Hour)
+
+	old := metrics.MakeGaugeMetric("HeapAlloc", 1)
+	oldTS := time.Now().Add(-2 * time.Hour)
+	old.Timestamp = &oldTS
+	if err := storage.SetMetric(ctx, old); err != nil {
+		t.Fatalf("SetMetric() error = %v", err)
+	}
+	if err := storage.SetMetric(ctx, metrics.MakeGaugeMetric("HeapAlloc", 2)); err != nil {
+		t.Fatalf("SetMetric() error = %v", err)
+	}
+
+	history, err := storage.GetRange(ctx, "HeapAlloc", oldTS.Add(-time.Minute), time.Now())
+	if err != nil {
+		t.Fatalf("GetRange() error = %v", err)
+	}
+	if len(history) != 1 || *history[0].Value != 2 {
+		t.Errorf("GetRange() = %v; want only the fresh sample", history)
+	}
+
+	disabled := NewMemoryStorage(0)
+	if err := disabled.SetMetric(ctx, metrics.MakeGaugeMetric("HeapAlloc", 1)); err != nil {
+		t.Fatalf("SetMetric() error = %v", err)
+	}
+	history, err = disabled.GetRange(ctx, "HeapAlloc", time.Time{}, time.Now())
+	if err != nil {
+		t.Fatalf("GetRange() error = %v", err)
+	}
+	if len(history) != 0 {
+		t.Errorf("GetRange() = %v; want empty history", history)
+	}
+}
+
+func TestMemoryStorage_HistoryOutOfOrder(t *testing.T) {
+	ctx := context.Background()
+	storage := NewMemoryStorage(time.Hour)
+	now := time.Now()
+
+	// Значение из очереди агента приходит после свежего
+	for _, sample := range []struct {
+		value metrics.Gauge
+		at    time.Time
+	}{
+		{3, now},
+		{1, now.Add(-2 * time.Hour)},
+		{2, now.Add(-30 * time.Minute)},
+	} {
+		m := metrics.MakeGaugeMetric("HeapAlloc", sample.value)
+		at := sample.at
+		m.Timestamp = &at
+		if err := storage.SetMetric(ctx, m); err != nil {
+			t.Fatalf("SetMetric() error = %v", err)
+		}
+	}
+
+	history, err := storage.GetRange(ctx, "HeapAlloc", now.Add(-3*time.Hour), now)
+	if err != nil {
+		t.Fatalf("GetRange() error = %v", err)
+	}
+	if len(history) != 2 || *history[0].Value != 2 || *history[1].Value != 3 {
+		t.Fatalf("GetRange() = %v; want samples 2 and 3 in time order", history)
+	}
+	if samples := storage.history[seriesKey(history[0])]; len(samples) != 2 {
+		t.Errorf("history keeps %d samples; want expired sample trimmed", len(samples))
+	}
+}
@@ -0,0 +1,226 @@
+package storage
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/rs/zerolog/log"
+)
+
+// migrationFiles упорядоченный набор миграций схемы. Первые миграции повторяют схему,
+// которая раньше создавалась при старте сервера, и идемпотентны, поэтому применяются и к существующим БД
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockID ключ advisory lock, под которым применяются миграции.
+// Лок не дает нескольким серверам, запущенным одновременно, применять миграции параллельно
+const migrationLockID int64 = 0x70726f6d6c696768
+
+// Migration версия схемы БД. Файлы миграции называются NNNN_name.up.sql и NNNN_name.down.sql
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// loadMigrations читает миграции из fsys и упорядочивает их по версии.
+// Версии должны идти подряд начиная с 1, у каждой миграции должны быть up и down
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		name := entry.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("unexpected migration file: %s", name)
+		}
+
+		rawVersion, title, found := strings.Cut(strings.TrimSuffix(name, "."+direction+".sql"), "_")
+		version, err := strconv.Atoi(rawVersion)
+		if !found || err != nil || version <= 0 {
+			return nil, fmt.Errorf("bad migration file name: %s", name)
+		}
+		data, err := fs.ReadFile(fsys, path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: title}
+			byVersion[version] = m
+		}
+		if m.Name != title {
+			return nil, fmt.Errorf("migration %d has different names: %s and %s", version, m.Name, title)
+		}
+		if direction == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s must have both up and down files", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("missing migration %d", i+1)
+		}
+	}
+
+	return migrations, nil
+}
+
+// language=PostgreSQL
+const createSchemaMigrationsSQL = `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    integer primary key,
+		name       varchar(255) not null,
+		applied_at timestamptz  not null default now()
+	)
+`
+
+// Migrate применяет все непримененные миграции
+func (s *PostgresStorage) Migrate(ctx context.Context) error {
+	_, err := s.MigrateUp(ctx)
+	return err
+}
+
+// MigrateUp применяет все непримененные миграции и выдает их список
+func (s *PostgresStorage) MigrateUp(ctx context.Context) ([]Migration, error) {
+	migrations, err := loadMigrations(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []Migration
+	err = s.withMigrationLock(ctx, func(conn *pgxpool.Conn, version int) error {
+		for _, m := range migrations[version:] {
+			if err := applyMigration(ctx, conn, m, true); err != nil {
+				return err
+			}
+			applied = append(applied, m)
+		}
+		return nil
+	})
+
+	return applied, err
+}
+
+// MigrateDown откатывает steps последних примененных миграций и выдает их список
+func (s *PostgresStorage) MigrateDown(ctx context.Context, steps int) ([]Migration, error) {
+	migrations, err := loadMigrations(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	var reverted []Migration
+	err = s.withMigrationLock(ctx, func(conn *pgxpool.Conn, version int) error {
+		if version > len(migrations) {
+			return fmt.Errorf("database schema version %d is newer than known migrations", version)
+		}
+		for i := version - 1; i >= 0 && len(reverted) < steps; i-- {
+			if err := applyMigration(ctx, conn, migrations[i], false); err != nil {
+				return err
+			}
+			reverted = append(reverted, migrations[i])
+		}
+		return nil
+	})
+
+	return reverted, err
+}
+
+// SchemaVersion выдает версию последней примененной миграции и версию последней известной миграции
+func (s *PostgresStorage) SchemaVersion(ctx context.Context) (current int, latest int, err error) {
+	migrations, err := loadMigrations(migrationFiles, "migrations")
+	if err != nil {
+		return 0, 0, err
+	}
+	err = s.withMigrationLock(ctx, func(_ *pgxpool.Conn, version int) error {
+		current = version
+		return nil
+	})
+
+	return current, len(migrations), err
+}
+
+// withMigrationLock выполняет fn на отдельном соединении под advisory lock
+// и передает в fn текущую версию схемы
+func (s *PostgresStorage) withMigrationLock(ctx context.Context, fn func(conn *pgxpool.Conn, version int) error) error {
+	conn, err := s.conn.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", migrationLockID); err != nil {
+		return err
+	}
+	defer func() {
+		if _, err := conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockID); err != nil {
+			log.Error().Err(err).Msg("Failed to release migration lock")
+		}
+	}()
+
+	if _, err := conn.Exec(ctx, createSchemaMigrationsSQL); err != nil {
+		return err
+	}
+	var version int
+	if err := conn.QueryRow(ctx, "SELECT coalesce(max(version), 0) FROM schema_migrations").Scan(&version); err != nil {
+		return err
+	}
+
+	return fn(conn, version)
+}
+
+// applyMigration применяет или откатывает миграцию в отдельной транзакции
+func applyMigration(ctx context.Context, conn *pgxpool.Conn, m Migration, up bool) error {
+	return conn.BeginFunc(ctx, func(tx pgx.Tx) error {
+		sql, record, args := m.Up, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", []interface{}{m.Version, m.Name}
+		if !up {
+			sql, record, args = m.Down, "DELETE FROM schema_migrations WHERE version = $1", []interface{}{m.Version}
+		}
+		// Без аргументов запрос выполняется по простому протоколу, и миграция может состоять из нескольких команд
+		if _, err := tx.Exec(ctx, sql); err != nil {
+			return fmt.Errorf("migration %d_%s: %w", m.Version, m.Name, err)
+		}
+		if _, err := tx.Exec(ctx, record, args...); err != nil {
+			return err
+		}
+
+		if up {
+			log.Info().Msgf("Applied migration %d_%s", m.Version, m.Name)
+		} else {
+			log.Info().Msgf("Reverted migration %d_%s", m.Version, m.Name)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/caarlos0/env/v6"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := loadMigrations(migrationFiles, "migrations")
+	if err != nil {
+		t.Fatalf("loadMigrations() error = %v", err)
+	}
+	if len(migrations) == 0 || migrations[0].Name != "create_metrics" {
+		t.Errorf("loadMigrations() = %v; want embedded migrations starting with create_metrics", migrations)
+	}
+
+	file := func(data string) *fstest.MapFile {
+		return &fstest.MapFile{Data: []byte(data)}
+	}
+	fsys := fstest.MapFS{
+		"m/0002_second.up.sql":   file("up 2"),
+		"m/0002_second.down.sql": file("down 2"),
+		"m/0001_first.up.sql":    file("up 1"),
+		"m/0001_first.down.sql":  file("down 1"),
+	}
+	migrations, err = loadMigrations(fsys, "m")
+	if err != nil {
+		t.Fatalf("loadMigrations() error = %v", err)
+	}
+	want := []Migration{
+		{Version: 1, Name: "first", Up: "up 1", Down: "down 1"},
+		{Version: 2, Name: "second", Up: "up 2", Down: "down 2"},
+	}
+	if len(migrations) != 2 || migrations[0] != want[0] || migrations[1] != want[1] {
+		t.Errorf("loadMigrations() = %v; want %v", migrations, want)
+	}
+
+	tests := []struct {
+		name string
+		fsys fstest.MapFS
+	}{
+		{name: "Missing down", fsys: fstest.MapFS{"m/0001_first.up.sql": file("up")}},
+		{name: "Gap in versions", fsys: fstest.MapFS{"m/0002_second.up.sql": file("up"), "m/0002_second.down.sql": file("down")}},
+		{name: "Bad name", fsys: fstest.MapFS{"m/first.up.sql": file("up"), "m/first.down.sql": file("down")}},
+		{name: "Different names", fsys: fstest.MapFS{"m/0001_first.up.sql": file("up"), "m/0001_other.down.sql": file("down")}},
+		{name: "Unexpected file", fsys: fstest.MapFS{"m/README.md": file("")}},
+	}
+	for _, tt := range tests {
+		if _, err := loadMigrations(tt.fsys, "m"); err == nil {
+			t.Errorf("loadMigrations() %s: expected error", tt.name)
+		}
+	}
+}
+
+func TestPostgresStorage_Migrations(t *testing.T) {
+	ctx := context.Background()
+	cfg := testConfig{}
+	if err := env.Parse(&cfg); err != nil {
+		t.Fatalf("env.Parse() error = %v", err)
+	}
+	if cfg.DSN == "" {
+		return
+	}
+	db, err := NewPostgresStorage(cfg.DSN, time.Second*5, time.Hour)
+	if err != nil {
+		t.Fatalf("NewPostgresStorage() error = %v", err)
+	}
+	defer db.ShutDown(ctx)
+
+	if err := db.Migrate(ctx); err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+	current, latest, err := db.SchemaVersion(ctx)
+	if err != nil || current != latest {
+		t.Fatalf("SchemaVersion() = %v, %v, %v; want latest version", current, latest, err)
+	}
+
+	reverted, err := db.MigrateDown(ctx, latest)
+	if err != nil || len(reverted) != latest {
+		t.Fatalf("MigrateDown() = %v, %v; want all migrations reverted", reverted, err)
+	}
+	applied, err := db.MigrateUp(ctx)
+	if err != nil || len(applied) != latest {
+		t.Fatalf("MigrateUp() = %v, %v; want all migrations applied", applied, err)
+	}
+	applied, err = db.MigrateUp(ctx)
+	if err != nil || len(applied) != 0 {
+		t.Errorf("MigrateUp() = %v, %v; want nothing to apply", applied, err)
+	}
+}
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS metrics (
+    id    serial constraint table_name_pk primary key,
+    name  varchar(255) not null unique,
+    type  varchar(255) not null,
+    value float8       not null
+);
@@ -0,0 +1,5 @@
+-- Без меток имя метрики снова уникально, поэтому серии с метками удаляются
+DELETE FROM metrics WHERE labels <> '{}';
+DROP INDEX IF EXISTS metrics_series_idx;
+ALTER TABLE metrics DROP COLUMN IF EXISTS labels;
+ALTER TABLE metrics ADD CONSTRAINT metrics_name_key UNIQUE (name);
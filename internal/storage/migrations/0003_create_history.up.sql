@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS metrics_history (
+    id         bigserial primary key,
+    name       varchar(255) not null,
+    labels     jsonb        not null default '{}',
+    type       varchar(255) not null,
+    value      float8       not null,
+    created_at timestamptz  not null
+);
+CREATE INDEX IF NOT EXISTS metrics_history_name_created_at_idx ON metrics_history (name, created_at);
+CREATE INDEX IF NOT EXISTS metrics_history_created_at_idx ON metrics_history (created_at);
@@ -0,0 +1,5 @@
+-- Значения histogram и summary хранятся только в data
+DELETE FROM metrics WHERE type IN ('histogram', 'summary');
+DELETE FROM metrics_history WHERE type IN ('histogram', 'summary');
+ALTER TABLE metrics DROP COLUMN IF EXISTS data;
+ALTER TABLE metrics_history DROP COLUMN IF EXISTS data;
@@ -0,0 +1,2 @@
+ALTER TABLE metrics ADD COLUMN IF NOT EXISTS data jsonb;
+ALTER TABLE metrics_history ADD COLUMN IF NOT EXISTS data jsonb;
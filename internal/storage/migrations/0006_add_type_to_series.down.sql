@@ -0,0 +1,5 @@
+-- Из серий с одинаковыми именем и метками остается первая сохраненная
+DELETE FROM metrics m USING metrics d
+WHERE m.name = d.name AND m.labels = d.labels AND m.id > d.id;
+DROP INDEX IF EXISTS metrics_series_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS metrics_series_idx ON metrics (name, labels);
@@ -0,0 +1,3 @@
+-- Серия определяется типом наравне с именем и метками, как в хранилище в памяти
+DROP INDEX IF EXISTS metrics_series_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS metrics_series_idx ON metrics (name, labels, type);
@@ -3,6 +3,7 @@ package storage
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -14,17 +15,25 @@ type mockStorage struct {
 }
 
 func NewMockStorage() *mockStorage {
-	return &mockStorage{NewMemoryStorage()}
+	return &mockStorage{NewMemoryStorage(time.Hour)}
 }
 
 func (s *mockStorage) AssertGaugeStoredWithValue(t *testing.T, name string, expected metrics.Gauge) {
-	actual, ok := s.gaugeMetrics[name]
+	m, ok := s.gaugeMetrics[name]
 	assert.True(t, ok, fmt.Sprintf("Gauge '%s' was not stored", name))
+	var actual metrics.Gauge
+	if ok {
+		actual = *m.Value
+	}
 	assert.Equal(t, expected, actual, fmt.Sprintf("Gauge '%s' was stored with wrong value. Expected: %f Actual: %f", name, expected, actual))
 }
 
 func (s *mockStorage) AssertCounterStoredWithValue(t *testing.T, name string, expected metrics.Counter) {
-	actual, ok := s.counterMetrics[name]
+	m, ok := s.counterMetrics[name]
 	assert.True(t, ok, fmt.Sprintf("Counter '%s' was not stored", name))
+	var actual metrics.Counter
+	if ok {
+		actual = *m.Delta
+	}
 	assert.Equal(t, expected, actual, fmt.Sprintf("Counter '%s' was stored with wrong value. Expected: %d Actual: %d", name, expected, actual))
 }
@@ -2,11 +2,15 @@ package storage
 
 import (
 	"context"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/caarlos0/env/v6"
 	"github.com/rs/zerolog/log"
+
+	"github.com/vleukhin/prom-light/internal/metrics"
 )
 
 type testConfig struct {
@@ -24,7 +28,7 @@ func TestPostgresStorage(t *testing.T) {
 	if cfg.DSN == "" {
 		return
 	}
-	db, err := NewPostgresStorage(cfg.DSN, time.Second*5)
+	db, err := NewPostgresStorage(cfg.DSN, time.Second*5, time.Hour)
 	if err != nil {
 		panic(err)
 	}
@@ -37,3 +41,67 @@ func TestPostgresStorage(t *testing.T) {
 		panic(err)
 	}
 }
+
+func TestBuildQuerySQL(t *testing.T) {
+	p, err := MetricsQuery{
+		Matcher: Matcher{
+			Types:      []string{"gauge"},
+			NamePrefix: "Heap_",
+			Labels:     metrics.Labels{"agent": "edge-01"},
+		},
+		SortBy: SortByType,
+		Desc:   true,
+		Cursor: encodeCursor(metrics.MakeGaugeMetric("HeapAlloc", 1)),
+		Limit:  10,
+	}.parse()
+	if err != nil {
+		t.Fatalf("parse() error = %v", err)
+	}
+
+	sql, args := buildQuerySQL(p)
+	wantSQL := queryMetricsSQL + " WHERE type = ANY($1) AND name LIKE $2 AND labels @> $3" +
+		" AND (type, name, labels::text) < ($6, $4, $5::jsonb::text)" +
+		" ORDER BY type DESC, name DESC, labels::text DESC LIMIT $7"
+	if sql != wantSQL {
+		t.Errorf("buildQuerySQL() sql = %v; want %v", sql, wantSQL)
+	}
+	if len(args) != 7 || args[1] != `Heap\_%` || args[6] != 11 {
+		t.Errorf("buildQuerySQL() wrong args = %v", args)
+	}
+
+	// Регулярное выражение проверяется после выборки, поэтому не попадает в запрос и снимает LIMIT
+	p, err = MetricsQuery{Matcher: Matcher{NameRegex: `^Heap\d+$`}, Limit: 10}.parse()
+	if err != nil {
+		t.Fatalf("parse() error = %v", err)
+	}
+	sql, args = buildQuerySQL(p)
+	if sql != queryMetricsSQL+" ORDER BY name, labels::text, type" || len(args) != 0 {
+		t.Errorf("buildQuerySQL() with regex sql = %v, args = %v", sql, args)
+	}
+
+	sql, args = buildQuerySQL(parsedQuery{MetricsQuery: MetricsQuery{SortBy: SortByName}})
+	if sql != queryMetricsSQL+" ORDER BY name, labels::text, type" || len(args) != 0 {
+		t.Errorf("buildQuerySQL() sql = %v, args = %v", sql, args)
+	}
+}
+
+func TestBuildDeleteStaleSQL(t *testing.T) {
+	now := time.Now()
+	sql, args := buildDeleteStaleSQL(StalenessPolicy{
+		TTL: time.Minute,
+		Overrides: []StalenessOverride{
+			{Type: metrics.CounterTypeName},
+			{Type: metrics.GaugeTypeName, Prefix: "CPU", TTL: time.Second},
+		},
+	}, now)
+
+	wantTTL := "CASE WHEN type = $3 AND name LIKE $4 THEN $5::bigint * interval '1 microsecond'" +
+		" WHEN type = $6 THEN NULL ELSE $2::bigint * interval '1 microsecond' END"
+	if !strings.Contains(sql, "updated_at < $1::timestamptz - ("+wantTTL+")") {
+		t.Errorf("buildDeleteStaleSQL() sql = %v; want TTL %v", sql, wantTTL)
+	}
+	wantArgs := []interface{}{now, int64(60000000), metrics.GaugeTypeName, "CPU%", int64(1000000), metrics.CounterTypeName}
+	if !reflect.DeepEqual(args, wantArgs) {
+		t.Errorf("buildDeleteStaleSQL() args = %v; want %v", args, wantArgs)
+	}
+}
@@ -2,19 +2,41 @@ package storage
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/rs/zerolog/log"
 
 	"github.com/vleukhin/prom-light/internal/metrics"
 )
 
 type PostgresStorage struct {
-	conn *pgxpool.Pool
+	conn       *pgxpool.Pool
+	retention  time.Duration
+	trimTicker *time.Ticker
+	done       chan struct{}
+	stopOnce   sync.Once
 }
 
-func NewPostgresStorage(dsn string, connTimeout time.Duration) (*PostgresStorage, error) {
+// historyTrimInterval как часто из истории удаляются устаревшие значения
+const historyTrimInterval = time.Minute
+
+// querier выполняет запросы в пуле соединений или в транзакции
+type querier interface {
+	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// NewPostgresStorage создает хранилище метрик в PostgreSQL.
+// retention задает время хранения истории значений, 0 отключает историю
+func NewPostgresStorage(dsn string, connTimeout time.Duration, retention time.Duration) (*PostgresStorage, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), connTimeout)
 	defer cancel()
 
@@ -23,19 +45,51 @@ func NewPostgresStorage(dsn string, connTimeout time.Duration) (*PostgresStorage
 		return nil, err
 	}
 
-	return &PostgresStorage{
-		conn: conn,
-	}, nil
+	storage := &PostgresStorage{
+		conn:      conn,
+		retention: retention,
+		done:      make(chan struct{}),
+	}
+	if retention > 0 {
+		interval := historyTrimInterval
+		if retention < interval {
+			interval = retention
+		}
+		storage.startHistoryTrimming(interval)
+	}
+
+	return storage, nil
+}
+
+// startHistoryTrimming периодически удаляет устаревшие значения истории.
+// Удаление не делается при каждой записи, чтобы не замедлять прием метрик
+func (s *PostgresStorage) startHistoryTrimming(interval time.Duration) {
+	s.trimTicker = time.NewTicker(interval)
+	go func() {
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-s.trimTicker.C:
+				if err := s.trimHistory(context.Background()); err != nil {
+					log.Error().Err(err).Msg("Failed to trim metrics history")
+				}
+			}
+		}
+	}()
 }
 
 // language=PostgreSQL
-const getMetricSQL = `SELECT value FROM metrics WHERE name = $1`
+const getMetricSQL = `SELECT value FROM metrics WHERE name = $1 AND labels = $2 AND type = $3`
 
-func (s *PostgresStorage) GetGauge(ctx context.Context, metricName string) (metrics.Gauge, error) {
+func (s *PostgresStorage) GetGauge(ctx context.Context, metricName string, labels metrics.Labels) (metrics.Gauge, error) {
 	var value float64
 
-	row := s.conn.QueryRow(ctx, getMetricSQL, metricName)
+	row := s.conn.QueryRow(ctx, getMetricSQL, metricName, labelsToDB(labels), metrics.GaugeTypeName)
 	err := row.Scan(&value)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, ErrMetricNotFound
+	}
 	if err != nil {
 		return 0, err
 	}
@@ -43,11 +97,14 @@ func (s *PostgresStorage) GetGauge(ctx context.Context, metricName string) (metr
 	return metrics.Gauge(value), nil
 }
 
-func (s *PostgresStorage) GetCounter(ctx context.Context, metricName string) (metrics.Counter, error) {
+func (s *PostgresStorage) GetCounter(ctx context.Context, metricName string, labels metrics.Labels) (metrics.Counter, error) {
 	var value int
 
-	row := s.conn.QueryRow(ctx, getMetricSQL, metricName)
+	row := s.conn.QueryRow(ctx, getMetricSQL, metricName, labelsToDB(labels), metrics.CounterTypeName)
 	err := row.Scan(&value)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, ErrMetricNotFound
+	}
 	if err != nil {
 		return 0, err
 	}
@@ -56,7 +113,32 @@ func (s *PostgresStorage) GetCounter(ctx context.Context, metricName string) (me
 }
 
 // language=PostgreSQL
-const getAllMetricsSQL = `SELECT name, type, value  FROM metrics order by id`
+const getMetricDataSQL = `SELECT data FROM metrics WHERE name = $1 AND labels = $2 AND type = $3`
+
+func (s *PostgresStorage) GetHistogram(ctx context.Context, metricName string, labels metrics.Labels) (metrics.Histogram, error) {
+	var h metrics.Histogram
+
+	row := s.conn.QueryRow(ctx, getMetricDataSQL, metricName, labelsToDB(labels), metrics.HistogramTypeName)
+	if err := row.Scan(&h); err != nil {
+		return metrics.Histogram{}, err
+	}
+
+	return h, nil
+}
+
+func (s *PostgresStorage) GetSummary(ctx context.Context, metricName string, labels metrics.Labels) (metrics.Summary, error) {
+	var summary metrics.Summary
+
+	row := s.conn.QueryRow(ctx, getMetricDataSQL, metricName, labelsToDB(labels), metrics.SummaryTypeName)
+	if err := row.Scan(&summary); err != nil {
+		return metrics.Summary{}, err
+	}
+
+	return summary, nil
+}
+
+// language=PostgreSQL
+const getAllMetricsSQL = `SELECT name, labels, type, value, data FROM metrics order by id`
 
 func (s *PostgresStorage) GetAllMetrics(ctx context.Context) (metrics.Metrics, error) {
 	rows, err := s.conn.Query(ctx, getAllMetricsSQL)
@@ -69,20 +151,15 @@ func (s *PostgresStorage) GetAllMetrics(ctx context.Context) (metrics.Metrics, e
 	for rows.Next() {
 		metric := metrics.Metric{}
 		var rawValue float64
-		err := rows.Scan(&metric.Name, &metric.Type, &rawValue)
+		var data []byte
+		err := rows.Scan(&metric.Name, &metric.Labels, &metric.Type, &rawValue, &data)
 		if err != nil {
 			return nil, err
 		}
+		metric.Labels = labelsFromDB(metric.Labels)
 
-		switch metric.Type {
-		case metrics.GaugeTypeName:
-			value := metrics.Gauge(rawValue)
-			metric.Value = &value
-		case metrics.CounterTypeName:
-			delta := metrics.Counter(rawValue)
-			metric.Delta = &delta
-		default:
-			return nil, errors.New("unknown metric type: " + metric.Type)
+		if err := setRawValue(&metric, rawValue, data); err != nil {
+			return nil, err
 		}
 
 		result = append(result, metric)
@@ -91,65 +168,483 @@ func (s *PostgresStorage) GetAllMetrics(ctx context.Context) (metrics.Metrics, e
 	return result, nil
 }
 
+// language=PostgreSQL
+const queryMetricsSQL = `SELECT name, labels, type, value, data FROM metrics`
+
+// QueryMetrics выполняет фильтрацию, сортировку и постраничную выдачу на стороне БД.
+// Регулярное выражение имени проверяется в Go, потому что синтаксис регулярных выражений
+// Postgres отличается от RE2, по которому выражение проверяется при разборе запроса
+func (s *PostgresStorage) QueryMetrics(ctx context.Context, q MetricsQuery) (MetricsPage, error) {
+	p, err := q.parse()
+	if err != nil {
+		return MetricsPage{}, err
+	}
+
+	sql, args := buildQuerySQL(p)
+	rows, err := s.conn.Query(ctx, sql, args...)
+	if err != nil {
+		return MetricsPage{}, err
+	}
+	defer rows.Close()
+
+	var result metrics.Metrics
+	for rows.Next() {
+		metric := metrics.Metric{}
+		var rawValue float64
+		var data []byte
+		if err := rows.Scan(&metric.Name, &metric.Labels, &metric.Type, &rawValue, &data); err != nil {
+			return MetricsPage{}, err
+		}
+		metric.Labels = labelsFromDB(metric.Labels)
+		if err := setRawValue(&metric, rawValue, data); err != nil {
+			return MetricsPage{}, err
+		}
+		if p.matcher.regex != nil && !p.matcher.regex.MatchString(metric.Name) {
+			continue
+		}
+		result = append(result, metric)
+		if p.Limit > 0 && len(result) > p.Limit {
+			break
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return MetricsPage{}, err
+	}
+
+	return paginate(result, p.Limit), nil
+}
+
+// buildQuerySQL строит запрос списка метрик. Выбирается на одну метрику больше лимита,
+// чтобы определить, есть ли следующая страница. С регулярным выражением имени лимит
+// не ограничивает запрос: часть строк отсеивается после выборки
+func buildQuerySQL(p parsedQuery) (string, []interface{}) {
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+	where := matcherSQL(p.Matcher, arg)
+
+	columns := "name, labels::text, type"
+	if p.SortBy == SortByType {
+		columns = "type, name, labels::text"
+	}
+	if p.cursor != nil {
+		name, labels, typ := arg(p.cursor.Name), arg(labelsToDB(p.cursor.Labels))+"::jsonb::text", arg(p.cursor.Type)
+		values := name + ", " + labels + ", " + typ
+		if p.SortBy == SortByType {
+			values = typ + ", " + name + ", " + labels
+		}
+		op := " > "
+		if p.Desc {
+			op = " < "
+		}
+		where = append(where, "("+columns+")"+op+"("+values+")")
+	}
+
+	sql := queryMetricsSQL
+	if len(where) > 0 {
+		sql += " WHERE " + strings.Join(where, " AND ")
+	}
+
+	direction := ""
+	if p.Desc {
+		direction = " DESC"
+	}
+	order := strings.Split(columns, ", ")
+	for i := range order {
+		order[i] += direction
+	}
+	sql += " ORDER BY " + strings.Join(order, ", ")
+
+	if p.Limit > 0 && p.matcher.regex == nil {
+		sql += " LIMIT " + arg(p.Limit+1)
+	}
+
+	return sql, args
+}
+
+// matcherSQL строит условия WHERE для фильтра метрик. arg добавляет параметр запроса и выдает его плейсхолдер.
+// Регулярное выражение имени в условия не попадает и проверяется после выборки
+func matcherSQL(m Matcher, arg func(v interface{}) string) []string {
+	var where []string
+	if len(m.Types) > 0 {
+		where = append(where, "type = ANY("+arg(m.Types)+")")
+	}
+	if m.Name != "" {
+		where = append(where, "name = "+arg(m.Name))
+	}
+	if m.NamePrefix != "" {
+		where = append(where, "name LIKE "+arg(escapeLike(m.NamePrefix)+"%"))
+	}
+	if len(m.Labels) > 0 {
+		where = append(where, "labels @> "+arg(m.Labels))
+	}
+
+	return where
+}
+
+// escapeLike экранирует спецсимволы шаблона LIKE
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
 // language=PostgreSQL
 const setGaugeSQL = `
-	INSERT INTO metrics (name, type, value)
-	VALUES ($1, $2, $3)
-	ON CONFLICT ON CONSTRAINT metrics_name_key DO UPDATE
-	SET value = excluded.value
+	INSERT INTO metrics (name, labels, type, value)
+	VALUES ($1, $2, $3, $4)
+	ON CONFLICT (name, labels, type) DO UPDATE
+	SET value = excluded.value, updated_at = now()
+	RETURNING value
 `
 
+// SetMetric сохраняет метрику в отдельной транзакции, в которой гистограмма
+// объединяется с сохраненной под блокировкой строки
 func (s *PostgresStorage) SetMetric(ctx context.Context, m metrics.Metric) error {
+	return s.SetMetrics(ctx, metrics.Metrics{m})
+}
+
+func (s *PostgresStorage) SetMetrics(ctx context.Context, mtrcs metrics.Metrics) error {
+	tx, err := s.conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	for _, m := range mtrcs {
+		if err := s.setMetric(ctx, tx, m); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// language=PostgreSQL
+const setDataSQL = `
+	INSERT INTO metrics (name, labels, type, value, data)
+	VALUES ($1, $2, $3, $4, $5)
+	ON CONFLICT (name, labels, type) DO UPDATE
+	SET value = excluded.value, data = excluded.data, updated_at = now()
+	RETURNING value
+`
+
+// language=PostgreSQL
+const createSeriesSQL = `
+	INSERT INTO metrics (name, labels, type, value)
+	VALUES ($1, $2, $3, 0)
+	ON CONFLICT (name, labels, type) DO NOTHING
+`
+
+// language=PostgreSQL
+const lockMetricDataSQL = `SELECT data FROM metrics WHERE name = $1 AND labels = $2 AND type = $3 FOR UPDATE`
+
+// lockHistogram выдает сохраненную гистограмму, блокируя ее строку до конца транзакции.
+// Строка создается заранее, чтобы параллельная запись новой серии тоже ждала блокировки
+func lockHistogram(ctx context.Context, q querier, name string, labels metrics.Labels) (metrics.Histogram, error) {
+	var h metrics.Histogram
+	if _, err := q.Exec(ctx, createSeriesSQL, name, labelsToDB(labels), metrics.HistogramTypeName); err != nil {
+		return h, err
+	}
+	var data []byte
+	if err := q.QueryRow(ctx, lockMetricDataSQL, name, labelsToDB(labels), metrics.HistogramTypeName).Scan(&data); err != nil {
+		return h, err
+	}
+	if data == nil {
+		return h, nil
+	}
+
+	return h, json.Unmarshal(data, &h)
+}
+
+// setMetric сохраняет значение метрики и записывает полученное значение серии в историю.
+// Для гистограмм q должен быть транзакцией, иначе блокировка строки снимается до записи
+func (s *PostgresStorage) setMetric(ctx context.Context, q querier, m metrics.Metric) error {
+	var value float64
+	var data []byte
 	var err error
 	switch m.Type {
 	case metrics.GaugeTypeName:
 		if m.Value == nil {
 			return errors.New("nil gauge value")
 		}
-		_, err = s.conn.Exec(ctx, setGaugeSQL, m.Name, metrics.GaugeTypeName, *m.Value)
+		err = q.QueryRow(ctx, setGaugeSQL, m.Name, labelsToDB(m.Labels), metrics.GaugeTypeName, *m.Value).Scan(&value)
 	case metrics.CounterTypeName:
 		if m.Delta == nil {
 			return errors.New("nil counter value")
 		}
-		_, err = s.conn.Exec(ctx, incCounterSQL, m.Name, metrics.CounterTypeName, *m.Delta)
+		err = q.QueryRow(ctx, incCounterSQL, m.Name, labelsToDB(m.Labels), metrics.CounterTypeName, *m.Delta).Scan(&value)
+	case metrics.HistogramTypeName:
+		if m.Histogram == nil {
+			return errors.New("nil histogram value")
+		}
+		if !m.Histogram.IsValid() {
+			return errors.New("invalid histogram buckets")
+		}
+		h, lockErr := lockHistogram(ctx, q, m.Name, m.Labels)
+		if lockErr != nil {
+			return lockErr
+		}
+		h = h.Merge(*m.Histogram)
+		if data, err = json.Marshal(h); err != nil {
+			return err
+		}
+		err = q.QueryRow(ctx, setDataSQL, m.Name, labelsToDB(m.Labels), metrics.HistogramTypeName, float64(h.Count), data).Scan(&value)
+	case metrics.SummaryTypeName:
+		if m.Summary == nil {
+			return errors.New("nil summary value")
+		}
+		if data, err = json.Marshal(m.Summary); err != nil {
+			return err
+		}
+		err = q.QueryRow(ctx, setDataSQL, m.Name, labelsToDB(m.Labels), metrics.SummaryTypeName, float64(m.Summary.Count), data).Scan(&value)
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
 	}
 
-	return err
+	at := time.Now()
+	if m.Timestamp != nil {
+		at = *m.Timestamp
+	}
+
+	return s.recordHistory(ctx, q, m.Name, m.Labels, m.Type, value, data, at)
 }
-func (s *PostgresStorage) SetMetrics(ctx context.Context, mtrcs metrics.Metrics) error {
-	tx, err := s.conn.Begin(ctx)
+
+func (s *PostgresStorage) DeleteMetric(ctx context.Context, metricType string, metricName string) error {
+	deleted, err := s.DeleteByMatcher(ctx, Matcher{Types: []string{metricType}, Name: metricName})
 	if err != nil {
 		return err
 	}
+	if deleted == 0 {
+		return ErrMetricNotFound
+	}
 
-	for _, m := range mtrcs {
-		if err := s.SetMetric(ctx, m); err != nil {
-			txErr := tx.Rollback(ctx)
-			if txErr != nil {
-				return txErr
+	return nil
+}
+
+// DeleteByMatcher удаляет подходящие под фильтр серии вместе с их историей
+func (s *PostgresStorage) DeleteByMatcher(ctx context.Context, m Matcher) (int, error) {
+	p, err := m.parse()
+	if err != nil {
+		return 0, err
+	}
+	if p.IsEmpty() {
+		return 0, ErrEmptyMatcher
+	}
+
+	tx, err := s.conn.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+	conditions := matcherSQL(p.Matcher, arg)
+	if p.regex != nil {
+		names, err := matchingNames(ctx, tx, p, conditions, args)
+		if err != nil || len(names) == 0 {
+			return 0, err
+		}
+		conditions = append(conditions, "name = ANY("+arg(names)+")")
+	}
+	where := " WHERE " + strings.Join(conditions, " AND ")
+
+	if _, err := tx.Exec(ctx, "DELETE FROM metrics_history"+where, args...); err != nil {
+		return 0, err
+	}
+	tag, err := tx.Exec(ctx, "DELETE FROM metrics"+where, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(tag.RowsAffected()), tx.Commit(ctx)
+}
+
+// matchingNames выбирает имена метрик, подходящих под условия conditions, и оставляет те,
+// что соответствуют регулярному выражению фильтра
+func matchingNames(ctx context.Context, tx pgx.Tx, p parsedMatcher, conditions []string, args []interface{}) ([]string, error) {
+	sql := "SELECT DISTINCT name FROM metrics"
+	if len(conditions) > 0 {
+		sql += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	rows, err := tx.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		if p.regex.MatchString(name) {
+			names = append(names, name)
+		}
+	}
+
+	return names, rows.Err()
+}
+
+// DeleteStale удаляет устаревшие серии и их историю одним запросом
+func (s *PostgresStorage) DeleteStale(ctx context.Context, policy StalenessPolicy, now time.Time) (int, error) {
+	if !policy.IsEnabled() {
+		return 0, nil
+	}
+
+	sql, args := buildDeleteStaleSQL(policy, now)
+	var deleted int
+	if err := s.conn.QueryRow(ctx, sql, args...).Scan(&deleted); err != nil {
+		return 0, err
+	}
+
+	return deleted, nil
+}
+
+// buildDeleteStaleSQL строит запрос удаления устаревших серий. Время жизни серии выбирается
+// выражением CASE по правилам политики от более специфичных к менее специфичным.
+// Время жизни 0 передается как NULL, и такие серии не удаляются
+func buildDeleteStaleSQL(policy StalenessPolicy, now time.Time) (string, []interface{}) {
+	args := []interface{}{now}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+	ttl := func(d time.Duration) string {
+		if d == 0 {
+			return "NULL"
+		}
+		return arg(d.Microseconds()) + "::bigint * interval '1 microsecond'"
+	}
+
+	expr := ttl(policy.TTL)
+	if rules := policy.rules(); len(rules) > 0 {
+		var b strings.Builder
+		b.WriteString("CASE")
+		for _, o := range rules {
+			var conds []string
+			if o.Type != "" {
+				conds = append(conds, "type = "+arg(o.Type))
 			}
-			return err
+			if o.Prefix != "" {
+				conds = append(conds, "name LIKE "+arg(escapeLike(o.Prefix)+"%"))
+			}
+			if len(conds) == 0 {
+				conds = append(conds, "true")
+			}
+			b.WriteString(" WHEN " + strings.Join(conds, " AND ") + " THEN " + ttl(o.TTL))
 		}
+		b.WriteString(" ELSE " + expr + " END")
+		expr = b.String()
 	}
 
-	return tx.Commit(ctx)
+	return `
+	WITH stale AS (
+		DELETE FROM metrics WHERE updated_at < $1::timestamptz - (` + expr + `)
+		RETURNING name, labels, type
+	), history AS (
+		DELETE FROM metrics_history h USING stale s
+		WHERE h.name = s.name AND h.labels = s.labels AND h.type = s.type
+	)
+	SELECT count(*) FROM stale
+`, args
 }
 
 // language=PostgreSQL
 const incCounterSQL = `
-	INSERT INTO metrics (name, type, value)
-	VALUES ($1, $2, $3)
-	ON CONFLICT ON CONSTRAINT metrics_name_key DO UPDATE
-	SET value = metrics.value + excluded.value
+	INSERT INTO metrics (name, labels, type, value)
+	VALUES ($1, $2, $3, $4)
+	ON CONFLICT (name, labels, type) DO UPDATE
+	SET value = metrics.value + excluded.value, updated_at = now()
+	RETURNING value
+`
+
+func (s *PostgresStorage) IncCounter(ctx context.Context, metricName string, labels metrics.Labels, value metrics.Counter) error {
+	return s.SetMetric(ctx, metrics.Metric{Name: metricName, Type: metrics.CounterTypeName, Delta: &value, Labels: labels})
+}
+
+// language=PostgreSQL
+const recordHistorySQL = `
+	INSERT INTO metrics_history (name, labels, type, value, data, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6)
 `
 
-func (s *PostgresStorage) IncCounter(ctx context.Context, metricName string, value metrics.Counter) error {
-	_, err := s.conn.Exec(ctx, incCounterSQL, metricName, metrics.CounterTypeName, value)
+func (s *PostgresStorage) recordHistory(ctx context.Context, q querier, name string, labels metrics.Labels, metricType string, value float64, data []byte, at time.Time) error {
+	if s.retention == 0 {
+		return nil
+	}
+	_, err := q.Exec(ctx, recordHistorySQL, name, labelsToDB(labels), metricType, value, data, at)
+	return err
+}
+
+// language=PostgreSQL
+const trimHistorySQL = `DELETE FROM metrics_history WHERE created_at < $1`
+
+// trimHistory удаляет из истории значения старше времени хранения
+func (s *PostgresStorage) trimHistory(ctx context.Context) error {
+	if s.retention == 0 {
+		return nil
+	}
+	_, err := s.conn.Exec(ctx, trimHistorySQL, time.Now().Add(-s.retention))
 	return err
 }
 
+// language=PostgreSQL
+const getRangeSQL = `
+	SELECT name, labels, type, value, data, created_at
+	FROM metrics_history
+	WHERE name = $1 AND created_at BETWEEN $2 AND $3
+	ORDER BY created_at, id
+`
+
+func (s *PostgresStorage) GetRange(ctx context.Context, metricName string, from, to time.Time) (metrics.Metrics, error) {
+	rows, err := s.conn.Query(ctx, getRangeSQL, metricName, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result metrics.Metrics
+	for rows.Next() {
+		var rawValue float64
+		var data []byte
+		var createdAt time.Time
+		metric := metrics.Metric{}
+		if err := rows.Scan(&metric.Name, &metric.Labels, &metric.Type, &rawValue, &data, &createdAt); err != nil {
+			return nil, err
+		}
+		if err := setRawValue(&metric, rawValue, data); err != nil {
+			return nil, err
+		}
+		metric.Labels = labelsFromDB(metric.Labels)
+		metric.Timestamp = &createdAt
+
+		result = append(result, metric)
+	}
+
+	return result, rows.Err()
+}
+
 func (s *PostgresStorage) ShutDown(_ context.Context) error {
-	s.conn.Close()
+	s.stopOnce.Do(func() {
+		if s.trimTicker != nil {
+			s.trimTicker.Stop()
+		}
+		close(s.done)
+		s.conn.Close()
+	})
 	return nil
 }
 
@@ -157,22 +652,46 @@ func (s *PostgresStorage) Ping(ctx context.Context) error {
 	return s.conn.Ping(ctx)
 }
 
-// language=PostgreSQL
-const createMetricsTable = `
-	CREATE TABLE IF NOT EXISTS metrics (
-		id    serial constraint table_name_pk primary key,
-		name  varchar(255) not null unique,
-		type  varchar(255) not null,
-		value float8       not null
-	)
-`
-
-func (s *PostgresStorage) Migrate(ctx context.Context) error {
-	_, err := s.conn.Exec(ctx, createMetricsTable)
+func (s *PostgresStorage) CleanUp(ctx context.Context) error {
+	_, err := s.conn.Exec(ctx, "TRUNCATE TABLE metrics, metrics_history")
 	return err
 }
 
-func (s *PostgresStorage) CleanUp(ctx context.Context) error {
-	_, err := s.conn.Exec(ctx, "TRUNCATE TABLE metrics")
-	return err
+// setRawValue заполняет значение метрики в соответствии с ее типом.
+// Значения histogram и summary хранятся в data в формате JSON
+func setRawValue(metric *metrics.Metric, rawValue float64, data []byte) error {
+	switch metric.Type {
+	case metrics.GaugeTypeName:
+		value := metrics.Gauge(rawValue)
+		metric.Value = &value
+	case metrics.CounterTypeName:
+		delta := metrics.Counter(rawValue)
+		metric.Delta = &delta
+	case metrics.HistogramTypeName:
+		metric.Histogram = &metrics.Histogram{}
+		return json.Unmarshal(data, metric.Histogram)
+	case metrics.SummaryTypeName:
+		metric.Summary = &metrics.Summary{}
+		return json.Unmarshal(data, metric.Summary)
+	default:
+		return errors.New("unknown metric type: " + metric.Type)
+	}
+
+	return nil
+}
+
+// labelsToDB приводит метки к виду, в котором они хранятся в БД: отсутствие меток - пустой объект
+func labelsToDB(labels metrics.Labels) metrics.Labels {
+	if labels == nil {
+		return metrics.Labels{}
+	}
+	return labels
+}
+
+// labelsFromDB приводит метки из БД к виду, принятому в приложении: отсутствие меток - nil
+func labelsFromDB(labels metrics.Labels) metrics.Labels {
+	if len(labels) == 0 {
+		return nil
+	}
+	return labels
 }
@@ -0,0 +1,242 @@
+package storage
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/vleukhin/prom-light/internal/metrics"
+)
+
+// Поля сортировки результатов запроса
+const (
+	SortByName = "name"
+	SortByType = "type"
+)
+
+// Matcher описывает фильтр метрик. Пустые поля не ограничивают выборку
+type Matcher struct {
+	// Types допустимые типы метрик
+	Types []string
+	// Name точное имя метрики
+	Name string
+	// NamePrefix префикс имени метрики
+	NamePrefix string
+	// NameRegex регулярное выражение, которому должно соответствовать имя метрики.
+	// Выражение ищется в любом месте имени, для полного совпадения используйте ^ и $
+	NameRegex string
+	// Labels метки, которые должны быть у метрики с указанными значениями
+	Labels metrics.Labels
+}
+
+// MetricsQuery описывает запрос списка метрик
+type MetricsQuery struct {
+	Matcher
+	// SortBy поле сортировки, по умолчанию SortByName
+	SortBy string
+	// Desc сортировка по убыванию
+	Desc bool
+	// Cursor позиция, с которой продолжается выдача, из MetricsPage.NextCursor предыдущей страницы
+	Cursor string
+	// Limit максимальное количество метрик на странице, 0 снимает ограничение
+	Limit int
+}
+
+// MetricsPage страница результатов запроса списка метрик
+type MetricsPage struct {
+	Metrics metrics.Metrics `json:"metrics"`
+	// NextCursor курсор следующей страницы, пустой на последней странице
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+var (
+	// ErrInvalidQuery возвращается при некорректном запросе или фильтре метрик
+	ErrInvalidQuery = errors.New("invalid query")
+	// ErrEmptyMatcher возвращается при попытке удалить метрики по пустому фильтру
+	ErrEmptyMatcher = fmt.Errorf("%w: empty matcher", ErrInvalidQuery)
+	// ErrMetricNotFound возвращается при чтении или удалении метрики, которой нет в хранилище
+	ErrMetricNotFound = errors.New("metric not found")
+)
+
+// queryCursor позиция последней выданной метрики
+type queryCursor struct {
+	Name   string         `json:"n"`
+	Labels metrics.Labels `json:"l,omitempty"`
+	Type   string         `json:"t"`
+}
+
+// IsEmpty проверяет, что фильтр не ограничивает выборку
+func (m Matcher) IsEmpty() bool {
+	return len(m.Types) == 0 && m.Name == "" && m.NamePrefix == "" && m.NameRegex == "" && len(m.Labels) == 0
+}
+
+type parsedMatcher struct {
+	Matcher
+	regex *regexp.Regexp
+}
+
+func (m Matcher) parse() (parsedMatcher, error) {
+	p := parsedMatcher{Matcher: m}
+	if m.NameRegex != "" {
+		regex, err := regexp.Compile(m.NameRegex)
+		if err != nil {
+			return p, fmt.Errorf("%w: bad name regex: %s", ErrInvalidQuery, err.Error())
+		}
+		p.regex = regex
+	}
+
+	return p, nil
+}
+
+// Validate проверяет корректность запроса
+func (q MetricsQuery) Validate() error {
+	_, err := q.parse()
+	return err
+}
+
+type parsedQuery struct {
+	MetricsQuery
+	matcher parsedMatcher
+	cursor  *queryCursor
+}
+
+func (q MetricsQuery) parse() (parsedQuery, error) {
+	p := parsedQuery{MetricsQuery: q}
+	if p.SortBy == "" {
+		p.SortBy = SortByName
+	}
+	if p.SortBy != SortByName && p.SortBy != SortByType {
+		return p, fmt.Errorf("%w: unknown sort field: %s", ErrInvalidQuery, q.SortBy)
+	}
+	if q.Limit < 0 {
+		return p, fmt.Errorf("%w: negative limit", ErrInvalidQuery)
+	}
+	matcher, err := q.Matcher.parse()
+	if err != nil {
+		return p, err
+	}
+	p.matcher = matcher
+	if q.Cursor != "" {
+		c, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return p, fmt.Errorf("%w: bad cursor", ErrInvalidQuery)
+		}
+		p.cursor = &c
+	}
+
+	return p, nil
+}
+
+func cursorOf(m metrics.Metric) queryCursor {
+	return queryCursor{Name: m.Name, Labels: m.Labels, Type: m.Type}
+}
+
+func encodeCursor(m metrics.Metric) string {
+	data, _ := json.Marshal(cursorOf(m))
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(raw string) (queryCursor, error) {
+	var c queryCursor
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal(data, &c)
+
+	return c, err
+}
+
+// matches проверяет, что метрика подходит под фильтр
+func (p parsedMatcher) matches(m metrics.Metric) bool {
+	if len(p.Types) > 0 {
+		found := false
+		for _, t := range p.Types {
+			if t == m.Type {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if p.Name != "" && m.Name != p.Name {
+		return false
+	}
+	if !strings.HasPrefix(m.Name, p.NamePrefix) {
+		return false
+	}
+	if p.regex != nil && !p.regex.MatchString(m.Name) {
+		return false
+	}
+	for k, v := range p.Labels {
+		if actual, ok := m.Labels[k]; !ok || actual != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// less сравнивает позиции метрик в порядке сортировки запроса
+func (p parsedQuery) less(a, b queryCursor) bool {
+	ka := []string{a.Name, a.Labels.String(), a.Type}
+	kb := []string{b.Name, b.Labels.String(), b.Type}
+	if p.SortBy == SortByType {
+		ka = []string{a.Type, a.Name, a.Labels.String()}
+		kb = []string{b.Type, b.Name, b.Labels.String()}
+	}
+	for i := range ka {
+		if ka[i] != kb[i] {
+			return (ka[i] < kb[i]) != p.Desc
+		}
+	}
+
+	return false
+}
+
+// queryMetrics выполняет запрос по полному списку метрик. Используется хранилищами,
+// которые держат все метрики в памяти
+func queryMetrics(all metrics.Metrics, q MetricsQuery) (MetricsPage, error) {
+	p, err := q.parse()
+	if err != nil {
+		return MetricsPage{}, err
+	}
+
+	var result metrics.Metrics
+	for _, m := range all {
+		if !p.matcher.matches(m) {
+			continue
+		}
+		if p.cursor != nil && !p.less(*p.cursor, cursorOf(m)) {
+			continue
+		}
+		result = append(result, m)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return p.less(cursorOf(result[i]), cursorOf(result[j]))
+	})
+
+	return paginate(result, p.Limit), nil
+}
+
+// paginate обрезает отсортированный список до limit метрик и выдает курсор следующей страницы,
+// если метрик больше limit
+func paginate(mtrcs metrics.Metrics, limit int) MetricsPage {
+	if mtrcs == nil {
+		mtrcs = metrics.Metrics{}
+	}
+	if limit == 0 || len(mtrcs) <= limit {
+		return MetricsPage{Metrics: mtrcs}
+	}
+
+	return MetricsPage{
+		Metrics:    mtrcs[:limit],
+		NextCursor: encodeCursor(mtrcs[limit-1]),
+	}
+}
@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/vleukhin/prom-light/internal/metrics"
+)
+
+// Снимок хранилища в файле - JSON массив метрик, номер последней попавшей в снимок
+// записи WAL (если журнал используется) и строка с контрольной суммой:
+//
+//	[{"id":"Alloc","type":"gauge","value":1}]
+//	#wal-lsn 42
+//	#crc32c 1a2b3c4d
+//
+// Контрольная сумма CRC-32C считается по всему, что идет до строки с ней
+const (
+	snapshotChecksumPrefix = "#crc32c "
+	snapshotLSNPrefix      = "#wal-lsn "
+)
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+var errEmptySnapshot = errors.New("empty snapshot")
+
+// encodeSnapshot кодирует метрики в снимок с контрольной суммой. lsn - номер последней
+// записи WAL, попавшей в снимок, 0 - журнал не используется
+func encodeSnapshot(data metrics.Metrics, lsn uint64) ([]byte, error) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	payload = append(payload, '\n')
+	if lsn > 0 {
+		payload = append(payload, snapshotLSNPrefix+strconv.FormatUint(lsn, 10)+"\n"...)
+	}
+
+	return append(payload, fmt.Sprintf("%s%08x\n", snapshotChecksumPrefix, crc32.Checksum(payload, crc32c))...), nil
+}
+
+// decodeSnapshot проверяет контрольную сумму снимка и декодирует метрики и номер записи WAL.
+// Снимки без контрольной суммы, записанные предыдущими версиями, читаются как JSON без проверки
+func decodeSnapshot(raw []byte) (metrics.Metrics, uint64, error) {
+	if len(bytes.TrimSpace(raw)) == 0 {
+		return nil, 0, errEmptySnapshot
+	}
+
+	var data metrics.Metrics
+	footerStart := lastLineStart(raw)
+	footer := bytes.TrimSuffix(raw[footerStart:], []byte("\n"))
+	if !bytes.HasPrefix(footer, []byte(snapshotChecksumPrefix)) {
+		err := json.NewDecoder(bytes.NewReader(raw)).Decode(&data)
+		return data, 0, err
+	}
+
+	want, err := strconv.ParseUint(string(footer[len(snapshotChecksumPrefix):]), 16, 32)
+	if err != nil {
+		return nil, 0, fmt.Errorf("bad snapshot checksum: %w", err)
+	}
+	payload := raw[:footerStart]
+	if got := crc32.Checksum(payload, crc32c); got != uint32(want) {
+		return nil, 0, fmt.Errorf("snapshot checksum mismatch: %08x != %08x", got, want)
+	}
+
+	var lsn uint64
+	lsnStart := lastLineStart(payload)
+	if line := bytes.TrimSuffix(payload[lsnStart:], []byte("\n")); bytes.HasPrefix(line, []byte(snapshotLSNPrefix)) {
+		if lsn, err = strconv.ParseUint(string(line[len(snapshotLSNPrefix):]), 10, 64); err != nil {
+			return nil, 0, fmt.Errorf("bad snapshot WAL position: %w", err)
+		}
+		payload = payload[:lsnStart]
+	}
+	err = json.Unmarshal(payload, &data)
+
+	return data, lsn, err
+}
+
+// lastLineStart выдает начало последней строки без учета завершающего перевода строки
+func lastLineStart(raw []byte) int {
+	return bytes.LastIndexByte(bytes.TrimSuffix(raw, []byte("\n")), '\n') + 1
+}
+
+// writeFileAtomic записывает файл через временный файл, fsync и rename, чтобы при сбое
+// на диске оставалась либо старая, либо новая версия целиком. Старая версия сохраняется в prev
+func writeFileAtomic(name string, prev string, data []byte) error {
+	tmp := name + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(name, prev); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		return err
+	}
+
+	return syncDir(filepath.Dir(name))
+}
+
+// syncDir сбрасывает на диск изменения каталога, чтобы переименование пережило сбой
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// StalenessPolicy описывает, через какое время без обновлений серия считается устаревшей
+type StalenessPolicy struct {
+	// TTL время жизни серии без обновлений, 0 - серии не устаревают
+	TTL time.Duration
+	// Overrides переопределения TTL для типов метрик и префиксов имен
+	Overrides []StalenessOverride
+}
+
+// StalenessOverride переопределяет TTL для серий заданного типа и/или с заданным префиксом имени.
+// Если под серию подходит несколько правил, выбирается правило с самым длинным префиксом,
+// при равных префиксах - правило с типом
+type StalenessOverride struct {
+	Type   string
+	Prefix string
+	// TTL время жизни серии без обновлений, 0 - серии не устаревают
+	TTL time.Duration
+}
+
+func (o StalenessOverride) matches(metricType string, name string) bool {
+	return (o.Type == "" || o.Type == metricType) && strings.HasPrefix(name, o.Prefix)
+}
+
+// IsEnabled проверяет, что по политике могут устаревать хоть какие-то серии
+func (p StalenessPolicy) IsEnabled() bool {
+	if p.TTL > 0 {
+		return true
+	}
+	for _, o := range p.Overrides {
+		if o.TTL > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// TTLFor выдает время жизни серии метрики
+func (p StalenessPolicy) TTLFor(metricType string, name string) time.Duration {
+	for _, o := range p.rules() {
+		if o.matches(metricType, name) {
+			return o.TTL
+		}
+	}
+
+	return p.TTL
+}
+
+// rules выдает переопределения от более специфичных к менее специфичным
+func (p StalenessPolicy) rules() []StalenessOverride {
+	rules := append([]StalenessOverride(nil), p.Overrides...)
+	sort.SliceStable(rules, func(i, j int) bool {
+		if len(rules[i].Prefix) != len(rules[j].Prefix) {
+			return len(rules[i].Prefix) > len(rules[j].Prefix)
+		}
+		return rules[i].Type != "" && rules[j].Type == ""
+	})
+
+	return rules
+}
@@ -0,0 +1,41 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/vleukhin/prom-light/internal/metrics"
+)
+
+func TestStalenessPolicy_TTLFor(t *testing.T) {
+	policy := StalenessPolicy{
+		TTL: time.Minute,
+		Overrides: []StalenessOverride{
+			{Prefix: "CPU", TTL: 2 * time.Minute},
+			{Type: metrics.CounterTypeName, TTL: 0},
+			{Prefix: "CPUUtilization", TTL: 3 * time.Minute},
+			{Type: metrics.GaugeTypeName, Prefix: "CPU", TTL: 4 * time.Minute},
+		},
+	}
+
+	tests := []struct {
+		metricType string
+		name       string
+		want       time.Duration
+	}{
+		{metricType: metrics.GaugeTypeName, name: "Alloc", want: time.Minute},
+		{metricType: metrics.CounterTypeName, name: "PollCount", want: 0},
+		{metricType: metrics.CounterTypeName, name: "CPUCount", want: 2 * time.Minute},
+		{metricType: metrics.GaugeTypeName, name: "CPUCount", want: 4 * time.Minute},
+		{metricType: metrics.GaugeTypeName, name: "CPUUtilization1", want: 3 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := policy.TTLFor(tt.metricType, tt.name); got != tt.want {
+			t.Errorf("TTLFor(%s, %s) = %v; want %v", tt.metricType, tt.name, got, tt.want)
+		}
+	}
+
+	if (StalenessPolicy{Overrides: []StalenessOverride{{Prefix: "CPU"}}}).IsEnabled() {
+		t.Errorf("IsEnabled() policy without TTL is enabled")
+	}
+}
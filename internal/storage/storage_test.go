@@ -2,9 +2,12 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"reflect"
 	"sort"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/vleukhin/prom-light/internal/metrics"
 )
@@ -29,7 +32,7 @@ func testStorage(storage MetricsStorage, t *testing.T) {
 			}
 
 			if m.IsCounter() {
-				stored, err := storage.GetCounter(ctx, m.Name)
+				stored, err := storage.GetCounter(ctx, m.Name, m.Labels)
 				if err != nil {
 					t.Errorf("GetCounter() error = %v", err)
 					return
@@ -39,7 +42,7 @@ func testStorage(storage MetricsStorage, t *testing.T) {
 					return
 				}
 			} else {
-				stored, err := storage.GetGauge(ctx, m.Name)
+				stored, err := storage.GetGauge(ctx, m.Name, m.Labels)
 				if err != nil {
 					t.Errorf("GetGauge() error = %v", err)
 					return
@@ -80,14 +83,14 @@ func testStorage(storage MetricsStorage, t *testing.T) {
 
 	t.Run("Inc counter", func(t *testing.T) {
 		name := "counter"
-		if err := storage.IncCounter(ctx, name, 10); err != nil {
+		if err := storage.IncCounter(ctx, name, nil, 10); err != nil {
 			t.Errorf("IncCounter() error = %v", err)
 		}
-		if err := storage.IncCounter(ctx, name, 15); err != nil {
+		if err := storage.IncCounter(ctx, name, nil, 15); err != nil {
 			t.Errorf("IncCounter() error = %v", err)
 		}
 
-		stored, err := storage.GetCounter(ctx, name)
+		stored, err := storage.GetCounter(ctx, name, nil)
 		if err != nil {
 			t.Errorf("GetCounter() error = %v", err)
 		}
@@ -97,4 +100,422 @@ func testStorage(storage MetricsStorage, t *testing.T) {
 			return
 		}
 	})
+	_ = storage.CleanUp(ctx)
+
+	t.Run("Labelled series", func(t *testing.T) {
+		hostA := metrics.Labels{"host": "a"}
+		hostB := metrics.Labels{"host": "b"}
+		if err := storage.IncCounter(ctx, "requests", hostA, 10); err != nil {
+			t.Errorf("IncCounter() error = %v", err)
+		}
+		if err := storage.IncCounter(ctx, "requests", hostB, 3); err != nil {
+			t.Errorf("IncCounter() error = %v", err)
+		}
+
+		stored, err := storage.GetCounter(ctx, "requests", hostA)
+		if err != nil {
+			t.Errorf("GetCounter() error = %v", err)
+		}
+		if stored != 10 {
+			t.Errorf("GetCounter() wrong value = %v; want %v", stored, 10)
+		}
+
+		stored, err = storage.GetCounter(ctx, "requests", hostB)
+		if err != nil {
+			t.Errorf("GetCounter() error = %v", err)
+		}
+		if stored != 3 {
+			t.Errorf("GetCounter() wrong value = %v; want %v", stored, 3)
+		}
+
+		if _, err := storage.GetCounter(ctx, "requests", nil); !errors.Is(err, ErrMetricNotFound) {
+			t.Errorf("GetCounter() error for unlabelled series = %v; want %v", err, ErrMetricNotFound)
+		}
+		if _, err := storage.GetGauge(ctx, "requests", hostA); !errors.Is(err, ErrMetricNotFound) {
+			t.Errorf("GetGauge() error for counter series = %v; want %v", err, ErrMetricNotFound)
+		}
+
+		all, err := storage.GetAllMetrics(ctx)
+		if err != nil {
+			t.Errorf("GetAllMetrics() error = %v", err)
+		}
+		if len(all) != 2 {
+			t.Errorf("GetAllMetrics() wrong series count = %v; want %v", len(all), 2)
+		}
+	})
+	_ = storage.CleanUp(ctx)
+
+	t.Run("Histogram and summary", func(t *testing.T) {
+		h := metrics.NewHistogram([]float64{0.1, 1})
+		h.Observe(0.05, 1)
+		h.Observe(0.5, 1)
+		if err := storage.SetMetric(ctx, metrics.MakeHistogramMetric("latency", h)); err != nil {
+			t.Errorf("SetMetric() error = %v", err)
+			return
+		}
+		h = metrics.NewHistogram([]float64{0.1, 1})
+		h.Observe(5, 1)
+		if err := storage.SetMetrics(ctx, metrics.Metrics{metrics.MakeHistogramMetric("latency", h)}); err != nil {
+			t.Errorf("SetMetrics() error = %v", err)
+			return
+		}
+
+		storedHistogram, err := storage.GetHistogram(ctx, "latency", nil)
+		if err != nil {
+			t.Errorf("GetHistogram() error = %v", err)
+			return
+		}
+		wantHistogram := metrics.Histogram{
+			Buckets: []metrics.Bucket{{UpperBound: 0.1, Count: 1}, {UpperBound: 1, Count: 2}},
+			Sum:     5.55,
+			Count:   3,
+		}
+		if !reflect.DeepEqual(storedHistogram, wantHistogram) {
+			t.Errorf("GetHistogram() wrong value = %v; want merged %v", storedHistogram, wantHistogram)
+		}
+
+		bad := metrics.MakeHistogramMetric("latency", metrics.Histogram{Buckets: []metrics.Bucket{{UpperBound: 1, Count: 5}}, Count: 1})
+		if err := storage.SetMetric(ctx, bad); err == nil {
+			t.Errorf("SetMetric() expected error for invalid histogram")
+		}
+
+		for _, v := range []float64{1, 2} {
+			summary := metrics.Summary{Quantiles: []metrics.Quantile{{Quantile: 0.5, Value: v}}, Sum: v, Count: 1}
+			if err := storage.SetMetric(ctx, metrics.MakeSummaryMetric("duration", summary)); err != nil {
+				t.Errorf("SetMetric() error = %v", err)
+				return
+			}
+		}
+		storedSummary, err := storage.GetSummary(ctx, "duration", nil)
+		if err != nil {
+			t.Errorf("GetSummary() error = %v", err)
+			return
+		}
+		if storedSummary.Sum != 2 || storedSummary.Quantiles[0].Value != 2 {
+			t.Errorf("GetSummary() wrong value = %v; want last reported", storedSummary)
+		}
+
+		all, err := storage.GetAllMetrics(ctx)
+		if err != nil {
+			t.Errorf("GetAllMetrics() error = %v", err)
+		}
+		if len(all) != 2 {
+			t.Errorf("GetAllMetrics() wrong series count = %v; want %v", len(all), 2)
+		}
+	})
+	_ = storage.CleanUp(ctx)
+
+	t.Run("Concurrent histogram", func(t *testing.T) {
+		const writers = 10
+		var wg sync.WaitGroup
+		for i := 0; i < writers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				h := metrics.NewHistogram([]float64{1})
+				h.Observe(0.5, 1)
+				if err := storage.SetMetric(ctx, metrics.MakeHistogramMetric("requests", h)); err != nil {
+					t.Errorf("SetMetric() error = %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		stored, err := storage.GetHistogram(ctx, "requests", nil)
+		if err != nil {
+			t.Errorf("GetHistogram() error = %v", err)
+			return
+		}
+		if stored.Count != writers || stored.Buckets[0].Count != writers {
+			t.Errorf("GetHistogram() wrong value = %v; want %d observations", stored, writers)
+		}
+	})
+	_ = storage.CleanUp(ctx)
+
+	t.Run("Series of different types", func(t *testing.T) {
+		if err := storage.SetMetrics(ctx, metrics.Metrics{
+			metrics.MakeGaugeMetric("Mixed", 5.5),
+			metrics.MakeCounterMetric("Mixed", 3),
+		}); err != nil {
+			t.Errorf("SetMetrics() error = %v", err)
+			return
+		}
+		if err := storage.IncCounter(ctx, "Mixed", nil, 2); err != nil {
+			t.Errorf("IncCounter() error = %v", err)
+		}
+
+		if gauge, err := storage.GetGauge(ctx, "Mixed", nil); err != nil || gauge != 5.5 {
+			t.Errorf("GetGauge() = %v, %v; want %v", gauge, err, 5.5)
+		}
+		if counter, err := storage.GetCounter(ctx, "Mixed", nil); err != nil || counter != 5 {
+			t.Errorf("GetCounter() = %v, %v; want %v", counter, err, 5)
+		}
+	})
+	_ = storage.CleanUp(ctx)
+
+	t.Run("History", func(t *testing.T) {
+		from := time.Now().Add(-time.Minute)
+		for _, v := range []metrics.Gauge{1, 2, 3} {
+			if err := storage.SetMetric(ctx, metrics.MakeGaugeMetric("HeapAlloc", v)); err != nil {
+				t.Errorf("SetMetric() error = %v", err)
+				return
+			}
+		}
+		if err := storage.IncCounter(ctx, "PollCount", nil, 2); err != nil {
+			t.Errorf("IncCounter() error = %v", err)
+		}
+		if err := storage.IncCounter(ctx, "PollCount", nil, 3); err != nil {
+			t.Errorf("IncCounter() error = %v", err)
+		}
+
+		gauges, err := storage.GetRange(ctx, "HeapAlloc", from, time.Now().Add(time.Minute))
+		if err != nil {
+			t.Errorf("GetRange() error = %v", err)
+			return
+		}
+		if len(gauges) != 3 {
+			t.Errorf("GetRange() wrong samples count = %v; want %v", len(gauges), 3)
+			return
+		}
+		for i, want := range []metrics.Gauge{1, 2, 3} {
+			if *gauges[i].Value != want || gauges[i].Timestamp == nil {
+				t.Errorf("GetRange() wrong sample = %v; want value %v with timestamp", gauges[i], want)
+			}
+		}
+
+		counters, err := storage.GetRange(ctx, "PollCount", from, time.Now().Add(time.Minute))
+		if err != nil {
+			t.Errorf("GetRange() error = %v", err)
+			return
+		}
+		if len(counters) != 2 || *counters[1].Delta != 5 {
+			t.Errorf("GetRange() wrong counter history = %v; want running totals", counters)
+		}
+
+		empty, err := storage.GetRange(ctx, "HeapAlloc", from.Add(-time.Hour), from)
+		if err != nil {
+			t.Errorf("GetRange() error = %v", err)
+		}
+		if len(empty) != 0 {
+			t.Errorf("GetRange() wrong samples count = %v; want %v", len(empty), 0)
+		}
+	})
+	_ = storage.CleanUp(ctx)
+
+	t.Run("Query", func(t *testing.T) {
+		series := metrics.Metrics{
+			metrics.MakeGaugeMetric("HeapAlloc", 1),
+			metrics.MakeGaugeMetric("HeapInuse", 2),
+			metrics.MakeGaugeMetric("StackInuse", 3),
+			metrics.MakeCounterMetric("HeapObjects", 4),
+		}
+		edge := metrics.MakeGaugeMetric("HeapSys", 5)
+		edge.Labels = metrics.Labels{"agent": "edge-01"}
+		series = append(series, edge)
+		if err := storage.SetMetrics(ctx, series); err != nil {
+			t.Errorf("SetMetrics() error = %v", err)
+			return
+		}
+
+		names := func(page MetricsPage) []string {
+			result := make([]string, 0, len(page.Metrics))
+			for _, m := range page.Metrics {
+				result = append(result, m.ID())
+			}
+			return result
+		}
+
+		tests := []struct {
+			name  string
+			query MetricsQuery
+			want  []string
+		}{
+			{
+				name:  "All metrics sorted by name",
+				query: MetricsQuery{},
+				want:  []string{"HeapAlloc", "HeapInuse", "HeapObjects", `HeapSys{agent="edge-01"}`, "StackInuse"},
+			},
+			{
+				name:  "Filter by type and prefix",
+				query: MetricsQuery{Matcher: Matcher{Types: []string{metrics.GaugeTypeName}, NamePrefix: "Heap"}},
+				want:  []string{"HeapAlloc", "HeapInuse", `HeapSys{agent="edge-01"}`},
+			},
+			{
+				name:  "Filter by regex",
+				query: MetricsQuery{Matcher: Matcher{NameRegex: "Inuse$"}, Desc: true},
+				want:  []string{"StackInuse", "HeapInuse"},
+			},
+			{
+				// Синтаксис RE2, которого нет в Postgres
+				name:  "Filter by RE2 regex",
+				query: MetricsQuery{Matcher: Matcher{NameRegex: `^(?P<area>Heap|Stack)Inuse$`}},
+				want:  []string{"HeapInuse", "StackInuse"},
+			},
+			{
+				name:  "Filter by labels",
+				query: MetricsQuery{Matcher: Matcher{Labels: metrics.Labels{"agent": "edge-01"}}},
+				want:  []string{`HeapSys{agent="edge-01"}`},
+			},
+			{
+				name:  "Sort by type",
+				query: MetricsQuery{Matcher: Matcher{NamePrefix: "Heap"}, SortBy: SortByType},
+				want:  []string{"HeapObjects", "HeapAlloc", "HeapInuse", `HeapSys{agent="edge-01"}`},
+			},
+		}
+		for _, tt := range tests {
+			page, err := storage.QueryMetrics(ctx, tt.query)
+			if err != nil {
+				t.Errorf("QueryMetrics() %s error = %v", tt.name, err)
+				continue
+			}
+			if got := names(page); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("QueryMetrics() %s = %v; want %v", tt.name, got, tt.want)
+			}
+			if page.NextCursor != "" {
+				t.Errorf("QueryMetrics() %s unexpected cursor on the last page", tt.name)
+			}
+		}
+
+		var got []string
+		q := MetricsQuery{Limit: 2, Desc: true}
+		for pages := 0; pages < 5; pages++ {
+			page, err := storage.QueryMetrics(ctx, q)
+			if err != nil {
+				t.Errorf("QueryMetrics() error = %v", err)
+				return
+			}
+			got = append(got, names(page)...)
+			if page.NextCursor == "" {
+				break
+			}
+			q.Cursor = page.NextCursor
+		}
+		want := []string{"StackInuse", `HeapSys{agent="edge-01"}`, "HeapObjects", "HeapInuse", "HeapAlloc"}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("QueryMetrics() paginated = %v; want %v", got, want)
+		}
+
+		page, err := storage.QueryMetrics(ctx, MetricsQuery{Matcher: Matcher{NameRegex: "Inuse$"}, Limit: 1})
+		if err != nil {
+			t.Errorf("QueryMetrics() error = %v", err)
+			return
+		}
+		if got := names(page); !reflect.DeepEqual(got, []string{"HeapInuse"}) || page.NextCursor == "" {
+			t.Errorf("QueryMetrics() regex first page = %v, cursor %q; want [HeapInuse] with cursor", got, page.NextCursor)
+		}
+
+		for _, bad := range []MetricsQuery{{SortBy: "value"}, {Matcher: Matcher{NameRegex: "("}}, {Cursor: "%%%"}, {Limit: -1}} {
+			if _, err := storage.QueryMetrics(ctx, bad); !errors.Is(err, ErrInvalidQuery) {
+				t.Errorf("QueryMetrics() error = %v; want %v", err, ErrInvalidQuery)
+			}
+		}
+	})
+	_ = storage.CleanUp(ctx)
+
+	t.Run("Delete", func(t *testing.T) {
+		from := time.Now().Add(-time.Minute)
+		edge := metrics.MakeGaugeMetric("CPUUtilization1", 10)
+		edge.Labels = metrics.Labels{"host": "edge-01"}
+		series := metrics.Metrics{
+			metrics.MakeGaugeMetric("CPUUtilization1", 5),
+			edge,
+			metrics.MakeGaugeMetric("CPUUtilization2", 7),
+			metrics.MakeCounterMetric("CPUUtilization1", 1),
+			metrics.MakeGaugeMetric("Alloc", 1),
+		}
+		if err := storage.SetMetrics(ctx, series); err != nil {
+			t.Errorf("SetMetrics() error = %v", err)
+			return
+		}
+
+		if err := storage.DeleteMetric(ctx, metrics.GaugeTypeName, "CPUUtilization1"); err != nil {
+			t.Errorf("DeleteMetric() error = %v", err)
+			return
+		}
+		if _, err := storage.GetGauge(ctx, "CPUUtilization1", edge.Labels); err == nil {
+			t.Errorf("GetGauge() deleted series is still stored")
+		}
+		if _, err := storage.GetCounter(ctx, "CPUUtilization1", nil); err != nil {
+			t.Errorf("GetCounter() counter with the same name was deleted: %v", err)
+		}
+		history, err := storage.GetRange(ctx, "CPUUtilization1", from, time.Now().Add(time.Minute))
+		if err != nil {
+			t.Errorf("GetRange() error = %v", err)
+		}
+		if len(history) != 1 || history[0].Type != metrics.CounterTypeName {
+			t.Errorf("GetRange() wrong history after delete = %v; want counter only", history)
+		}
+		if err := storage.DeleteMetric(ctx, metrics.GaugeTypeName, "CPUUtilization1"); !errors.Is(err, ErrMetricNotFound) {
+			t.Errorf("DeleteMetric() error = %v; want %v", err, ErrMetricNotFound)
+		}
+
+		deleted, err := storage.DeleteByMatcher(ctx, Matcher{NamePrefix: "CPU", NameRegex: `(?P<n>\d)$`})
+		if err != nil {
+			t.Errorf("DeleteByMatcher() error = %v", err)
+		}
+		if deleted != 2 {
+			t.Errorf("DeleteByMatcher() deleted = %v; want %v", deleted, 2)
+		}
+		all, err := storage.GetAllMetrics(ctx)
+		if err != nil {
+			t.Errorf("GetAllMetrics() error = %v", err)
+		}
+		if len(all) != 1 || all[0].Name != "Alloc" {
+			t.Errorf("GetAllMetrics() wrong metrics after delete = %v", all)
+		}
+
+		if _, err := storage.DeleteByMatcher(ctx, Matcher{}); !errors.Is(err, ErrEmptyMatcher) {
+			t.Errorf("DeleteByMatcher() error = %v; want %v", err, ErrEmptyMatcher)
+		}
+	})
+	_ = storage.CleanUp(ctx)
+
+	t.Run("Stale series", func(t *testing.T) {
+		series := metrics.Metrics{
+			metrics.MakeGaugeMetric("CPUUtilization1", 5),
+			metrics.MakeGaugeMetric("Alloc", 1),
+			metrics.MakeCounterMetric("PollCount", 1),
+		}
+		if err := storage.SetMetrics(ctx, series); err != nil {
+			t.Errorf("SetMetrics() error = %v", err)
+			return
+		}
+		policy := StalenessPolicy{
+			TTL: time.Minute,
+			Overrides: []StalenessOverride{
+				{Type: metrics.CounterTypeName, TTL: 0},
+				{Prefix: "CPU", TTL: time.Hour},
+			},
+		}
+
+		deleted, err := storage.DeleteStale(ctx, policy, time.Now())
+		if err != nil {
+			t.Errorf("DeleteStale() error = %v", err)
+		}
+		if deleted != 0 {
+			t.Errorf("DeleteStale() deleted fresh series = %v", deleted)
+		}
+
+		deleted, err = storage.DeleteStale(ctx, policy, time.Now().Add(10*time.Minute))
+		if err != nil {
+			t.Errorf("DeleteStale() error = %v", err)
+		}
+		if deleted != 1 {
+			t.Errorf("DeleteStale() deleted = %v; want %v", deleted, 1)
+		}
+		if _, err := storage.GetGauge(ctx, "Alloc", nil); err == nil {
+			t.Errorf("GetGauge() stale series is still stored")
+		}
+
+		deleted, err = storage.DeleteStale(ctx, policy, time.Now().Add(2*time.Hour))
+		if err != nil {
+			t.Errorf("DeleteStale() error = %v", err)
+		}
+		if deleted != 1 {
+			t.Errorf("DeleteStale() deleted = %v; want %v", deleted, 1)
+		}
+		if _, err := storage.GetCounter(ctx, "PollCount", nil); err != nil {
+			t.Errorf("GetCounter() series without TTL was deleted: %v", err)
+		}
+	})
 }
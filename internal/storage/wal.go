@@ -0,0 +1,217 @@
+package storage
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/vleukhin/prom-light/internal/metrics"
+)
+
+// Запись журнала упреждающей записи (WAL):
+//
+//	длина данных uint32 | CRC-32C номера и данных uint32 | номер записи uint64 | данные
+//
+// Числа записываются в big endian, данные - walEntry в формате JSON
+const walHeaderSize = 16
+
+// Операции в журнале
+const (
+	walOpSet    = "set"
+	walOpDelete = "delete"
+)
+
+// walEntry изменение хранилища. Для walOpSet Metrics - сохраненные метрики,
+// для walOpDelete - удаленные серии
+type walEntry struct {
+	Op      string          `json:"op"`
+	At      time.Time       `json:"at"`
+	Metrics metrics.Metrics `json:"metrics"`
+}
+
+// walLog журнал изменений хранилища, дописываемый в конец файла
+type walLog struct {
+	file *os.File
+	// lsn номер последней записи. Номера растут и не сбрасываются при очистке журнала,
+	// чтобы по номеру в снимке можно было пропустить уже попавшие в него записи
+	lsn  uint64
+	size int64
+}
+
+func openWAL(name string) (*walLog, error) {
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	return &walLog{file: f, size: info.Size()}, nil
+}
+
+// replay применяет записи журнала с номерами больше fromLSN: сначала из предыдущего сегмента,
+// затем из текущего. Недописанный или поврежденный хвост текущего сегмента, например после
+// сбоя во время записи, отбрасывается. Если записи идут с пропуском номеров, часть изменений
+// потеряна и восстановление прерывается с ошибкой
+func (w *walLog) replay(fromLSN uint64, apply func(e walEntry) error) error {
+	if w.lsn < fromLSN {
+		w.lsn = fromLSN
+	}
+	next := fromLSN + 1
+
+	prev, err := os.ReadFile(w.prevName())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if _, err := w.replaySegment(prev, &next, apply); err != nil {
+		return err
+	}
+
+	raw, err := os.ReadFile(w.file.Name())
+	if err != nil {
+		return err
+	}
+	offset, err := w.replaySegment(raw, &next, apply)
+	if err != nil {
+		return err
+	}
+
+	if offset < len(raw) {
+		if err := w.file.Truncate(int64(offset)); err != nil {
+			return err
+		}
+	}
+	w.size = int64(offset)
+
+	return nil
+}
+
+// replaySegment применяет записи сегмента с номером next и следующими и выдает размер
+// неповрежденной части сегмента. Записи с меньшими номерами уже есть в снимке или применены
+func (w *walLog) replaySegment(raw []byte, next *uint64, apply func(e walEntry) error) (int, error) {
+	offset := 0
+	for offset < len(raw) {
+		lsn, e, n, err := decodeWALRecord(raw[offset:])
+		if err != nil {
+			log.Warn().Err(err).Int("offset", offset).Msg("Discarding damaged WAL tail")
+			break
+		}
+		offset += n
+		if lsn > w.lsn {
+			w.lsn = lsn
+		}
+		if lsn < *next {
+			continue
+		}
+		if lsn > *next {
+			return offset, fmt.Errorf("WAL records %d-%d are missing", *next, lsn-1)
+		}
+		if err := apply(e); err != nil {
+			return offset, err
+		}
+		*next++
+	}
+
+	return offset, nil
+}
+
+// append дописывает запись в журнал и сбрасывает ее на диск
+func (w *walLog) append(e walEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	record := make([]byte, walHeaderSize+len(data))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(data)))
+	binary.BigEndian.PutUint64(record[8:16], w.lsn+1)
+	copy(record[walHeaderSize:], data)
+	binary.BigEndian.PutUint32(record[4:8], crc32.Checksum(record[8:], crc32c))
+
+	if _, err := w.file.Write(record); err != nil {
+		// Недописанная запись отрезается, чтобы за ней можно было продолжить журнал
+		_ = w.file.Truncate(w.size)
+		return err
+	}
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+	w.lsn++
+	w.size += int64(len(record))
+
+	return nil
+}
+
+// rotate начинает новый сегмент журнала после контрольной точки. Текущий сегмент становится
+// предыдущим и заменяет старый: его записи нужны, если снимок контрольной точки окажется
+// поврежден и данные придется восстанавливать из предыдущего снимка
+func (w *walLog) rotate() error {
+	name := w.file.Name()
+	if err := os.Rename(name, w.prevName()); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_APPEND|os.O_TRUNC, 0644)
+	if err != nil {
+		// Записи продолжат дописываться в прежний сегмент под его старым именем
+		_ = os.Rename(w.prevName(), name)
+		return err
+	}
+	_ = w.file.Close()
+	w.file = f
+	w.size = 0
+
+	return syncDir(filepath.Dir(name))
+}
+
+// reset очищает журнал вместе с предыдущим сегментом, когда его записи больше не нужны
+func (w *walLog) reset() error {
+	if err := os.Remove(w.prevName()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := w.file.Truncate(0); err != nil {
+		return err
+	}
+	w.size = 0
+
+	return w.file.Sync()
+}
+
+// prevName файл предыдущего сегмента журнала
+func (w *walLog) prevName() string {
+	return w.file.Name() + ".prev"
+}
+
+func (w *walLog) close() error {
+	return w.file.Close()
+}
+
+var errWALRecordTruncated = errors.New("truncated WAL record")
+
+// decodeWALRecord читает запись из начала raw и выдает ее номер, данные и размер
+func decodeWALRecord(raw []byte) (uint64, walEntry, int, error) {
+	var e walEntry
+	if len(raw) < walHeaderSize {
+		return 0, e, 0, errWALRecordTruncated
+	}
+	size := walHeaderSize + int(binary.BigEndian.Uint32(raw[0:4]))
+	if len(raw) < size {
+		return 0, e, 0, errWALRecordTruncated
+	}
+	if crc32.Checksum(raw[8:size], crc32c) != binary.BigEndian.Uint32(raw[4:8]) {
+		return 0, e, 0, errors.New("WAL record checksum mismatch")
+	}
+	if err := json.Unmarshal(raw[walHeaderSize:size], &e); err != nil {
+		return 0, e, 0, err
+	}
+
+	return binary.BigEndian.Uint64(raw[8:16]), e, size, nil
+}